@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// batchForbidden are the flags naming a single file, which the concurrent runs would overwrite,
+// and --watch, which would never let them finish.
+var batchForbidden = []string{
+	"input", "input_top", "input_bottom", "checkpoint", "output_stl", "output_dxf", "output_hpgl", "output_camotics",
+	"output_gerber", "output_pdf", "output_svg", "volume_report", "release_report", "report", "cpuprofile", "memprofile", "pprof_addr", "watch",
+}
+
+// batchResult is the outcome of a single input of a batch.
+type batchResult struct {
+	Input   string
+	Code    int
+	Summary runSummary
+	Elapsed time.Duration
+}
+
+// runBatch implements the batch subcommand: every input is converted by a separate run of this
+// program with the same flags, several of them at a time, and a combined report is printed.
+// The runs share the --jobs workers: each of the --batch_size concurrent runs gets its part.
+// The G-code of a.png goes to a.nc in the --output directory (or next to the input, if not set),
+// or to the file the --output template makes for it.
+// The debug images would be overwritten by the concurrent runs, so they are off.
+// It returns the most severe exit code of the runs.
+func runBatch(args []string) (int, error) {
+	if err := parseFlags(args); err != nil {
+		return 0, err
+	}
+	if err := setupLogger(*logLevel, *logFormat); err != nil {
+		return 0, err
+	}
+	inputs := flag.Args()
+	if len(inputs) == 0 {
+		return 0, errorf(exitBadFlags, "no inputs given to batch")
+	}
+	var forward []string
+	var forbidden error
+	flag.Visit(func(f *flag.Flag) {
+		for _, name := range batchForbidden {
+			if f.Name == name && forbidden == nil {
+				forbidden = errorf(exitBadFlags, "--%s is not supported in batch", name)
+			}
+		}
+		if f.Name != "output" && f.Name != "jobs" && f.Name != "batch_size" {
+			forward = append(forward, fmt.Sprintf("--%s=%s", f.Name, f.Value))
+		}
+	})
+	if forbidden != nil {
+		return 0, forbidden
+	}
+	if *batchSize < 1 {
+		return 0, errorf(exitBadFlags, "--batch_size must be positive")
+	}
+	self, err := os.Executable()
+	if err != nil {
+		return 0, fmt.Errorf("failed to find the executable: %w", err)
+	}
+	runs := imin(*batchSize, len(inputs))
+	jobsPerRun := imax(*jobs/runs, 1)
+
+	results := make([]batchResult, len(inputs))
+	errs := make([]error, len(inputs))
+	sem := make(chan struct{}, runs)
+	var wg sync.WaitGroup
+	for i, in := range inputs {
+		wg.Add(1)
+		go func(i int, in string) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			results[i], errs[i] = batchRun(self, in, forward, jobsPerRun)
+		}(i, in)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return printResults(results), nil
+}
+
+// printResults prints the table of the runs with the totals, and returns the most severe exit code.
+func printResults(results []batchResult) int {
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "input\toutput\texit\tholes\tpaths\tlines\testimated\telapsed")
+	var holes, paths, lines int
+	var seconds float64
+	worst := exitOK
+	for _, r := range results {
+		s := r.Summary
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%d\t%d\t%d\t%v\t%v\n", r.Input, s.Output, r.Code, s.Holes, s.Paths, s.Lines,
+			time.Duration(s.EstimatedSeconds*float64(time.Second)).Round(time.Second), r.Elapsed.Round(time.Millisecond))
+		holes += s.Holes
+		paths += s.Paths
+		lines += s.Lines
+		seconds += s.EstimatedSeconds
+		worst = worseCode(worst, r.Code)
+	}
+	fmt.Fprintf(tw, "total: %d\t\t%d\t%d\t%d\t%d\t%v\t\n", len(results), worst, holes, paths, lines,
+		time.Duration(seconds*float64(time.Second)).Round(time.Second))
+	tw.Flush()
+	return worst
+}
+
+// batchRun converts a single input of a batch, see runChild.
+func batchRun(self, in string, forward []string, jobs int) (batchResult, error) {
+	out := strings.TrimSuffix(in, filepath.Ext(in)) + ".nc"
+	switch {
+	case isTemplate(*output):
+		// The run expands it for its input.
+		out = *output
+	case *output != "":
+		out = filepath.Join(*output, filepath.Base(out))
+	}
+	args := append([]string{"--input=" + in, "--output=" + out, fmt.Sprintf("--jobs=%d", jobs), "--debug_images=false"}, forward...)
+	return runChild(self, in, args)
+}
+
+// runChild runs this program with the args converting the input. It fails only if the run could
+// not be started; the exit code of the run is in the result.
+func runChild(self, in string, args []string) (batchResult, error) {
+	cmd := exec.Command(self, args...)
+	var stdout bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = os.Stderr
+	start := time.Now()
+	err := cmd.Run()
+	res := batchResult{Input: in, Elapsed: time.Since(start)}
+	if ee, ok := err.(*exec.ExitError); ok {
+		res.Code = ee.ExitCode()
+	} else if err != nil {
+		return res, fmt.Errorf("failed to run %s: %w", self, err)
+	}
+	// The summary is the last line of the output, see printSummary.
+	if lines := strings.Split(strings.TrimSpace(stdout.String()), "\n"); len(lines) > 0 && lines[len(lines)-1] != "" {
+		json.Unmarshal([]byte(lines[len(lines)-1]), &res.Summary)
+	}
+	return res, nil
+}
+
+// worseCode returns the more severe of two exit codes: any failure is worse than any warning.
+func worseCode(a, b int) int {
+	failure := func(c int) bool { return c != exitOK && c < exitSkipped }
+	switch {
+	case failure(a):
+		return a
+	case failure(b):
+		return b
+	}
+	return imax(a, b)
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"image"
+	"math"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/krasin/png2stencil/packer"
+	"github.com/krasin/png2stencil/stencil"
+	"github.com/krasin/png2stencil/stencilimg"
+)
+
+// benchBoard is a synthetic board for the bench subcommand. The pads are drawn in mm.
+type benchBoard struct {
+	name string
+	w, h float64 // board size in mm
+	draw func(b *benchCanvas)
+}
+
+// benchCanvas draws pads into an input mask.
+type benchCanvas struct {
+	m  *stencilimg.BitMask
+	px float64
+}
+
+// rect fills the rectangle with the center (x, y) and the size w*h.
+func (b *benchCanvas) rect(x, y, w, h float64) {
+	b.fill(func(px, py float64) bool { return math.Abs(px-x) <= w/2 && math.Abs(py-y) <= h/2 })
+}
+
+// ring fills the annulus with the center (x, y) between the radii r0 and r1.
+func (b *benchCanvas) ring(x, y, r0, r1 float64) {
+	b.fill(func(px, py float64) bool {
+		d := math.Hypot(px-x, py-y)
+		return d >= r0 && d <= r1
+	})
+}
+
+func (b *benchCanvas) fill(inside func(x, y float64) bool) {
+	r := b.m.Bounds()
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			if inside((float64(x)+0.5)*b.px, (float64(y)+0.5)*b.px) {
+				b.m.Set(x, y)
+			}
+		}
+	}
+}
+
+var benchBoards = []benchBoard{
+	{"passives", 30, 20, func(b *benchCanvas) {
+		// 0805 and 1206 resistor and capacitor footprints.
+		for i := 0; i < 8; i++ {
+			for j := 0; j < 3; j++ {
+				x, y := 2+float64(i)*3.5, 2.5+float64(j)*3
+				b.rect(x-0.95, y, 1.0, 1.3)
+				b.rect(x+0.95, y, 1.0, 1.3)
+			}
+		}
+		for i := 0; i < 7; i++ {
+			x, y := 2.5+float64(i)*4.2, 13.0
+			b.rect(x-1.4, y, 1.2, 1.8)
+			b.rect(x+1.4, y, 1.2, 1.8)
+		}
+	}},
+	{"soic", 40, 20, func(b *benchCanvas) {
+		// Four SOIC-16 footprints.
+		for k := 0; k < 4; k++ {
+			x0 := 2 + float64(k)*10
+			for i := 0; i < 8; i++ {
+				x := x0 + float64(i)*1.27 - 1
+				b.rect(x, 7.3, 0.6, 1.55)
+				b.rect(x, 12.7, 0.6, 1.55)
+			}
+		}
+	}},
+	{"qfp", 20, 20, func(b *benchCanvas) {
+		// QFP-64 with an exposed thermal pad.
+		for i := 0; i < 16; i++ {
+			d := float64(i)*0.5 - 3.75
+			b.rect(10+d, 3.5, 0.3, 1.5)
+			b.rect(10+d, 16.5, 0.3, 1.5)
+			b.rect(3.5, 10+d, 1.5, 0.3)
+			b.rect(16.5, 10+d, 1.5, 0.3)
+		}
+		b.rect(10, 10, 6, 6)
+	}},
+	{"power", 40, 30, func(b *benchCanvas) {
+		// Large and irregular pads: an L-shaped pour, a ring and a long bar.
+		b.rect(8, 8, 12, 6)
+		b.rect(5, 13, 6, 12)
+		b.ring(27, 10, 3, 6)
+		b.rect(20, 25, 30, 3)
+	}},
+}
+
+// benchConfig is a packing configuration compared by the bench subcommand.
+type benchConfig struct {
+	search   string
+	lattices []string
+}
+
+var benchConfigs = []benchConfig{
+	{packer.SearchCoarse, []string{packer.StrategyTriangle, packer.StrategyQuad}},
+	{packer.SearchFull, []string{packer.StrategyTriangle, packer.StrategyQuad}},
+	{packer.SearchFull, []string{packer.StrategyTriangle}},
+	{packer.SearchFull, []string{packer.StrategyQuad}},
+}
+
+// runBench implements the bench subcommand: it packs the synthetic boards (or the --input)
+// with each packing configuration and prints the coverage and the run time, so
+// the performance and quality regressions can be measured between releases. The digest of
+// the circle centers identifies the result; the bench fails if it differs from the one
+// of a single worker.
+func runBench(args []string) error {
+	if err := parseFlags(args); err != nil {
+		return err
+	}
+	if err := setupLogger(*logLevel, *logFormat); err != nil {
+		return err
+	}
+	if math.IsNaN(*pxSize) {
+		*pxSize = 0.05
+	}
+	if math.IsNaN(*toolDiameter) {
+		*toolDiameter = 0.4
+	}
+	boards := benchBoards
+	if *input != "" {
+		boards = []benchBoard{{name: *input}}
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "board\tsearch\tlattices\tapertures\tcircles\tskipped\tcoverage\ttime\tdigest\t")
+	var nondet []string
+	for _, b := range boards {
+		var src *stencilimg.BitMask
+		if b.draw != nil {
+			src = stencilimg.NewBitMask(image.Rect(0, 0, int(b.w / *pxSize), int(b.h / *pxSize)))
+			b.draw(&benchCanvas{src, *pxSize})
+		} else {
+			bk, err := backgroundColor()
+			if err != nil {
+				return err
+			}
+			in, err := loadPNG(*input)
+			if err != nil {
+				return err
+			}
+			src = stencilimg.Threshold(in, bk)
+		}
+		for _, cfg := range benchConfigs {
+			p := packParams()
+			p.Search = cfg.search
+			p.Strategies = cfg.lattices
+			name := strings.Join(cfg.lattices, "+")
+			start := time.Now()
+			st, digest := benchPack(src, p, *jobs)
+			elapsed := time.Since(start)
+			// The packing must not depend on the number of workers.
+			if *jobs > 1 {
+				if _, seq := benchPack(src, p, 1); seq != digest {
+					nondet = append(nondet, fmt.Sprintf("%s %s %s", b.name, cfg.search, name))
+				}
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%d\t%d\t%.1f%%\t%v\t%s\t\n", b.name, cfg.search, name,
+				len(st.Apertures), st.Circles(), len(st.Skipped()), 100*st.Coverage(), elapsed.Round(time.Millisecond), digest[:8])
+		}
+	}
+	tw.Flush()
+	if len(nondet) > 0 {
+		return fmt.Errorf("the packing depends on --jobs for: %s", strings.Join(nondet, ", "))
+	}
+	return nil
+}
+
+// benchPack packs all components of the input mask with the given parameters and number of workers,
+// and returns the statistics and the digest of all circle centers.
+func benchPack(src *stencilimg.BitMask, params *packer.Params, jobs int) (*stencil.Stats, string) {
+	comps := packer.Segment(src, params)
+	var st stencil.Stats
+	st.Apertures = make([]stencil.Aperture, len(comps))
+	packings, _ := packer.PackAll(comps, jobs, nil, nil, func(c *packer.Component, p packer.Packing, elapsed time.Duration) {
+		st.Apertures[c.ID] = stencil.NewAperture(c, p)
+	})
+	h := sha256.New()
+	for _, p := range packings {
+		fmt.Fprintf(h, "%s:", p.Strategy)
+		for _, c := range p.Centers {
+			binary.Write(h, binary.LittleEndian, [2]float64{c.X, c.Y})
+		}
+	}
+	return &st, hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// maxCachedPackings is how many packings the cache keeps; the least recently saved ones over it
+// are removed.
+const maxCachedPackings = 100
+
+// defaultCacheDir returns the directory for the packing cache in the user cache directory,
+// or an empty string (no cache) if there is none.
+func defaultCacheDir() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "png2stencil")
+}
+
+// openCache returns the cached packing for the key, which is the checkpoint of a successful run:
+// the G-code can be regenerated with any machining parameters (feed rates, heights, mode)
+// without solving the packing again. If there is no cached packing, an empty one is returned.
+// The run doesn't need the cache, so the callers only warn about the errors.
+func openCache(key string) (*checkpoint, error) {
+	if err := os.MkdirAll(*cacheDir, 0755); err != nil {
+		return nil, errorf(exitWriteFailed, "failed to create cache directory: %w", err)
+	}
+	name := filepath.Join(*cacheDir, key+".json")
+	cache := &checkpoint{Key: key, Components: make(map[int]solvedComponent), name: name, saved: time.Now()}
+	old, err := readCheckpoint(name, key)
+	if err != nil {
+		return nil, err
+	}
+	if old != nil {
+		slog.Info("Using cached packing", "file", name, "components", len(old))
+		cache.Components = old
+	}
+	return cache, nil
+}
+
+// saveCache saves the cache, only warning if it fails, and removes the least recently saved
+// packings over maxCachedPackings.
+func saveCache(cache *checkpoint) {
+	if err := cache.save(); err != nil {
+		slog.Warn("Failed to save the packing cache", "err", err)
+		return
+	}
+	names, err := filepath.Glob(filepath.Join(*cacheDir, "*.json"))
+	if err != nil || len(names) <= maxCachedPackings {
+		return
+	}
+	saved := make(map[string]time.Time)
+	for _, name := range names {
+		if fi, err := os.Stat(name); err == nil {
+			saved[name] = fi.ModTime()
+		}
+	}
+	sort.Slice(names, func(i, j int) bool { return saved[names[i]].After(saved[names[j]]) })
+	for _, name := range names[maxCachedPackings:] {
+		if err := os.Remove(name); err != nil && !os.IsNotExist(err) {
+			slog.Warn("Failed to remove a cached packing", "file", name, "err", err)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"path/filepath"
+)
+
+type camoticsTool struct {
+	Units       string  `json:"units"`
+	Shape       string  `json:"shape"`
+	Length      float64 `json:"length"`
+	Diameter    float64 `json:"diameter"`
+	Number      int     `json:"number"`
+	Description string  `json:"description"`
+}
+
+type camoticsWorkpiece struct {
+	Automatic bool    `json:"automatic"`
+	Margin    float64 `json:"margin"`
+}
+
+type camoticsProject struct {
+	Units          string                  `json:"units"`
+	ResolutionMode string                  `json:"resolution-mode"`
+	Tools          map[string]camoticsTool `json:"tools"`
+	Workpiece      camoticsWorkpiece       `json:"workpiece"`
+	Files          []string                `json:"files"`
+}
+
+// camoticsToolLength is the length of the simulated tool (in mm). It only needs to be
+// longer than the stencil is thick.
+const camoticsToolLength = 10
+
+// writeCAMotics writes a CAMotics simulation project referencing the G-code file ref,
+// which is relative to the project directory.
+func writeCAMotics(w io.Writer, ref string, diameter float64) error {
+	p := camoticsProject{
+		Units:          "metric",
+		ResolutionMode: "high",
+		Tools: map[string]camoticsTool{
+			"1": {
+				Units:       "metric",
+				Shape:       "cylindrical",
+				Length:      camoticsToolLength,
+				Diameter:    diameter,
+				Number:      1,
+				Description: "png2stencil tool",
+			},
+		},
+		Workpiece: camoticsWorkpiece{Automatic: true, Margin: 5},
+		Files:     []string{filepath.ToSlash(ref)},
+	}
+	data, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
+
+// saveCAMotics saves the CAMotics project of the --output G-code.
+func saveCAMotics(name string) error {
+	ref := *output
+	if abs, err := filepath.Abs(*output); err == nil {
+		if dir, err := filepath.Abs(filepath.Dir(name)); err == nil {
+			if rel, err := filepath.Rel(dir, abs); err == nil {
+				ref = rel
+			}
+		}
+	}
+	err := writeFile(name, func(w io.Writer) error {
+		return writeCAMotics(w, ref, cutDiameter())
+	})
+	if err != nil {
+		return errorf(exitWriteFailed, "failed to save CAMotics project %q: %w", name, err)
+	}
+	return nil
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/krasin/png2stencil/stencilimg"
+)
+
+// runCheck implements the check subcommand: it validates the flags and the input without
+// packing it, and prints the input size and the number of apertures.
+func runCheck() error {
+	opts, err := checkFlags(false)
+	if err != nil {
+		return err
+	}
+	in, err := loadPNG(*input)
+	if err != nil {
+		return err
+	}
+	regs := stencilimg.LabelRegions(stencilimg.Threshold(in, opts.Background), opts.Packing.Connectivity)
+	if len(regs) == 0 {
+		return errorf(exitBadInput, "no apertures in %q; is --background=%s right?", *input, *background)
+	}
+	w, h := in.Bounds().Dx(), in.Bounds().Dy()
+	imgW, imgH = w*(*n), h*(*n)
+	if err := checkSheet(stencilBounds(opts.RegistrationHoles)); err != nil {
+		return err
+	}
+	fmt.Printf("%s: %dx%d px, %.2fx%.2f mm, %d apertures\n", *input, w, h, float64(w)*(*pxSize), float64(h)*(*pxSize), len(regs))
+	return nil
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/krasin/png2stencil/geom"
+	"github.com/krasin/png2stencil/packer"
+	"github.com/krasin/png2stencil/stencil"
+)
+
+// solvedComponent is a packing result saved into a checkpoint.
+type solvedComponent struct {
+	X, Y     int
+	Strategy string
+	Centers  []geom.Point
+}
+
+// checkpoint holds the components solved so far, so an interrupted run can resume.
+type checkpoint struct {
+	// Key identifies the input and the parameters affecting the packing.
+	Key        string
+	Components map[int]solvedComponent
+
+	name  string
+	saved time.Time
+}
+
+// checkpointInterval is how often the checkpoint is saved during the packing.
+const checkpointInterval = 5 * time.Second
+
+// packingKey hashes the input file with all parameters which affect the circle placement.
+func packingKey() (string, error) {
+	data, err := ioutil.ReadFile(*input)
+	if err != nil {
+		return "", errorf(exitBadInput, "failed to read input file: %w", err)
+	}
+	h := sha256.New()
+	h.Write(data)
+	fmt.Fprintf(h, "px_size=%v tool_diameter=%v n=%v background=%v search=%v coverage_target=%v adaptive_n=%v",
+		*pxSize, cutDiameter(), *n, *background, *search, *covTarget, *adaptiveN)
+	if *strategy != "" {
+		fmt.Fprintf(h, " strategy=%v", *strategy)
+	}
+	if *edgeTol != 0 {
+		fmt.Fprintf(h, " edge_tolerance=%v", *edgeTol)
+	}
+	if *tolerance != stencil.DefaultTolerance {
+		fmt.Fprintf(h, " tolerance=%v", *tolerance)
+	}
+	if *samples != 1 {
+		fmt.Fprintf(h, " circle_samples=%v", *samples)
+	}
+	if *mirror {
+		fmt.Fprintf(h, " mirror=%v", *mirror)
+	}
+	if *connectivity != 4 {
+		fmt.Fprintf(h, " connectivity=%v", *connectivity)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// loadCheckpoint reads the checkpoint, if it exists and matches key. Otherwise an empty one is returned.
+func loadCheckpoint(name, key string) (*checkpoint, error) {
+	cp := &checkpoint{Key: key, Components: make(map[int]solvedComponent), name: name, saved: time.Now()}
+	old, err := readCheckpoint(name, key)
+	if err != nil {
+		return nil, err
+	}
+	if old != nil {
+		slog.Info("Resuming from checkpoint", "file", name, "components", len(old))
+		cp.Components = old
+	}
+	return cp, nil
+}
+
+// readCheckpoint returns the components saved in the checkpoint file, or nil if there is no file,
+// or it's broken or for a different key.
+func readCheckpoint(name, key string) (map[int]solvedComponent, error) {
+	data, err := ioutil.ReadFile(name)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read checkpoint: %w", err)
+	}
+	var old checkpoint
+	if err := json.Unmarshal(data, &old); err != nil {
+		slog.Error("Ignoring broken checkpoint", "file", name, "err", err)
+		return nil, nil
+	}
+	if old.Key != key {
+		slog.Error("Ignoring checkpoint for a different input or parameters", "file", name)
+		return nil, nil
+	}
+	return old.Components, nil
+}
+
+// Lookup returns the saved packing of the k-th component, if it was solved.
+func (cp *checkpoint) Lookup(k, x, y int) (packer.Packing, bool) {
+	c, ok := cp.Components[k]
+	if !ok || c.X != x || c.Y != y {
+		return packer.Packing{}, false
+	}
+	return packer.Packing{Strategy: c.Strategy, Centers: c.Centers}, true
+}
+
+// Add records a solved component and saves the checkpoint, if it's time to. A failed save
+// does not stop the packing: it's retried on the next component, and the final save reports it.
+func (cp *checkpoint) Add(k, x, y int, p packer.Packing) {
+	cp.Components[k] = solvedComponent{x, y, p.Strategy, p.Centers}
+	if time.Since(cp.saved) >= checkpointInterval {
+		if err := cp.save(); err != nil {
+			slog.Error("Failed to save checkpoint", "err", err)
+		}
+	}
+}
+
+func (cp *checkpoint) save() error {
+	data, err := json.Marshal(cp)
+	if err != nil {
+		return fmt.Errorf("failed to marshal checkpoint: %w", err)
+	}
+	// Write to a temporary file first, so a crash never leaves a truncated checkpoint.
+	tmp := cp.name + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return errorf(exitWriteFailed, "failed to write checkpoint: %w", err)
+	}
+	if err := os.Rename(tmp, cp.name); err != nil {
+		return errorf(exitWriteFailed, "failed to save checkpoint: %w", err)
+	}
+	cp.saved = time.Now()
+	slog.Debug("Saved checkpoint", "file", cp.name, "components", len(cp.Components))
+	return nil
+}
+
+// remove deletes the checkpoint after a successful run.
+func (cp *checkpoint) remove() {
+	if err := os.Remove(cp.name); err != nil && !os.IsNotExist(err) {
+		slog.Error("Failed to remove checkpoint", "file", cp.name, "err", err)
+	}
+}
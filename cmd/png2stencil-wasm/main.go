@@ -0,0 +1,93 @@
+//go:build js && wasm
+
+// Command png2stencil-wasm exposes the conversion to JavaScript, so a web page can turn a PNG into
+// G-code without a server. It defines the global function
+//
+//	png2stencilConvert(png: Uint8Array, params: string): Promise<{gcode: string, summary: string}>
+//
+// where params is the JSON of stencil.Params (with the flag names as the keys), and summary is
+// the JSON of stencil.Summary. The promise is rejected with an Error on the bad input.
+//
+// Build it with
+//
+//	GOOS=js GOARCH=wasm go build -o web/png2stencil.wasm ./cmd/png2stencil-wasm
+//	cp "$(go env GOROOT)/lib/wasm/wasm_exec.js" web/
+//
+// and serve the web directory as static files.
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"syscall/js"
+
+	"github.com/krasin/png2stencil/stencil"
+)
+
+func main() {
+	js.Global().Set("png2stencilConvert", js.FuncOf(convert))
+	// Keep the exported function alive for the lifetime of the page.
+	select {}
+}
+
+// convert implements png2stencilConvert.
+func convert(this js.Value, args []js.Value) any {
+	if len(args) != 2 {
+		return reject(fmt.Errorf("png2stencilConvert takes the PNG bytes and the params JSON"))
+	}
+	png := make([]byte, args[0].Get("length").Int())
+	js.CopyBytesToGo(png, args[0])
+	params := args[1].String()
+
+	var resolve, rejectFn js.Value
+	promise := js.Global().Get("Promise").New(js.FuncOf(func(this js.Value, args []js.Value) any {
+		resolve, rejectFn = args[0], args[1]
+		return nil
+	}))
+	go func() {
+		gcode, summary, err := run(png, params)
+		if err != nil {
+			rejectFn.Invoke(js.Global().Get("Error").New(err.Error()))
+			return
+		}
+		res := js.Global().Get("Object").New()
+		res.Set("gcode", gcode)
+		res.Set("summary", summary)
+		resolve.Invoke(res)
+	}()
+	return promise
+}
+
+// reject returns a promise rejected with err.
+func reject(err error) js.Value {
+	return js.Global().Get("Promise").Call("reject", js.Global().Get("Error").New(err.Error()))
+}
+
+// run converts the PNG with the params JSON, and returns the G-code and the summary JSON.
+func run(png []byte, params string) (string, string, error) {
+	p := stencil.DefaultParams()
+	if err := json.Unmarshal([]byte(params), &p); err != nil {
+		return "", "", fmt.Errorf("bad params: %w", err)
+	}
+	// The browser runs the Go code on a single thread, so the extra workers would only add overhead.
+	opts, err := p.Options(1)
+	if err != nil {
+		return "", "", err
+	}
+	opts.Comments = []string{"Generated by png2stencil in the browser"}
+	plan, err := stencil.ConvertReader(context.Background(), bytes.NewReader(png), opts)
+	if err != nil {
+		return "", "", err
+	}
+	var buf bytes.Buffer
+	if err := plan.WriteProgram(&buf); err != nil {
+		return "", "", err
+	}
+	summary, err := json.Marshal(plan.Summary())
+	if err != nil {
+		return "", "", err
+	}
+	return buf.String(), string(summary), nil
+}
@@ -0,0 +1,305 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// parseTOML reads the subset of TOML the configuration files need: the tables, and the keys
+// with the string, number, boolean or string array values. The values are returned by
+// the dotted keys (like "table.key") as flag values: the strings are unquoted, and the arrays
+// are joined with commas.
+func parseTOML(r io.Reader) (map[string]string, error) {
+	res := make(map[string]string)
+	table := ""
+	sc := bufio.NewScanner(r)
+	for line := 1; sc.Scan(); line++ {
+		s := strings.TrimSpace(stripComment(sc.Text()))
+		if s == "" {
+			continue
+		}
+		if strings.HasPrefix(s, "[") {
+			if !strings.HasSuffix(s, "]") || strings.HasPrefix(s, "[[") {
+				return nil, fmt.Errorf("line %d: bad table header: %s", line, s)
+			}
+			table = strings.TrimSpace(s[1:len(s)-1]) + "."
+			continue
+		}
+		i := strings.IndexByte(s, '=')
+		if i < 0 {
+			return nil, fmt.Errorf("line %d: expected key = value: %s", line, s)
+		}
+		key := strings.Trim(strings.TrimSpace(s[:i]), `"`)
+		val, err := tomlValue(strings.TrimSpace(s[i+1:]))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		if _, ok := res[table+key]; ok {
+			return nil, fmt.Errorf("line %d: %s is set twice", line, table+key)
+		}
+		res[table+key] = val
+	}
+	return res, sc.Err()
+}
+
+// stripComment removes the # comment from the line, unless it's inside of a string.
+func stripComment(s string) string {
+	var quote byte
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case quote != 0 && c == '\\' && quote == '"':
+			i++
+		case quote != 0 && c == quote:
+			quote = 0
+		case quote == 0 && (c == '"' || c == '\''):
+			quote = c
+		case quote == 0 && c == '#':
+			return s[:i]
+		}
+	}
+	return s
+}
+
+// tomlValue converts a TOML value into a flag value.
+func tomlValue(s string) (string, error) {
+	switch {
+	case s == "":
+		return "", fmt.Errorf("missing value")
+	case strings.HasPrefix(s, `"`):
+		v, err := strconv.Unquote(s)
+		if err != nil {
+			return "", fmt.Errorf("bad string: %s", s)
+		}
+		return v, nil
+	case strings.HasPrefix(s, "'"):
+		if len(s) < 2 || !strings.HasSuffix(s, "'") {
+			return "", fmt.Errorf("bad string: %s", s)
+		}
+		return s[1 : len(s)-1], nil
+	case strings.HasPrefix(s, "["):
+		if !strings.HasSuffix(s, "]") {
+			return "", fmt.Errorf("bad array: %s", s)
+		}
+		var vals []string
+		for _, e := range strings.Split(s[1:len(s)-1], ",") {
+			if e = strings.TrimSpace(e); e == "" {
+				continue
+			}
+			v, err := tomlValue(e)
+			if err != nil {
+				return "", err
+			}
+			vals = append(vals, v)
+		}
+		return strings.Join(vals, ","), nil
+	}
+	// Numbers and booleans are the same in TOML and in the flags, but for the digit separators.
+	return strings.ReplaceAll(s, "_", ""), nil
+}
+
+// loadConfig reads the TOML file with the flag values, like
+//
+//	px_size = 0.05
+//	background = "black"
+//	strategy = ["triangle", "quad"]
+func loadConfig(name string) (map[string]string, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, errorf(exitBadFlags, "failed to open config: %w", err)
+	}
+	defer f.Close()
+	vals, err := parseTOML(f)
+	if err != nil {
+		return nil, errorf(exitBadFlags, "failed to parse config %q: %w", name, err)
+	}
+	return vals, nil
+}
+
+// envPrefix starts the names of the environment variables setting the flags, like PNG2STENCIL_PX_SIZE.
+const envPrefix = "PNG2STENCIL_"
+
+// envFlags returns the flag values set by the environment variables.
+func envFlags() map[string]string {
+	vals := make(map[string]string)
+	flag.VisitAll(func(f *flag.Flag) {
+		if v, ok := os.LookupEnv(envPrefix + strings.ToUpper(f.Name)); ok {
+			vals[f.Name] = v
+		}
+	})
+	return vals
+}
+
+// parseFlags parses the command line flags, and sets the ones not given on the command line
+// from the environment, then from the --config file, if any, then from the --tool, then from
+// the --material preset, and then from the --machine profile.
+func parseFlags(args []string) error {
+	flag.CommandLine.Parse(args)
+	if err := setFlags(envFlags(), "environment"); err != nil {
+		return err
+	}
+	if *configFile != "" {
+		vals, err := loadConfig(*configFile)
+		if err != nil {
+			return err
+		}
+		if _, ok := vals["config"]; ok {
+			return errorf(exitBadFlags, "a config can't set the config, in %s", *configFile)
+		}
+		if err := setFlags(vals, *configFile); err != nil {
+			return err
+		}
+	}
+	if *toolName != "" {
+		if err := applyTool(); err != nil {
+			return err
+		}
+	}
+	if *material != "" {
+		vals, err := loadMaterial(*material)
+		if err != nil {
+			return err
+		}
+		if err := setFlags(vals, "material "+*material); err != nil {
+			return err
+		}
+	}
+	if *machine != "" {
+		vals, err := loadMachine(*machine)
+		if err != nil {
+			return err
+		}
+		if err := setFlags(vals, "machine "+*machine); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setFlags sets the flags not set yet to the values from the source.
+func setFlags(vals map[string]string, source string) error {
+	set := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	var names []string
+	for name := range vals {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		val := vals[name]
+		f := flag.Lookup(name)
+		if f == nil {
+			return errorf(exitBadFlags, "unknown flag %q in %s", name, source)
+		}
+		if set[name] {
+			continue
+		}
+		if err := flag.Set(name, val); err != nil {
+			return errorf(exitBadFlags, "invalid value %q for %s in %s: %w", val, name, source, err)
+		}
+	}
+	return nil
+}
+
+// flagRules check the values of the flags, returning what's wrong with the value, or "" if it's fine.
+var flagRules = map[string]func() string{
+	"input":                 nonEmpty(input),
+	"output":                nonEmpty(output),
+	"background":            nonEmpty(background),
+	"px_size":               positive(pxSize),
+	"tool_diameter":         positive(toolDiameter),
+	"runout":                finite(runout),
+	"mill_rate":             positive(millRate),
+	"travel_rate":           positive(travelRate),
+	"mill_height":           finite(millHeight),
+	"safe_height":           finite(safeHeight),
+	"hatch_spacing":         positive(hatchSpacing),
+	"knife_offset":          nonNegative(knifeOffset),
+	"thickness":             positive(thickness),
+	"registration_diameter": positive(regDiameter),
+	"corner_holes":          nonNegative(cornerInset),
+	"arc_tolerance":         nonNegative(arcTolerance),
+	"backlash_x":            nonNegative(backlashX),
+	"backlash_y":            nonNegative(backlashY),
+	"sheet_x":               positive(sheetX),
+	"sheet_y":               positive(sheetY),
+	"sheet_gap":             nonNegative(sheetGap),
+	"max_feed":              positive(maxFeed),
+	"max_z_feed":            positive(maxZFeed),
+	"dispense_time": func() string {
+		if *dispenseTime <= 0 {
+			return "must be positive"
+		}
+		return ""
+	},
+}
+
+func nonEmpty(v *string) func() string {
+	return func() string {
+		if *v == "" {
+			return "must not be empty"
+		}
+		return ""
+	}
+}
+
+func positive(v *float64) func() string {
+	return func() string {
+		if !(*v > 0) {
+			return "must be positive"
+		}
+		return ""
+	}
+}
+
+func nonNegative(v *float64) func() string {
+	return func() string {
+		if !(*v >= 0) {
+			return "must not be negative"
+		}
+		return ""
+	}
+}
+
+func finite(v *float64) func() string {
+	return func() string {
+		if math.IsNaN(*v) || math.IsInf(*v, 0) {
+			return "must be a number"
+		}
+		return ""
+	}
+}
+
+// requireFlags checks that the mandatory flags are set, on the command line or by any source of
+// parseFlags, even if to a zero value, and that the values of all set flags pass the flagRules.
+func requireFlags(names ...string) error {
+	set := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	var missing []string
+	for _, name := range names {
+		if !set[name] {
+			missing = append(missing, "--"+name)
+		}
+	}
+	if len(missing) > 0 {
+		return errorf(exitBadFlags, "some mandatory flags not set: %s", strings.Join(missing, ", "))
+	}
+	var invalid []string
+	flag.Visit(func(f *flag.Flag) {
+		if rule, ok := flagRules[f.Name]; ok {
+			if msg := rule(); msg != "" {
+				invalid = append(invalid, fmt.Sprintf("--%s=%s %s", f.Name, f.Value, msg))
+			}
+		}
+	})
+	if len(invalid) > 0 {
+		return errorf(exitBadFlags, "invalid flags: %s", strings.Join(invalid, "; "))
+	}
+	return nil
+}
@@ -0,0 +1,272 @@
+// Package datamatrix encodes the short texts as the square ECC 200 Data Matrix symbols, the
+// 2D codes the phones and the production scanners read, for marking the stencils.
+package datamatrix
+
+import "fmt"
+
+// symbol is a square symbol size with a single data region and a single Reed-Solomon block.
+type symbol struct {
+	size   int // modules per side, with the finder pattern
+	data   int // data codewords
+	ecc    int // error correction codewords
+	region int // data region modules per side
+}
+
+// symbols are the sizes Encode picks from, smallest first. The bigger ones have several data
+// regions, which a stencil ID doesn't need.
+var symbols = []symbol{
+	{10, 3, 5, 8},
+	{12, 5, 7, 10},
+	{14, 8, 10, 12},
+	{16, 12, 12, 14},
+	{18, 18, 14, 16},
+	{20, 22, 18, 18},
+	{22, 30, 20, 20},
+	{24, 36, 24, 22},
+	{26, 44, 28, 24},
+}
+
+// Encode returns the modules of the smallest symbol encoding the text, row by row from the top,
+// true for the dark ones. The text must be ASCII; the pairs of digits take a codeword together.
+func Encode(text string) ([][]bool, error) {
+	data, err := encodeASCII(text)
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range symbols {
+		if len(data) <= s.data {
+			return s.modules(pad(data, s.data)), nil
+		}
+	}
+	return nil, fmt.Errorf("%q takes %d codewords, more than the %d of the largest symbol", text, len(data), symbols[len(symbols)-1].data)
+}
+
+// encodeASCII returns the codewords of the text in the ASCII encodation.
+func encodeASCII(text string) ([]byte, error) {
+	var res []byte
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case c >= 0x80:
+			return nil, fmt.Errorf("%q is not ASCII", text)
+		case isDigit(c) && i+1 < len(text) && isDigit(text[i+1]):
+			res = append(res, 130+(c-'0')*10+(text[i+1]-'0'))
+			i++
+		default:
+			res = append(res, c+1)
+		}
+	}
+	return res, nil
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// pad fills the data up to n codewords: the first pad is 129, and the next ones are randomized
+// by their positions, so the symbol has no big uniform areas.
+func pad(data []byte, n int) []byte {
+	res := append([]byte(nil), data...)
+	for i := len(res); i < n; i++ {
+		if i == len(data) {
+			res = append(res, 129)
+			continue
+		}
+		v := 129 + (149*(i+1))%253 + 1
+		if v > 254 {
+			v -= 254
+		}
+		res = append(res, byte(v))
+	}
+	return res
+}
+
+// The Reed-Solomon codes are over GF(256) with the field polynomial x^8+x^5+x^3+x^2+1.
+var gfExp, gfLog [256]int
+
+func init() {
+	x := 1
+	for i := 0; i < 255; i++ {
+		gfExp[i] = x
+		gfLog[x] = i
+		x <<= 1
+		if x >= 256 {
+			x ^= 0x12d
+		}
+	}
+}
+
+func gfMul(a, b int) int {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	return gfExp[(gfLog[a]+gfLog[b])%255]
+}
+
+// eccCodewords returns the n error correction codewords of the data: the remainder of its division
+// by the generator polynomial with the roots α^1 to α^n.
+func eccCodewords(data []byte, n int) []byte {
+	// gen[j] is the coefficient of x^j of the generator.
+	gen := make([]int, n+1)
+	gen[0] = 1
+	for i := 1; i <= n; i++ {
+		// Multiply by (x + α^i).
+		for j := i; j > 0; j-- {
+			gen[j] = gen[j-1] ^ gfMul(gen[j], gfExp[i])
+		}
+		gen[0] = gfMul(gen[0], gfExp[i])
+	}
+	// The division shifts the data through the remainder, from the highest power.
+	rem := make([]int, n)
+	for _, d := range data {
+		k := int(d) ^ rem[n-1]
+		for j := n - 1; j > 0; j-- {
+			rem[j] = rem[j-1] ^ gfMul(k, gen[j])
+		}
+		rem[0] = gfMul(k, gen[0])
+	}
+	res := make([]byte, n)
+	for j := range rem {
+		res[n-1-j] = byte(rem[j])
+	}
+	return res
+}
+
+// modules places the data codewords with their error correction in the symbol.
+func (s symbol) modules(data []byte) [][]bool {
+	words := append(data, eccCodewords(data, s.ecc)...)
+	p := newPlacement(s.region, s.region)
+	p.place()
+	res := make([][]bool, s.size)
+	for r := range res {
+		res[r] = make([]bool, s.size)
+	}
+	// The finder pattern: the solid left and bottom edges, and the alternating top and right ones.
+	for i := 0; i < s.size; i++ {
+		res[i][0] = true
+		res[s.size-1][i] = true
+		res[0][i] = i%2 == 0
+		res[i][s.size-1] = i%2 == 1
+	}
+	for r := 0; r < s.region; r++ {
+		for c := 0; c < s.region; c++ {
+			m := p.modules[r*s.region+c]
+			switch {
+			case m.fixed:
+				res[r+1][c+1] = m.dark
+			default:
+				res[r+1][c+1] = words[m.word]>>(7-m.bit)&1 == 1
+			}
+		}
+	}
+	return res
+}
+
+// module is a module of the data region: a bit of a codeword, or one of the fixed ones
+// filling the corner some sizes leave over.
+type module struct {
+	set, fixed, dark bool
+	word, bit        int // the bit 0 is the most significant
+}
+
+// placement lays out the codewords in the data region of nrow by ncol modules, along
+// the diagonal stripes of the standard.
+type placement struct {
+	nrow, ncol int
+	modules    []module
+}
+
+func newPlacement(nrow, ncol int) *placement {
+	return &placement{nrow, ncol, make([]module, nrow*ncol)}
+}
+
+// set places the bit of the codeword at the module, wrapping the positions outside of the region.
+func (p *placement) set(row, col, word, bit int) {
+	if row < 0 {
+		row += p.nrow
+		col += 4 - (p.nrow+4)%8
+	}
+	if col < 0 {
+		col += p.ncol
+		row += 4 - (p.ncol+4)%8
+	}
+	p.modules[row*p.ncol+col] = module{set: true, word: word, bit: bit}
+}
+
+// utah places the codeword in the standard L shape with its last bit at the module.
+func (p *placement) utah(row, col, word int) {
+	p.set(row-2, col-2, word, 0)
+	p.set(row-2, col-1, word, 1)
+	p.set(row-1, col-2, word, 2)
+	p.set(row-1, col-1, word, 3)
+	p.set(row-1, col, word, 4)
+	p.set(row, col-2, word, 5)
+	p.set(row, col-1, word, 6)
+	p.set(row, col, word, 7)
+}
+
+// corner places the codeword in one of the special shapes at the corners, the module of every bit.
+func (p *placement) corner(word int, pos [8][2]int) {
+	for bit, rc := range pos {
+		p.set(rc[0], rc[1], word, bit)
+	}
+}
+
+func (p *placement) isSet(row, col int) bool {
+	return p.modules[row*p.ncol+col].set
+}
+
+func (p *placement) place() {
+	nrow, ncol := p.nrow, p.ncol
+	word, row, col := 0, 4, 0
+	for {
+		switch {
+		case row == nrow && col == 0:
+			p.corner(word, [8][2]int{{nrow - 1, 0}, {nrow - 1, 1}, {nrow - 1, 2}, {0, ncol - 2}, {0, ncol - 1}, {1, ncol - 1}, {2, ncol - 1}, {3, ncol - 1}})
+			word++
+		case row == nrow-2 && col == 0 && ncol%4 != 0:
+			p.corner(word, [8][2]int{{nrow - 3, 0}, {nrow - 2, 0}, {nrow - 1, 0}, {0, ncol - 4}, {0, ncol - 3}, {0, ncol - 2}, {0, ncol - 1}, {1, ncol - 1}})
+			word++
+		case row == nrow-2 && col == 0 && ncol%8 == 4:
+			p.corner(word, [8][2]int{{nrow - 3, 0}, {nrow - 2, 0}, {nrow - 1, 0}, {0, ncol - 2}, {0, ncol - 1}, {1, ncol - 1}, {2, ncol - 1}, {3, ncol - 1}})
+			word++
+		case row == nrow+4 && col == 2 && ncol%8 == 0:
+			p.corner(word, [8][2]int{{nrow - 1, 0}, {nrow - 1, ncol - 1}, {0, ncol - 3}, {0, ncol - 2}, {0, ncol - 1}, {1, ncol - 3}, {1, ncol - 2}, {1, ncol - 1}})
+			word++
+		}
+		// Up and to the right.
+		for {
+			if row < nrow && col >= 0 && !p.isSet(row, col) {
+				p.utah(row, col, word)
+				word++
+			}
+			row, col = row-2, col+2
+			if row < 0 || col >= ncol {
+				break
+			}
+		}
+		row, col = row+1, col+3
+		// Down and to the left.
+		for {
+			if row >= 0 && col < ncol && !p.isSet(row, col) {
+				p.utah(row, col, word)
+				word++
+			}
+			row, col = row+2, col-2
+			if row >= nrow || col < 0 {
+				break
+			}
+		}
+		row, col = row+3, col+1
+		if row >= nrow && col >= ncol {
+			break
+		}
+	}
+	// The sizes leaving the lower right corner over fill it with a fixed pattern.
+	if !p.isSet(nrow-1, ncol-1) {
+		p.modules[(nrow-1)*ncol+ncol-1] = module{set: true, fixed: true, dark: true}
+		p.modules[(nrow-2)*ncol+ncol-2] = module{set: true, fixed: true, dark: true}
+		p.modules[(nrow-1)*ncol+ncol-2] = module{set: true, fixed: true}
+		p.modules[(nrow-2)*ncol+ncol-1] = module{set: true, fixed: true}
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+
+	"github.com/krasin/png2stencil/geom"
+	"github.com/krasin/png2stencil/stencil"
+	"github.com/krasin/png2stencil/stencilimg"
+)
+
+// dxfWriter emits AutoCAD R12 DXF group code/value pairs. R12 has no header variable telling
+// the units, so the drawings are in mm, unitless to the readers.
+type dxfWriter struct {
+	w *bufio.Writer
+}
+
+func (d *dxfWriter) pair(code int, val interface{}) {
+	switch v := val.(type) {
+	case float64:
+		fmt.Fprintf(d.w, "%d\n%f\n", code, v)
+	default:
+		fmt.Fprintf(d.w, "%d\n%v\n", code, v)
+	}
+}
+
+func (d *dxfWriter) layer(name string, color int) {
+	d.pair(0, "LAYER")
+	d.pair(2, name)
+	d.pair(70, 0)
+	d.pair(62, color)
+	d.pair(6, "CONTINUOUS")
+}
+
+func (d *dxfWriter) circle(layer string, c geom.Point, r float64) {
+	d.pair(0, "CIRCLE")
+	d.pair(8, layer)
+	d.pair(10, c.X)
+	d.pair(20, c.Y)
+	d.pair(30, 0.0)
+	d.pair(40, r)
+}
+
+// polyline writes the polyline through the points, back to the first one if closed.
+func (d *dxfWriter) polyline(layer string, pts []geom.Point, closed bool) {
+	flags := 0
+	if closed {
+		flags = 1
+	}
+	d.pair(0, "POLYLINE")
+	d.pair(8, layer)
+	d.pair(66, 1)
+	d.pair(70, flags)
+	for _, p := range pts {
+		d.pair(0, "VERTEX")
+		d.pair(8, layer)
+		d.pair(10, p.X)
+		d.pair(20, p.Y)
+		d.pair(30, 0.0)
+	}
+	d.pair(0, "SEQEND")
+	d.pair(8, layer)
+}
+
+const (
+	dxfAperturesLayer = "APERTURES"
+	dxfToolpathLayer  = "TOOLPATH"
+)
+
+// writeDXF writes the drawing in the machine space (in mm): the aperture contours (in the base
+// image space) as the closed polylines on one layer, and the toolpath on the other. The toolpath
+// is the milled circles of the radius r with the travel path between them in the dispense mode,
+// and the paths (in the base image space) the laser or the knife cuts along in the others.
+func writeDXF(w io.Writer, contours [][]geom.Point, centers []geom.Point, r float64, paths [][]geom.Point) error {
+	d := &dxfWriter{w: bufio.NewWriter(w)}
+
+	d.pair(0, "SECTION")
+	d.pair(2, "HEADER")
+	d.pair(9, "$ACADVER")
+	d.pair(1, "AC1009")
+	d.pair(0, "ENDSEC")
+
+	d.pair(0, "SECTION")
+	d.pair(2, "TABLES")
+	d.pair(0, "TABLE")
+	d.pair(2, "LAYER")
+	d.pair(70, 2)
+	d.layer(dxfAperturesLayer, 1)
+	d.layer(dxfToolpathLayer, 5)
+	d.pair(0, "ENDTAB")
+	d.pair(0, "ENDSEC")
+
+	d.pair(0, "SECTION")
+	d.pair(2, "ENTITIES")
+	toMachinePath := func(path []geom.Point) []geom.Point {
+		res := make([]geom.Point, len(path))
+		for i, p := range path {
+			res[i] = toMachine(p)
+		}
+		return res
+	}
+	for _, c := range contours {
+		d.polyline(dxfAperturesLayer, toMachinePath(c), true)
+	}
+	for _, c := range centers {
+		d.circle(dxfToolpathLayer, toMachine(c), r)
+	}
+	if len(centers) > 1 {
+		d.polyline(dxfToolpathLayer, toMachinePath(centers), false)
+	}
+	for _, p := range paths {
+		d.polyline(dxfToolpathLayer, toMachinePath(p), false)
+	}
+	d.pair(0, "ENDSEC")
+	d.pair(0, "EOF")
+	return d.w.Flush()
+}
+
+// saveDXF saves the aperture contours of the base image and the toolpath of the plan, see writeDXF.
+func saveDXF(name string, base stencilimg.PixelMask, plan *stencil.Plan) error {
+	contours := stencilimg.TraceContours(base, *pxSize/float64(*n))
+	var centers []geom.Point
+	if *mode == stencil.ModeDispense {
+		centers = plan.Centers
+	}
+	err := writeFile(name, func(w io.Writer) error {
+		return writeDXF(w, contours, centers, cutDiameter()/2, plan.CutPaths())
+	})
+	if err != nil {
+		return errorf(exitWriteFailed, "failed to save DXF file %q: %w", name, err)
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/krasin/png2stencil/stencil"
+)
+
+// Exit codes. Codes below exitSkipped are hard failures, when no usable output was produced;
+// exitSkipped and exitLowCoverage are warnings about a complete, but possibly deficient result.
+// exitInterrupted is neither: the user stopped the run, and it's 128 plus SIGINT, as in the shells.
+const (
+	exitOK           = 0
+	exitFailure      = 1 // internal error
+	exitBadFlags     = 2
+	exitBadInput     = 3
+	exitWriteFailed  = 4
+	exitVerifyFailed = 5
+	exitLimits       = 6
+	exitSendFailed   = 8
+	exitUncovered    = 9
+	exitSkipped      = 10
+	exitLowCoverage  = 11
+	exitInterrupted  = 130
+)
+
+var exitCodeDocs = []struct {
+	code int
+	doc  string
+}{
+	{exitOK, "success"},
+	{exitFailure, "internal error"},
+	{exitBadFlags, "bad or missing flags"},
+	{exitBadInput, "unreadable input"},
+	{exitWriteFailed, "failed to write an output file"},
+	{exitVerifyFailed, "generated G-code failed verification"},
+	{exitLimits, "generated G-code exceeds the machine travel limits or the stencil doesn't fit the sheet"},
+	{exitSendFailed, "the controller rejected the program or stopped responding"},
+	{exitUncovered, "more of the aperture area than --max_uncovered is left uncovered"},
+	{exitSkipped, "warning: some apertures got no circles in the dispense mode"},
+	{exitLowCoverage, "warning: coverage is below --min_coverage in the dispense mode"},
+	{exitInterrupted, "interrupted; the outputs only have the apertures solved so far"},
+}
+
+// atExit holds the functions to run before the process exits, in the reverse order.
+var atExit []func()
+
+// exit runs the atExit functions and exits with the code. All exits go through it,
+// so the profiles are written even if the run fails.
+func exit(code int) {
+	for i := len(atExit) - 1; i >= 0; i-- {
+		atExit[i]()
+	}
+	os.Exit(code)
+}
+
+// exitError is an error ending the process with the code.
+type exitError struct {
+	code int
+	err  error
+}
+
+func (e *exitError) Error() string { return e.err.Error() }
+
+func (e *exitError) Unwrap() error { return e.err }
+
+// errorf returns an error with the exit code, formatted by fmt.Errorf, so %w wraps the cause.
+func errorf(code int, format string, args ...interface{}) error {
+	return &exitError{code, fmt.Errorf(format, args...)}
+}
+
+// exitCode returns the exit code of the error: the code of the outermost exitError in its chain,
+// or exitFailure if there is none.
+func exitCode(err error) int {
+	var e *exitError
+	if errors.As(err, &e) {
+		return e.code
+	}
+	return exitFailure
+}
+
+// exitWith prints the error and exits with its code, or exits with the code if there is no error.
+// It's the only place where the errors turn into exits.
+func exitWith(code int, err error) {
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "png2stencil: %v\n", err)
+		code = exitCode(err)
+	}
+	exit(code)
+}
+
+// resultCode returns the exit code for a complete run, reporting the deficient results. Only the
+// circles of the dispense mode can be deficient; the laser and the knife cut the whole apertures.
+func resultCode(st *stencil.Stats) int {
+	if *mode != stencil.ModeDispense {
+		return exitOK
+	}
+	if st.Coverage() < *minCoverage {
+		return exitLowCoverage
+	}
+	if len(st.Skipped()) > 0 {
+		return exitSkipped
+	}
+	return exitOK
+}
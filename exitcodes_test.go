@@ -0,0 +1,36 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/krasin/png2stencil/stencil"
+)
+
+func TestResultCode(t *testing.T) {
+	defer func(m string, c float64) { *mode, *minCoverage = m, c }(*mode, *minCoverage)
+	skipped := &stencil.Stats{Apertures: []stencil.Aperture{
+		{ID: 1, Area: 100, Covered: 80, Circles: 3},
+		{ID: 2, Area: 10},
+	}}
+	covered := &stencil.Stats{Apertures: []stencil.Aperture{{ID: 1, Area: 100, Covered: 80, Circles: 3}}}
+	for _, tc := range []struct {
+		mode        string
+		minCoverage float64
+		st          *stencil.Stats
+		want        int
+	}{
+		{stencil.ModeDispense, 0, covered, exitOK},
+		{stencil.ModeDispense, 0, skipped, exitSkipped},
+		{stencil.ModeDispense, 0.9, covered, exitLowCoverage},
+		// The laser and the knife cut the whole apertures, so there's nothing to warn about.
+		{stencil.ModeLaser, 0, skipped, exitOK},
+		{stencil.ModeLaser, 0.9, covered, exitOK},
+		{stencil.ModeKnife, 0, skipped, exitOK},
+		{stencil.ModeKnife, 0.9, covered, exitOK},
+	} {
+		*mode, *minCoverage = tc.mode, tc.minCoverage
+		if got := resultCode(tc.st); got != tc.want {
+			t.Errorf("resultCode in the %s mode with --min_coverage=%v: got %d, want %d", tc.mode, tc.minCoverage, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/krasin/png2stencil/gcode"
+	"github.com/krasin/png2stencil/geom"
+)
+
+// fiducialTolerance is the RMS distance (in mm) between the transformed design fiducials and
+// the measured ones above which they likely don't match, and scaleTolerance is the same for
+// the scale: a blank can be placed off, but not shrunk.
+const (
+	fiducialTolerance = 0.1
+	scaleTolerance    = 0.01
+)
+
+// parsePoint parses a point like "10.5,20".
+func parsePoint(s string) (geom.Point, error) {
+	f := strings.Split(s, ",")
+	if len(f) != 2 {
+		return geom.Point{}, fmt.Errorf("expected x,y, got %q", s)
+	}
+	x, err := strconv.ParseFloat(strings.TrimSpace(f[0]), 64)
+	if err != nil {
+		return geom.Point{}, err
+	}
+	y, err := strconv.ParseFloat(strings.TrimSpace(f[1]), 64)
+	if err != nil {
+		return geom.Point{}, err
+	}
+	return geom.Pt(x, y), nil
+}
+
+// loadFiducials returns the transform fitting the --fiducials, or nil if the flag is not set.
+func loadFiducials() (*gcode.Transform, error) {
+	if *fiducials == "" {
+		return nil, nil
+	}
+	var design, measured []geom.Point
+	for _, pair := range strings.Split(*fiducials, ";") {
+		dm := strings.Split(pair, "=")
+		if len(dm) != 2 {
+			return nil, errorf(exitBadFlags, "bad --fiducials pair %q, expected design_x,design_y=machine_x,machine_y", pair)
+		}
+		d, err := parsePoint(dm[0])
+		if err != nil {
+			return nil, errorf(exitBadFlags, "bad --fiducials design point: %w", err)
+		}
+		m, err := parsePoint(dm[1])
+		if err != nil {
+			return nil, errorf(exitBadFlags, "bad --fiducials machine point: %w", err)
+		}
+		design, measured = append(design, d), append(measured, m)
+	}
+	t, rms, err := gcode.FitTransform(design, measured)
+	if err != nil {
+		return nil, errorf(exitBadFlags, "bad --fiducials: %w", err)
+	}
+	slog.Info("Fitted the fiducials", "rotation", t.Rotation(), "scale", t.Scale(),
+		"offset_x", real(t.T), "offset_y", imag(t.T), "rms", rms)
+	if rms > fiducialTolerance || math.Abs(t.Scale()-1) > scaleTolerance {
+		slog.Warn("The fiducials fit poorly; are they measured in the same order as given?",
+			"rms", rms, "scale", t.Scale())
+	}
+	return &t, nil
+}
@@ -0,0 +1,75 @@
+package gcode
+
+import (
+	"math"
+
+	"github.com/krasin/png2stencil/geom"
+)
+
+// minArcPoints is the fewest points of a closed path fitCircle takes for a circle.
+const minArcPoints = 8
+
+// fitCircle tells if the closed path (ending at its start) is within tol (in mm) of a circle,
+// going around it once, and returns the circle and its direction. The center is the centroid
+// of the path area, and the radius is halfway between the nearest and the farthest points.
+func fitCircle(path []geom.Point, tol float64) (center geom.Point, r float64, ccw, ok bool) {
+	n := len(path) - 1
+	if n < minArcPoints || path[0] != path[n] {
+		return geom.Point{}, 0, false, false
+	}
+	var area, cx, cy float64
+	for i := 0; i < n; i++ {
+		a, b := path[i], path[i+1]
+		cross := a.X*b.Y - b.X*a.Y
+		area += cross
+		cx += (a.X + b.X) * cross
+		cy += (a.Y + b.Y) * cross
+	}
+	if math.Abs(area) < 1e-12 {
+		return geom.Point{}, 0, false, false
+	}
+	center = geom.Pt(cx/(3*area), cy/(3*area))
+	ccw = area > 0
+	lo, hi := math.Inf(1), math.Inf(-1)
+	for i := 0; i < n; i++ {
+		a, b := path[i], path[i+1]
+		d := math.Hypot(a.X-center.X, a.Y-center.Y)
+		lo, hi = math.Min(lo, d), math.Max(hi, d)
+		// Going around once, every step turns the same way around the center.
+		turn := (a.X-center.X)*(b.Y-center.Y) - (a.Y-center.Y)*(b.X-center.X)
+		if turn > 0 != ccw && turn != 0 {
+			return geom.Point{}, 0, false, false
+		}
+	}
+	if (hi-lo)/2 > tol || lo <= tol {
+		return geom.Point{}, 0, false, false
+	}
+	return center, (lo + hi) / 2, ccw, true
+}
+
+// arcPoints returns the polygon approximating the arc from the point to the point to around
+// the center, counterclockwise if ccw, without from and ending at to. It's the full circle if
+// to is from. The radius is the one at from.
+func arcPoints(from, to, center geom.Point, ccw bool) []geom.Point {
+	r := math.Hypot(from.X-center.X, from.Y-center.Y)
+	a0 := math.Atan2(from.Y-center.Y, from.X-center.X)
+	a1 := math.Atan2(to.Y-center.Y, to.X-center.X)
+	switch {
+	case ccw && a1 <= a0+verifyEps:
+		a1 += 2 * math.Pi
+	case !ccw && a1 >= a0-verifyEps:
+		a1 -= 2 * math.Pi
+	}
+	// As many segments as the holes have for the angle.
+	n := 1
+	if r > holeSagitta {
+		n = int(math.Ceil(math.Abs(a1-a0) / (2 * math.Acos(1-holeSagitta/r))))
+	}
+	pts := make([]geom.Point, n)
+	for i := 1; i < n; i++ {
+		a := a0 + (a1-a0)*float64(i)/float64(n)
+		pts[i-1] = geom.Pt(center.X+r*math.Cos(a), center.Y+r*math.Sin(a))
+	}
+	pts[n-1] = to
+	return pts
+}
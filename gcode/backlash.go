@@ -0,0 +1,70 @@
+package gcode
+
+import (
+	"github.com/krasin/png2stencil/geom"
+)
+
+// backlashed takes up the Config BacklashX and BacklashY of the moves of an emitter. The slack is
+// assumed taken up toward the negative direction at the start, so the points of the moves in
+// the positive direction along an axis are offset by its backlash. Every time the motion along
+// an axis reverses, the offset changes with an extra move at the current point first, which only
+// takes up the slack, so the next move is straight.
+type backlashed struct {
+	Emitter
+	c *Config
+	// pos is the target of the last move, without the offset, if known is set.
+	pos   geom.Point
+	known bool
+	// up tells for X and Y if the last motion along the axis was in the positive direction.
+	up [2]bool
+}
+
+func newBacklashed(e Emitter, c *Config) *backlashed {
+	return &backlashed{Emitter: e, c: c}
+}
+
+// offset returns the point with the offset of the motion directions.
+func (e *backlashed) offset(p geom.Point) geom.Point {
+	if e.up[0] {
+		p.X += e.c.BacklashX
+	}
+	if e.up[1] {
+		p.Y += e.c.BacklashY
+	}
+	return p
+}
+
+func (e *backlashed) move(p geom.Point, move func(p geom.Point)) {
+	if e.known {
+		up := e.up
+		for axis, d := range [2]float64{p.X - e.pos.X, p.Y - e.pos.Y} {
+			switch {
+			case d > verifyEps:
+				up[axis] = true
+			case d < -verifyEps:
+				up[axis] = false
+			}
+		}
+		if up != e.up {
+			e.up = up
+			move(e.offset(e.pos))
+		}
+	}
+	e.pos, e.known = p, true
+	move(e.offset(p))
+}
+
+func (e *backlashed) Rapid(p geom.Point) {
+	e.move(p, e.Emitter.Rapid)
+}
+
+func (e *backlashed) Feed(p geom.Point) {
+	e.move(p, e.Emitter.Feed)
+}
+
+// Arc cuts the circle with the straight moves, since the motion along both axes reverses on it.
+func (e *backlashed) Arc(from, center geom.Point, ccw bool) {
+	for _, p := range arcPoints(from, from, center, ccw) {
+		e.Feed(p)
+	}
+}
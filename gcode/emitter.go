@@ -0,0 +1,120 @@
+package gcode
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/krasin/png2stencil/geom"
+)
+
+// Emitter writes the machine commands in the dialect of a controller. The points are in the machine
+// space, the heights are relative to the material top (see Config.ZOffset), and the feed rates come
+// from the Config.
+type Emitter interface {
+	// Header starts the program.
+	Header()
+	// Footer ends the program.
+	Footer()
+	// Rapid travels to p at the current Z, without cutting.
+	Rapid(p geom.Point)
+	// Feed cuts to p at the current Z at the mill rate.
+	Feed(p geom.Point)
+	// Arc cuts the full circle around center from the current point from back to it at the current Z
+	// at the mill rate, counterclockwise if ccw, with G2 or G3.
+	Arc(from, center geom.Point, ccw bool)
+	// Plunge lowers the tool to z at the mill rate.
+	Plunge(z float64)
+	// Retract raises the tool to z.
+	Retract(z float64)
+	// ToolChange stops the program until the tool is changed to t, with the ToolChangeCmd.
+	ToolChange(t Tool)
+	// Dwell waits for d.
+	Dwell(d time.Duration)
+	// Valve opens or closes the dispenser valve.
+	Valve(open bool)
+	// Laser turns the laser on at the LaserPower, or off.
+	Laser(on bool)
+	// Spindle turns the spindle on clockwise at rpm, or off if it's 0.
+	Spindle(rpm float64)
+	// Coolant turns the Config Coolant on, or off; it does nothing if there's none.
+	Coolant(on bool)
+	// Probe lowers the tool towards z at the ProbeRate until the probe touches the surface,
+	// and reports the touch position (see ParseProbeLog).
+	Probe(z float64)
+	// Comment writes a line with the comment only.
+	Comment(text string)
+}
+
+// NewEmitterFunc returns an emitter of a dialect passing the lines to add one by one.
+type NewEmitterFunc func(add func(code string), c *Config) Emitter
+
+type dialect struct {
+	name string
+	new  NewEmitterFunc
+}
+
+// dialects are the registered controller dialects.
+var dialects []dialect
+
+func init() {
+	RegisterDialect(DialectMarlin, newMarlin)
+	RegisterDialect(DialectGRBL, newGRBL)
+}
+
+// RegisterDialect makes the controller dialect available by the name. It's meant to be called
+// from init functions, and panics if the name is already taken.
+func RegisterDialect(name string, f NewEmitterFunc) {
+	if HasDialect(name) {
+		panic(fmt.Sprintf("gcode: dialect %q registered twice", name))
+	}
+	dialects = append(dialects, dialect{name, f})
+}
+
+// HasDialect tells if there's a registered dialect with the name.
+func HasDialect(name string) bool {
+	for _, d := range dialects {
+		if d.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// DialectNames returns the names of the registered dialects.
+func DialectNames() []string {
+	var names []string
+	for _, d := range dialects {
+		names = append(names, d.name)
+	}
+	return names
+}
+
+// NewEmitter returns the emitter of the Config dialect (DialectMarlin if it's empty), which passes
+// the lines to add one by one, with the Config Hooks, if any. If the Config has a BacklashX or
+// a BacklashY, the points of the moves in the positive direction along the axis are offset by it,
+// and the offset is changed with an extra move at the same point when the direction reverses.
+// The slack is assumed taken up toward the negative direction at the start.
+func NewEmitter(add func(code string), c *Config) (Emitter, error) {
+	name := c.Dialect
+	if name == "" {
+		name = DialectMarlin
+	}
+	for _, d := range dialects {
+		if d.name != name {
+			continue
+		}
+		e := d.new(add, c)
+		if c.BacklashX > 0 || c.BacklashY > 0 {
+			e = newBacklashed(e, c)
+		}
+		if c.Hooks == nil {
+			return e, nil
+		}
+		h, err := newHooked(e, add, c.Hooks)
+		if err != nil {
+			return nil, err
+		}
+		return h, nil
+	}
+	return nil, fmt.Errorf("unknown dialect: %s", name)
+}
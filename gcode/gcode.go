@@ -0,0 +1,210 @@
+// Package gcode generates the machine programs of the stencils, and simulates and verifies them.
+package gcode
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/krasin/png2stencil/geom"
+	"github.com/krasin/png2stencil/stencilimg"
+)
+
+// Laser on/off command sets.
+const (
+	// LaserM3 turns the laser on with M3 S and off with M5.
+	LaserM3 = "m3"
+	// LaserM106 turns the laser on with M106 S and off with M107, like a fan output.
+	LaserM106 = "m106"
+)
+
+// Tool change command sets.
+const (
+	// ToolChangeM0 pauses the program with M0 and a prompt to change the tool and re-zero Z by hand.
+	ToolChangeM0 = "m0"
+	// ToolChangeM6 changes the tool with M6 T, for the machines with a tool changer.
+	ToolChangeM6 = "m6"
+)
+
+// Z zero references.
+const (
+	// ZReferenceTop is Z zeroed on the material top.
+	ZReferenceTop = "top"
+	// ZReferenceBottom is Z zeroed on the spoilboard under the material.
+	ZReferenceBottom = "bottom"
+)
+
+// Coolants.
+const (
+	// CoolantFlood is the flood coolant, turned on with M8.
+	CoolantFlood = "flood"
+	// CoolantMist is the mist coolant, turned on with M7.
+	CoolantMist = "mist"
+	// CoolantAir is the air blast, which is usually wired to the mist output, turned on with M7.
+	CoolantAir = "air"
+)
+
+// coolantCode returns the command turning the coolant on or off, or "" if there's no coolant.
+func coolantCode(coolant string, on bool) string {
+	switch {
+	case coolant == "":
+		return ""
+	case !on:
+		return "M9"
+	case coolant == CoolantFlood:
+		return "M8"
+	}
+	return "M7"
+}
+
+// Tool is a tool of a job with several ones.
+type Tool struct {
+	// Number is the T number of the tool.
+	Number int
+	// Name is what the tool is called in the prompt, if not empty.
+	Name string
+	// Diameter is the cutting diameter (in mm), 0 if it doesn't matter.
+	Diameter float64
+}
+
+// String describes the tool for the prompts, like "tool 2 (flat-1.0, 1 mm)".
+func (t Tool) String() string {
+	var details []string
+	if t.Name != "" {
+		details = append(details, t.Name)
+	}
+	if t.Diameter > 0 {
+		details = append(details, fmt.Sprintf("%g mm", t.Diameter))
+	}
+	if len(details) == 0 {
+		return fmt.Sprintf("tool %d", t.Number)
+	}
+	return fmt.Sprintf("tool %d (%s)", t.Number, strings.Join(details, ", "))
+}
+
+// toolPrompt is the message of the manual tool change.
+func toolPrompt(t Tool) string {
+	return fmt.Sprintf("Change to %s and re-zero Z", t)
+}
+
+// ChangeTool generates the program body changing the tool, raised to the safe height first,
+// unless it's the laser.
+func ChangeTool(e Emitter, c *Config, t Tool, laser bool) {
+	if laser {
+		e.Laser(false)
+	} else {
+		e.Retract(c.SafeHeight)
+	}
+	e.ToolChange(t)
+}
+
+// Config holds the machine parameters of the generated programs.
+type Config struct {
+	// Frame converts the points from the base image space to the machine space.
+	Frame geom.Frame
+	// TravelRate and MillRate are the feed rates (in mm/min).
+	TravelRate, MillRate float64
+	// MillHeight and SafeHeight are the working and the travel Z (in mm), relative to the material top.
+	MillHeight, SafeHeight float64
+	// ZOffset is the height of the material top above the Z zero (in mm): 0 if Z is zeroed on the top
+	// (ZReferenceTop), or the material thickness if it's zeroed on the spoilboard under it (ZReferenceBottom).
+	// The emitters add it to every height.
+	ZOffset float64
+	// DispenseTime is how long the dispenser valve is kept open for each shot.
+	DispenseTime time.Duration
+	// LaserCmd is the laser on/off command set: LaserM3 or LaserM106.
+	LaserCmd string
+	// LaserPower is the S value of the laser on command.
+	LaserPower int
+	// Passes is the number of laser passes over each aperture.
+	Passes int
+	// HatchSpacing is the distance between the laser hatching lines (in mm).
+	HatchSpacing float64
+	// ProbeRate is the feed rate of the probing moves (in mm/min).
+	ProbeRate float64
+	// Hooks, if not nil, are added to the programs.
+	Hooks *Hooks
+	// DrillRPM and MillRPM are the spindle speeds of the plunged holes and of the milled cuts;
+	// the spindle is off for the sections at 0, and left alone if both are.
+	DrillRPM, MillRPM float64
+	// Coolant is CoolantFlood, CoolantMist, CoolantAir, or empty if there's none.
+	Coolant string
+	// ArcTolerance, if positive, makes the holes cut with the arcs, and the closed knife paths within
+	// it (in mm) of a circle, like the ones of the round pads, see Emitter.Arc.
+	ArcTolerance float64
+	// BacklashX and BacklashY are the slack (in mm) of the X and Y drives, which the emitters take
+	// up with an extra move every time the motion along the axis reverses, see NewEmitter.
+	BacklashX, BacklashY float64
+	// ToolChangeCmd is the tool change command set: ToolChangeM0 (if empty) or ToolChangeM6.
+	ToolChangeCmd string
+	// Dialect is the name of the controller dialect, see RegisterDialect. It's DialectMarlin if empty.
+	Dialect string
+}
+
+// startHeight is the Z (in mm) the dispenser is raised to before the first move, well above the board.
+const startHeight = 10
+
+// Dispense generates the program body (which goes between the Emitter Header and Footer) plunging
+// to every center and opening the dispenser valve.
+func Dispense(e Emitter, c *Config, res []geom.Point) {
+	e.Retract(startHeight)
+	for _, p := range res {
+		e.Rapid(c.Frame.ToMachine(p))
+		e.Plunge(c.MillHeight)
+		e.Valve(true)
+		e.Dwell(c.DispenseTime)
+		e.Valve(false)
+		e.Retract(c.SafeHeight)
+	}
+}
+
+// HatchLines returns the laser hatching segments (in the base image space) covering
+// all non-background pixels of the base image. Every other line is reversed, so the
+// laser head goes back and forth.
+func HatchLines(base stencilimg.PixelMask, pxSize, spacing float64) [][2]geom.Point {
+	var lines [][2]geom.Point
+	height := float64(base.Bounds().Dy()) * pxSize
+	for k := 0; ; k++ {
+		y := (float64(k) + 0.5) * spacing
+		if y >= height {
+			break
+		}
+		cy := int(y / pxSize)
+		w := base.Bounds().Dx()
+		var segs [][2]geom.Point
+		for cx := 0; cx < w; {
+			if !base.Get(cx, cy) {
+				cx++
+				continue
+			}
+			a := cx
+			for cx < w && base.Get(cx, cy) {
+				cx++
+			}
+			segs = append(segs, [2]geom.Point{geom.Pt(float64(a)*pxSize, y), geom.Pt(float64(cx)*pxSize, y)})
+		}
+		if k%2 == 1 {
+			for i, j := 0, len(segs)-1; i <= j; i, j = i+1, j-1 {
+				segs[i], segs[j] = [2]geom.Point{segs[j][1], segs[j][0]}, [2]geom.Point{segs[i][1], segs[i][0]}
+			}
+		}
+		lines = append(lines, segs...)
+	}
+	return lines
+}
+
+// Laser generates the program body hatching all apertures of the base image (with the given
+// pixel size) with the laser, with no Z moves.
+func Laser(e Emitter, c *Config, base stencilimg.PixelMask, pxSize float64) {
+	lines := HatchLines(base, pxSize, c.HatchSpacing)
+
+	e.Laser(false)
+	for pass := 0; pass < c.Passes; pass++ {
+		for _, l := range lines {
+			e.Rapid(c.Frame.ToMachine(l[0]))
+			e.Laser(true)
+			e.Feed(c.Frame.ToMachine(l[1]))
+			e.Laser(false)
+		}
+	}
+}
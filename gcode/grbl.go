@@ -0,0 +1,120 @@
+package gcode
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/krasin/png2stencil/geom"
+)
+
+// DialectGRBL is the dialect of the GRBL controllers: the travel moves are G0 at the machine
+// rapid rate, the dwell is in seconds, the dispenser valve is driven by the flood coolant
+// output, and the tool change is a program pause, since GRBL has no M6. The laser is always
+// switched with M3 S/M5.
+const DialectGRBL = "grbl"
+
+type grbl struct {
+	add func(code string)
+	c   *Config
+}
+
+func newGRBL(add func(code string), c *Config) Emitter {
+	return &grbl{add, c}
+}
+
+func (e *grbl) Header() {
+	e.add("G21; Set units to millimeters")
+	e.add("G90; Absolute positioning")
+}
+
+func (e *grbl) Footer() {
+	e.add("M5")
+	e.add("M9")
+	e.add("M2")
+}
+
+func (e *grbl) Rapid(p geom.Point) {
+	e.add(fmt.Sprintf("G0 X%f Y%f", p.X, p.Y))
+}
+
+func (e *grbl) Feed(p geom.Point) {
+	e.add(fmt.Sprintf("G1 X%f Y%f F%f", p.X, p.Y, e.c.MillRate))
+}
+
+func (e *grbl) Arc(from, center geom.Point, ccw bool) {
+	g := 2
+	if ccw {
+		g = 3
+	}
+	e.add(fmt.Sprintf("G%d X%f Y%f I%f J%f F%f", g, from.X, from.Y, center.X-from.X, center.Y-from.Y, e.c.MillRate))
+}
+
+func (e *grbl) Plunge(z float64) {
+	e.add(fmt.Sprintf("G1 Z%f F%f", z+e.c.ZOffset, e.c.MillRate))
+}
+
+func (e *grbl) Retract(z float64) {
+	e.add(fmt.Sprintf("G0 Z%f", z+e.c.ZOffset))
+}
+
+// ToolChange turns the spindle off, and pauses with the prompt in a comment, which the senders show.
+func (e *grbl) ToolChange(t Tool) {
+	e.add("M5")
+	if e.c.ToolChangeCmd == ToolChangeM6 {
+		e.Comment(toolPrompt(t))
+		e.add(fmt.Sprintf("M6 T%d", t.Number))
+		return
+	}
+	e.add("M0 " + grblComment(toolPrompt(t)))
+}
+
+func (e *grbl) Dwell(d time.Duration) {
+	e.add(fmt.Sprintf("G4 P%.3f", d.Seconds()))
+}
+
+func (e *grbl) Valve(open bool) {
+	if open {
+		e.add("M8")
+	} else {
+		e.add("M9")
+	}
+}
+
+func (e *grbl) Laser(on bool) {
+	if on {
+		e.add(fmt.Sprintf("M3 S%d", e.c.LaserPower))
+	} else {
+		e.add("M5")
+	}
+}
+
+// Probe relies on GRBL reporting the touch position as [PRB:...].
+func (e *grbl) Probe(z float64) {
+	e.add(fmt.Sprintf("G38.2 Z%f F%f", z+e.c.ZOffset, e.c.ProbeRate))
+}
+
+func (e *grbl) Spindle(rpm float64) {
+	if rpm > 0 {
+		e.add(fmt.Sprintf("M3 S%g", rpm))
+	} else {
+		e.add("M5")
+	}
+}
+
+func (e *grbl) Coolant(on bool) {
+	if code := coolantCode(e.c.Coolant, on); code != "" {
+		e.add(code)
+	}
+}
+
+// Comment writes a parenthesized comment, which is the GRBL standard. The parentheses can't
+// be nested, so the ones in the text become brackets.
+func (e *grbl) Comment(text string) {
+	e.add(grblComment(text))
+}
+
+// grblComment returns the text as a comment, with the parentheses, which can't be nested, as brackets.
+func grblComment(text string) string {
+	return "(" + strings.NewReplacer("(", "[", ")", "]").Replace(text) + ")"
+}
@@ -0,0 +1,64 @@
+package gcode
+
+import (
+	"math"
+
+	"github.com/krasin/png2stencil/geom"
+)
+
+// holeSagitta is the largest distance (in mm) between a hole circle and the polygon cutting it.
+const holeSagitta = 0.005
+
+// HoleVertices returns the polygon approximating the circle, starting and ending at the rightmost point.
+func HoleVertices(center geom.Point, r float64) []geom.Point {
+	n := 8
+	if r > holeSagitta {
+		n = int(math.Max(float64(n), math.Ceil(math.Pi/math.Acos(1-holeSagitta/r))))
+	}
+	pts := make([]geom.Point, n+1)
+	for i := 0; i < n; i++ {
+		a := 2 * math.Pi * float64(i) / float64(n)
+		pts[i] = geom.Pt(center.X+r*math.Cos(a), center.Y+r*math.Sin(a))
+	}
+	pts[n] = pts[0]
+	return pts
+}
+
+// Holes generates the program body cutting the circles with the diameter around the centers
+// (in the machine space): at the mill height, or with the laser, with no Z moves. The tool path is
+// inside of the circles by the half of toolDiameter; the holes the tool fills are just plunged.
+// The circles are arcs with a Config ArcTolerance, and polygons otherwise.
+func Holes(e Emitter, c *Config, centers []geom.Point, diameter, toolDiameter float64, laser bool) {
+	if laser {
+		e.Laser(false)
+	} else {
+		e.Retract(c.SafeHeight)
+	}
+	r := (diameter - toolDiameter) / 2
+	for _, center := range centers {
+		pts := []geom.Point{center}
+		if r > 0 {
+			pts = HoleVertices(center, r)
+		}
+		e.Rapid(pts[0])
+		if laser {
+			e.Laser(true)
+		} else {
+			e.Plunge(c.MillHeight)
+		}
+		switch {
+		case len(pts) == 1:
+		case c.ArcTolerance > 0:
+			e.Arc(pts[0], center, true)
+		default:
+			for _, p := range pts[1:] {
+				e.Feed(p)
+			}
+		}
+		if laser {
+			e.Laser(false)
+		} else {
+			e.Retract(c.SafeHeight)
+		}
+	}
+}
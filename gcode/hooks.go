@@ -0,0 +1,149 @@
+package gcode
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"text/template"
+)
+
+// Hooks are the snippets of the user added to the programs at the points of their run, like
+// turning the vacuum on and off, or pausing for a paste refill. The snippets are text/template
+// templates executed with HookData, and may have several lines. They are added as they are,
+// whatever the dialect.
+type Hooks struct {
+	// FirstCut goes before the first cut: the first plunge, or the first time the laser is on.
+	FirstCut string
+	// BeforeToolChange and AfterToolChange go around every tool change.
+	BeforeToolChange, AfterToolChange string
+	// Every goes after every EveryN cuts, once the tool is retracted or the laser is off.
+	Every  string
+	EveryN int
+	// End goes at the end of the program, before the footer.
+	End string
+}
+
+// HookData is what the Hooks templates are executed with.
+type HookData struct {
+	// Cuts is the number of the cuts so far.
+	Cuts int
+	// Tool is the number of the tool changed to, or 0.
+	Tool int
+}
+
+// Validate checks the templates.
+func (h *Hooks) Validate() error {
+	_, err := newHooked(nil, nil, h)
+	return err
+}
+
+// hooked adds the Hooks to the program of an emitter.
+type hooked struct {
+	Emitter
+	add  func(code string)
+	cuts int
+	// cutting tells that a cut is started, but not finished.
+	cutting bool
+
+	firstCut, beforeToolChange, afterToolChange, every, end *template.Template
+	everyN                                                  int
+}
+
+func newHooked(e Emitter, add func(code string), h *Hooks) (*hooked, error) {
+	res := &hooked{Emitter: e, add: add, everyN: h.EveryN}
+	for _, t := range []struct {
+		name string
+		text string
+		dst  **template.Template
+	}{
+		{"first cut", h.FirstCut, &res.firstCut},
+		{"before tool change", h.BeforeToolChange, &res.beforeToolChange},
+		{"after tool change", h.AfterToolChange, &res.afterToolChange},
+		{"every", h.Every, &res.every},
+		{"end", h.End, &res.end},
+	} {
+		if t.text == "" {
+			continue
+		}
+		tmpl, err := template.New(t.name).Parse(t.text)
+		if err == nil {
+			// The wrong field names only show up when executed.
+			err = tmpl.Execute(io.Discard, HookData{})
+		}
+		if err != nil {
+			return nil, fmt.Errorf("bad %s hook: %w", t.name, err)
+		}
+		*t.dst = tmpl
+	}
+	if res.every != nil && res.everyN < 1 {
+		return nil, fmt.Errorf("the every hook needs a positive number of cuts")
+	}
+	return res, nil
+}
+
+// run adds the lines of the hook, if it's set.
+func (e *hooked) run(t *template.Template, tool int) {
+	if t == nil {
+		return
+	}
+	var buf bytes.Buffer
+	// The templates are checked by newHooked, so they don't fail.
+	t.Execute(&buf, HookData{Cuts: e.cuts, Tool: tool})
+	for _, line := range strings.Split(buf.String(), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			e.add(line)
+		}
+	}
+}
+
+func (e *hooked) startCut() {
+	if e.cuts == 0 {
+		e.run(e.firstCut, 0)
+	}
+	e.cuts++
+	e.cutting = true
+}
+
+func (e *hooked) endCut() {
+	if !e.cutting {
+		return
+	}
+	e.cutting = false
+	if e.every != nil && e.cuts%e.everyN == 0 {
+		e.run(e.every, 0)
+	}
+}
+
+func (e *hooked) Plunge(z float64) {
+	if !e.cutting {
+		e.startCut()
+	}
+	e.Emitter.Plunge(z)
+}
+
+func (e *hooked) Retract(z float64) {
+	e.Emitter.Retract(z)
+	e.endCut()
+}
+
+func (e *hooked) Laser(on bool) {
+	if on && !e.cutting {
+		e.startCut()
+	}
+	e.Emitter.Laser(on)
+	if !on {
+		e.endCut()
+	}
+}
+
+func (e *hooked) ToolChange(t Tool) {
+	e.run(e.beforeToolChange, t.Number)
+	e.Emitter.ToolChange(t)
+	e.run(e.afterToolChange, t.Number)
+}
+
+func (e *hooked) Footer() {
+	e.run(e.end, 0)
+	e.Emitter.Footer()
+}
@@ -0,0 +1,107 @@
+package gcode
+
+import (
+	"math"
+
+	"github.com/krasin/png2stencil/geom"
+)
+
+// knifePath compensates a closed contour for a swivel knife, which blade tip trails
+// the knife axis by offset. The axis is moved ahead of the contour by offset along the
+// cutting direction, and at the corners sharper than minAngle (in radians) it travels
+// an arc around the corner point, so the blade swivels in place instead of the corner being rounded.
+func knifePath(poly []geom.Point, offset, minAngle float64) []geom.Point {
+	if len(poly) < 2 || offset <= 0 {
+		return append(append([]geom.Point{}, poly...), poly[0])
+	}
+	dir := func(i int) (float64, float64) {
+		a, b := poly[i%len(poly)], poly[(i+1)%len(poly)]
+		l := math.Hypot(b.X-a.X, b.Y-a.Y)
+		return (b.X - a.X) / l, (b.Y - a.Y) / l
+	}
+	ux, uy := dir(0)
+	res := []geom.Point{geom.Pt(poly[0].X+offset*ux, poly[0].Y+offset*uy)}
+	for i := 1; i <= len(poly); i++ {
+		p := poly[i%len(poly)]
+		vx, vy := dir(i)
+		res = append(res, geom.Pt(p.X+offset*ux, p.Y+offset*uy))
+		a0 := math.Atan2(uy, ux)
+		turn := math.Atan2(ux*vy-uy*vx, ux*vx+uy*vy)
+		if math.Abs(turn) >= minAngle {
+			steps := int(math.Ceil(math.Abs(turn) / (10 * math.Pi / 180)))
+			for s := 1; s <= steps; s++ {
+				a := a0 + turn*float64(s)/float64(steps)
+				res = append(res, geom.Pt(p.X+offset*math.Cos(a), p.Y+offset*math.Sin(a)))
+			}
+		} else {
+			res = append(res, geom.Pt(p.X+offset*vx, p.Y+offset*vy))
+		}
+		ux, uy = vx, vy
+	}
+	return res
+}
+
+// knifeCircle returns the knife path of the closed contour if it's within tol (in mm) of a circle:
+// the circle the knife axis travels with the blade tip trailing it by offset on the fitted one,
+// in the direction of the contour.
+func knifeCircle(poly []geom.Point, offset, tol float64) ([]geom.Point, bool) {
+	center, r, ccw, ok := fitCircle(append(append([]geom.Point{}, poly...), poly[0]), tol)
+	if !ok {
+		return nil, false
+	}
+	pts := HoleVertices(center, math.Hypot(r, offset))
+	if !ccw {
+		for i, j := 0, len(pts)-1; i < j; i, j = i+1, j-1 {
+			pts[i], pts[j] = pts[j], pts[i]
+		}
+	}
+	return pts, true
+}
+
+// KnifePaths returns the compensated knife paths for all aperture contours of the base image,
+// see knifePath. If arcTol is positive, the contours within it (in mm) of a circle, like the ones
+// of the round pads, are replaced with the circles, see knifeCircle, which Knife cuts as arcs.
+func KnifePaths(contours [][]geom.Point, offset, minAngle, arcTol float64) [][]geom.Point {
+	var paths [][]geom.Point
+	for _, c := range contours {
+		if arcTol > 0 {
+			if path, ok := knifeCircle(c, offset, arcTol); ok {
+				paths = append(paths, path)
+				continue
+			}
+		}
+		paths = append(paths, knifePath(c, offset, minAngle))
+	}
+	return paths
+}
+
+// Knife generates the program body dragging the knife along every path at the mill height.
+// With a Config ArcTolerance, the paths within it of a circle, like the ones of KnifePaths, are cut as
+// a single arc.
+func Knife(e Emitter, c *Config, paths [][]geom.Point) {
+	e.Retract(c.SafeHeight)
+	for _, path := range paths {
+		pts := make([]geom.Point, len(path))
+		for i, p := range path {
+			pts[i] = c.Frame.ToMachine(p)
+		}
+		if c.ArcTolerance > 0 {
+			if center, r, ccw, ok := fitCircle(pts, c.ArcTolerance); ok {
+				// The arc starts on the circle, toward the path start.
+				d := math.Hypot(pts[0].X-center.X, pts[0].Y-center.Y)
+				from := geom.Pt(center.X+(pts[0].X-center.X)*r/d, center.Y+(pts[0].Y-center.Y)*r/d)
+				e.Rapid(from)
+				e.Plunge(c.MillHeight)
+				e.Arc(from, center, ccw)
+				e.Retract(c.SafeHeight)
+				continue
+			}
+		}
+		e.Rapid(pts[0])
+		e.Plunge(c.MillHeight)
+		for _, p := range pts[1:] {
+			e.Feed(p)
+		}
+		e.Retract(c.SafeHeight)
+	}
+}
@@ -0,0 +1,264 @@
+package gcode
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/krasin/png2stencil/geom"
+)
+
+// HeightMap is the height of the work surface probed on a rectilinear grid (in the machine space),
+// relative to the Z zero.
+type HeightMap struct {
+	// xs and ys are the grid lines in the ascending order, and z[j][i] is the height at (xs[i], ys[j]).
+	xs, ys []float64
+	z      [][]float64
+}
+
+// ParseHeightMap reads the probed points, one "x y z" per line, separated by spaces or commas.
+// The empty lines, the # comments and a header line (like "x,y,z") are skipped. The points must
+// cover a rectilinear grid, each grid point once, in any order.
+func ParseHeightMap(r io.Reader) (*HeightMap, error) {
+	var pts []geom.Vec3
+	sc := bufio.NewScanner(r)
+	for line := 1; sc.Scan(); line++ {
+		s := sc.Text()
+		if i := strings.IndexByte(s, '#'); i >= 0 {
+			s = s[:i]
+		}
+		fields := strings.FieldsFunc(s, func(c rune) bool { return c == ',' || c == ' ' || c == '\t' })
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("line %d: expected x y z, got %q", line, s)
+		}
+		var v [3]float64
+		var err error
+		for i, f := range fields {
+			if v[i], err = strconv.ParseFloat(f, 64); err != nil {
+				break
+			}
+		}
+		if err != nil {
+			if len(pts) == 0 {
+				// The header.
+				continue
+			}
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		pts = append(pts, geom.Vec(v[0], v[1], v[2]))
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	if len(pts) == 0 {
+		return nil, fmt.Errorf("no points")
+	}
+	return newHeightMap(pts)
+}
+
+// gridEps is the distance within which the probed coordinates are on the same grid line (in mm).
+const gridEps = 1e-3
+
+func newHeightMap(pts []geom.Vec3) (*HeightMap, error) {
+	gridLines := func(coord func(p geom.Vec3) float64) []float64 {
+		var res []float64
+		for _, p := range pts {
+			res = append(res, coord(p))
+		}
+		sort.Float64s(res)
+		k := 0
+		for _, v := range res {
+			if k == 0 || v-res[k-1] > gridEps {
+				res[k] = v
+				k++
+			}
+		}
+		return res[:k]
+	}
+	m := &HeightMap{
+		xs: gridLines(func(p geom.Vec3) float64 { return p.X }),
+		ys: gridLines(func(p geom.Vec3) float64 { return p.Y }),
+	}
+	if len(m.xs)*len(m.ys) != len(pts) {
+		return nil, fmt.Errorf("%d points don't make a grid of %d by %d", len(pts), len(m.xs), len(m.ys))
+	}
+	index := func(lines []float64, v float64) int {
+		i := sort.SearchFloat64s(lines, v-gridEps)
+		if i == len(lines) || lines[i]-v > gridEps {
+			return -1
+		}
+		return i
+	}
+	m.z = make([][]float64, len(m.ys))
+	set := make([][]bool, len(m.ys))
+	for j := range m.z {
+		m.z[j] = make([]float64, len(m.xs))
+		set[j] = make([]bool, len(m.xs))
+	}
+	for _, p := range pts {
+		i, j := index(m.xs, p.X), index(m.ys, p.Y)
+		if i < 0 || j < 0 || set[j][i] {
+			return nil, fmt.Errorf("the point %f, %f is off the grid of %d by %d or probed twice", p.X, p.Y, len(m.xs), len(m.ys))
+		}
+		m.z[j][i], set[j][i] = p.Z, true
+	}
+	return m, nil
+}
+
+// Contains tells if the point is within the probed area.
+func (m *HeightMap) Contains(p geom.Point) bool {
+	return p.X >= m.xs[0]-gridEps && p.X <= m.xs[len(m.xs)-1]+gridEps &&
+		p.Y >= m.ys[0]-gridEps && p.Y <= m.ys[len(m.ys)-1]+gridEps
+}
+
+// At returns the height at the point, interpolated bilinearly between the probed ones.
+// Outside of the probed area, it's the height at the nearest point of its edge.
+func (m *HeightMap) At(p geom.Point) float64 {
+	i, tx := cell(m.xs, p.X)
+	j, ty := cell(m.ys, p.Y)
+	z := func(i, j int) float64 {
+		return m.z[imin(j, len(m.ys)-1)][imin(i, len(m.xs)-1)]
+	}
+	return (1-ty)*((1-tx)*z(i, j)+tx*z(i+1, j)) + ty*((1-tx)*z(i, j+1)+tx*z(i+1, j+1))
+}
+
+// cell returns the index of the grid cell with v, clamped to the grid, and the position of v in it (0..1).
+func cell(lines []float64, v float64) (int, float64) {
+	if len(lines) == 1 || v <= lines[0] {
+		return 0, 0
+	}
+	if v >= lines[len(lines)-1] {
+		return len(lines) - 2, 1
+	}
+	i := sort.SearchFloat64s(lines, v) - 1
+	return i, (v - lines[i]) / (lines[i+1] - lines[i])
+}
+
+func imin(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// Leveler rewrites a program line by line, adding the height of the work surface to the Z
+// of every move, so the tool follows a bed which is not flat. The cutting moves are split into
+// segments, so Z follows the surface along them too; the travel moves (G0, or faster than the
+// mill rate, as in Verifier) are not, and the arcs are split into the straight cuts. The other
+// lines are passed as they are.
+type Leveler struct {
+	m       *HeightMap
+	c       *Config
+	segment float64
+	motion  int
+	pos     geom.Vec3
+	known   [3]bool
+	feed    float64
+	// Outside is the number of moves ending outside of the probed area.
+	Outside int
+}
+
+// NewLeveler returns a leveler of the programs made with the config, splitting the cutting moves
+// into segments no longer than segment (in mm).
+func NewLeveler(m *HeightMap, c *Config, segment float64) *Leveler {
+	return &Leveler{m: m, c: c, segment: segment, motion: -1}
+}
+
+// Feed passes the leveled line, or the lines it's split into, to add.
+func (l *Leveler) Feed(line string, add func(code string)) {
+	words := ParseLine(line)
+	to := l.pos
+	var set [3]bool
+	var offset geom.Point
+	var rest []string
+	for _, w := range words {
+		switch w.Letter {
+		case 'G':
+			if w.Value == 0 || w.Value == 1 || w.Value == 2 || w.Value == 3 {
+				l.motion = int(w.Value)
+			} else {
+				rest = append(rest, fmt.Sprintf("G%g", w.Value))
+			}
+		case 'X':
+			to.X, set[0] = w.Value, true
+		case 'Y':
+			to.Y, set[1] = w.Value, true
+		case 'Z':
+			to.Z, set[2] = w.Value, true
+		case 'I':
+			offset.X = w.Value
+		case 'J':
+			offset.Y = w.Value
+		case 'F':
+			l.feed = w.Value
+			rest = append(rest, fmt.Sprintf("F%f", w.Value))
+		default:
+			rest = append(rest, fmt.Sprintf("%c%g", w.Letter, w.Value))
+		}
+	}
+	if l.motion < 0 || !(set[0] || set[1] || set[2]) {
+		add(line)
+		return
+	}
+	from, knownXY := l.pos, l.known[0] && l.known[1]
+	l.pos = to
+	for i := range set {
+		l.known[i] = l.known[i] || set[i]
+	}
+	if l.known[0] && l.known[1] && !l.m.Contains(geom.Pt(to.X, to.Y)) {
+		l.Outside++
+	}
+	if !l.known[2] {
+		// There's no Z to level until it's set.
+		add(line)
+		return
+	}
+	if l.motion < 2 || !knownXY {
+		l.line(l.motion, from, to, knownXY, rest, add)
+		return
+	}
+	center := geom.Pt(from.X+offset.X, from.Y+offset.Y)
+	pts := arcPoints(geom.Pt(from.X, from.Y), geom.Pt(to.X, to.Y), center, l.motion == 3)
+	for i, p := range pts {
+		q := geom.Vec(p.X, p.Y, from.Z+(to.Z-from.Z)*float64(i+1)/float64(len(pts)))
+		l.line(1, from, q, true, rest, add)
+		from, rest = q, nil
+	}
+}
+
+// line passes the leveled straight move of the motion from the point to the point to add, split
+// into the segments if it cuts, with the rest of the words after the first one.
+func (l *Leveler) line(motion int, from, to geom.Vec3, knownXY bool, rest []string, add func(code string)) {
+	n := 1
+	travel := motion == 0 || l.feed > l.c.MillRate
+	if d := math.Hypot(to.X-from.X, to.Y-from.Y); knownXY && !travel && l.segment > 0 && d > l.segment {
+		n = int(math.Ceil(d / l.segment))
+	}
+	for k := 1; k <= n; k++ {
+		t := float64(k) / float64(n)
+		p := geom.Vec(from.X+t*(to.X-from.X), from.Y+t*(to.Y-from.Y), from.Z+t*(to.Z-from.Z))
+		if k == n {
+			p = to
+		}
+		// The axes not set yet are left alone, and the height is taken at the origin until they are.
+		code := fmt.Sprintf("G%d", motion)
+		if l.known[0] {
+			code += fmt.Sprintf(" X%f", p.X)
+		}
+		if l.known[1] {
+			code += fmt.Sprintf(" Y%f", p.Y)
+		}
+		code += fmt.Sprintf(" Z%f", p.Z+l.m.At(geom.Pt(p.X, p.Y)))
+		if k == 1 && len(rest) > 0 {
+			code += " " + strings.Join(rest, " ")
+		}
+		add(code)
+	}
+}
@@ -0,0 +1,111 @@
+package gcode
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/krasin/png2stencil/geom"
+)
+
+// DialectMarlin is the dialect of the Marlin and RepRap firmwares of the 3D printers, which
+// the dispensers are usually built on: the travel moves are G1 at the travel rate, the dwell
+// is in milliseconds, and the dispenser valve is driven by the fan output.
+const DialectMarlin = "marlin"
+
+type marlin struct {
+	add func(code string)
+	c   *Config
+}
+
+func newMarlin(add func(code string), c *Config) Emitter {
+	return &marlin{add, c}
+}
+
+func (e *marlin) Header() {
+	e.add("G21; Set units to millimeters")
+}
+
+func (e *marlin) Footer() {}
+
+func (e *marlin) Rapid(p geom.Point) {
+	e.add(fmt.Sprintf("G1 X%f Y%f F%f", p.X, p.Y, e.c.TravelRate))
+}
+
+func (e *marlin) Feed(p geom.Point) {
+	e.add(fmt.Sprintf("G1 X%f Y%f F%f", p.X, p.Y, e.c.MillRate))
+}
+
+func (e *marlin) Arc(from, center geom.Point, ccw bool) {
+	g := 2
+	if ccw {
+		g = 3
+	}
+	e.add(fmt.Sprintf("G%d X%f Y%f I%f J%f F%f", g, from.X, from.Y, center.X-from.X, center.Y-from.Y, e.c.MillRate))
+}
+
+func (e *marlin) Plunge(z float64) {
+	e.add(fmt.Sprintf("G1 Z%f F%f", z+e.c.ZOffset, e.c.MillRate))
+}
+
+func (e *marlin) Retract(z float64) {
+	e.add(fmt.Sprintf("G1 Z%f F%f", z+e.c.ZOffset, e.c.TravelRate))
+}
+
+// ToolChange shows the prompt on the printer display with M0, or M117 before M6.
+func (e *marlin) ToolChange(t Tool) {
+	if e.c.ToolChangeCmd == ToolChangeM6 {
+		e.add("M117 " + toolPrompt(t))
+		e.add(fmt.Sprintf("M6 T%d", t.Number))
+		return
+	}
+	e.add("M0 " + toolPrompt(t))
+}
+
+func (e *marlin) Dwell(d time.Duration) {
+	e.add(fmt.Sprintf("G4 P%d", int64(d/time.Millisecond)))
+}
+
+func (e *marlin) Valve(open bool) {
+	if open {
+		e.add("M106 S255")
+	} else {
+		e.add("M107")
+	}
+}
+
+func (e *marlin) Laser(on bool) {
+	switch {
+	case on && e.c.LaserCmd == LaserM106:
+		e.add(fmt.Sprintf("M106 S%d", e.c.LaserPower))
+	case on:
+		e.add(fmt.Sprintf("M3 S%d", e.c.LaserPower))
+	case e.c.LaserCmd == LaserM106:
+		e.add("M107")
+	default:
+		e.add("M5")
+	}
+}
+
+// Probe reports the touch position with M114, as Marlin's G38.2 doesn't.
+func (e *marlin) Probe(z float64) {
+	e.add(fmt.Sprintf("G38.2 Z%f F%f", z+e.c.ZOffset, e.c.ProbeRate))
+	e.add("M114")
+}
+
+func (e *marlin) Spindle(rpm float64) {
+	if rpm > 0 {
+		e.add(fmt.Sprintf("M3 S%g", rpm))
+	} else {
+		e.add("M5")
+	}
+}
+
+func (e *marlin) Coolant(on bool) {
+	if code := coolantCode(e.c.Coolant, on); code != "" {
+		e.add(code)
+	}
+}
+
+func (e *marlin) Comment(text string) {
+	e.add("; " + text)
+}
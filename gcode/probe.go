@@ -0,0 +1,112 @@
+package gcode
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/krasin/png2stencil/geom"
+)
+
+// ProbeGrid returns the points of a grid over the rectangle with the corners a and b, with
+// the spacing at most the given one and the points on the edges, in the rows alternating
+// the direction, so the probe travels the least.
+func ProbeGrid(a, b geom.Point, spacing float64) []geom.Point {
+	min := geom.Pt(math.Min(a.X, b.X), math.Min(a.Y, b.Y))
+	max := geom.Pt(math.Max(a.X, b.X), math.Max(a.Y, b.Y))
+	steps := func(d float64) int { return imax(int(math.Ceil(d/spacing-1e-9)), 1) }
+	nx, ny := steps(max.X-min.X), steps(max.Y-min.Y)
+	var pts []geom.Point
+	for j := 0; j <= ny; j++ {
+		y := min.Y + (max.Y-min.Y)*float64(j)/float64(ny)
+		for k := 0; k <= nx; k++ {
+			i := k
+			if j%2 == 1 {
+				i = nx - k
+			}
+			pts = append(pts, geom.Pt(min.X+(max.X-min.X)*float64(i)/float64(nx), y))
+		}
+	}
+	return pts
+}
+
+// Probe generates the program body probing the surface at every point (in the machine space),
+// going down to depth below the Z zero at most, and raising to the safe height in between.
+func Probe(e Emitter, c *Config, pts []geom.Point, depth float64) {
+	e.Retract(c.SafeHeight)
+	for _, p := range pts {
+		e.Rapid(p)
+		e.Probe(-depth)
+		e.Retract(c.SafeHeight)
+	}
+}
+
+// ParseProbeLog reads the touch positions from the log of a probing program run, in the order
+// of the probes: the GRBL [PRB:x,y,z:1] reports, and the Marlin M114 ones, like
+// "X:10.00 Y:20.00 Z:-0.12 E:0.00 Count X:800 Y:1600 Z:-48". The other lines are skipped.
+// It fails on a GRBL probe which didn't touch.
+func ParseProbeLog(r io.Reader) ([]geom.Vec3, error) {
+	var res []geom.Vec3
+	sc := bufio.NewScanner(r)
+	for line := 1; sc.Scan(); line++ {
+		s := strings.TrimSpace(sc.Text())
+		if i := strings.Index(s, "[PRB:"); i >= 0 {
+			f := strings.Split(strings.TrimSuffix(s[i+len("[PRB:"):], "]"), ":")
+			if len(f) != 2 {
+				return nil, fmt.Errorf("line %d: bad probe report: %s", line, s)
+			}
+			if f[1] != "1" {
+				return nil, fmt.Errorf("line %d: probe %d didn't touch the surface", line, len(res)+1)
+			}
+			v, err := parseCoords(strings.Split(f[0], ","))
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", line, err)
+			}
+			res = append(res, v)
+			continue
+		}
+		// The M114 report starts with X:, and the Count part repeats the axes in steps.
+		if !strings.HasPrefix(s, "X:") {
+			continue
+		}
+		if i := strings.Index(s, "Count"); i >= 0 {
+			s = s[:i]
+		}
+		var coords []string
+		for _, f := range strings.Fields(s) {
+			if len(f) > 2 && f[1] == ':' && strings.IndexByte("XYZ", f[0]) >= 0 {
+				coords = append(coords, f[2:])
+			}
+		}
+		v, err := parseCoords(coords)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		res = append(res, v)
+	}
+	return res, sc.Err()
+}
+
+func parseCoords(f []string) (geom.Vec3, error) {
+	if len(f) != 3 {
+		return geom.Vec3{}, fmt.Errorf("expected x, y and z, got %d values", len(f))
+	}
+	var v [3]float64
+	for i := range f {
+		var err error
+		if v[i], err = strconv.ParseFloat(f[i], 64); err != nil {
+			return geom.Vec3{}, err
+		}
+	}
+	return geom.Vec(v[0], v[1], v[2]), nil
+}
+
+func imax(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,216 @@
+package gcode
+
+import (
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/krasin/png2stencil/geom"
+)
+
+// Word is a single letter address with its value, like X1.5.
+type Word struct {
+	Letter byte
+	Value  float64
+}
+
+// ParseLine splits a G-code line into words, ignoring ; and (...) comments.
+func ParseLine(line string) []Word {
+	if i := strings.IndexByte(line, ';'); i >= 0 {
+		line = line[:i]
+	}
+	var words []Word
+	for i := 0; i < len(line); {
+		c := line[i]
+		switch {
+		case c == '(':
+			j := strings.IndexByte(line[i:], ')')
+			if j < 0 {
+				return words
+			}
+			i += j + 1
+		case c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z':
+			j := i + 1
+			for j < len(line) && strings.IndexByte("+-.0123456789", line[j]) >= 0 {
+				j++
+			}
+			v, _ := strconv.ParseFloat(line[i+1:j], 64)
+			if c >= 'a' {
+				c -= 'a' - 'A'
+			}
+			words = append(words, Word{c, v})
+			i = j
+		default:
+			i++
+		}
+	}
+	return words
+}
+
+// Motion is a single linear move of the simulated machine. The arcs are split into the straight
+// moves, each with the line of the arc.
+type Motion struct {
+	Line     int    // 1-based line number
+	Code     string // the line itself
+	Rapid    bool   // G0
+	From, To geom.Vec3
+	// Known tells for X, Y and Z if the axis was set at least once before the move, so From
+	// is not reliable along the others. The laser programs never set Z, for one.
+	Known [3]bool
+	// ToKnown tells for X, Y and Z if the axis of To was set.
+	ToKnown [3]bool
+	Feed    float64 // mm/min, 0 if not set
+}
+
+// KnownXY tells if X and Y of both From and To are known.
+func (m *Motion) KnownXY() bool {
+	return m.Known[0] && m.Known[1]
+}
+
+// Sim tracks the machine state over a G-code program.
+type Sim struct {
+	pos   geom.Vec3
+	known [3]bool
+	feed  float64
+	line  int
+	// Dwell is the total dwell time in seconds.
+	Dwell float64
+	// DwellSeconds tells that the P word of G4 is in seconds, as in DialectGRBL, rather than in milliseconds.
+	DwellSeconds bool
+	// OnMotion, if set, is called for every linear move, and the moves the arcs are split into.
+	OnMotion func(m Motion)
+}
+
+// Feed processes a single program line.
+func (s *Sim) Feed(line string) {
+	s.line++
+	words := ParseLine(line)
+	motion := -1
+	dwell := false
+	to := s.pos
+	var set [3]bool
+	var offset geom.Point
+	for _, w := range words {
+		switch w.Letter {
+		case 'G':
+			switch w.Value {
+			case 0, 1, 2, 3:
+				motion = int(w.Value)
+			case 4:
+				dwell = true
+			}
+		case 'X':
+			to.X, set[0] = w.Value, true
+		case 'Y':
+			to.Y, set[1] = w.Value, true
+		case 'Z':
+			to.Z, set[2] = w.Value, true
+		case 'I':
+			offset.X = w.Value
+		case 'J':
+			offset.Y = w.Value
+		case 'F':
+			s.feed = w.Value
+		case 'P':
+			if dwell && s.DwellSeconds {
+				s.Dwell += w.Value
+			} else if dwell {
+				s.Dwell += w.Value / 1000
+			}
+		case 'S':
+			if dwell {
+				s.Dwell += w.Value
+			}
+		}
+	}
+	if motion < 0 || !(set[0] || set[1] || set[2]) {
+		return
+	}
+	m := Motion{
+		Line:  s.line,
+		Code:  line,
+		Rapid: motion == 0,
+		From:  s.pos,
+		To:    to,
+		Known: s.known,
+		Feed:  s.feed,
+	}
+	for i := range set {
+		s.known[i] = s.known[i] || set[i]
+	}
+	m.ToKnown = s.known
+	s.pos = to
+	if s.OnMotion == nil {
+		return
+	}
+	if motion < 2 || !m.KnownXY() {
+		s.OnMotion(m)
+		return
+	}
+	start := m.From
+	center := geom.Pt(start.X+offset.X, start.Y+offset.Y)
+	pts := arcPoints(geom.Pt(start.X, start.Y), geom.Pt(to.X, to.Y), center, motion == 3)
+	for i, p := range pts {
+		m.To = geom.Vec(p.X, p.Y, start.Z+(to.Z-start.Z)*float64(i+1)/float64(len(pts)))
+		s.OnMotion(m)
+		m.From = m.To
+	}
+}
+
+// Stats are the estimates collected from a simulated program.
+type Stats struct {
+	Seconds float64
+	// Bounds of all move end points (in the machine space). Z is 0 if it was never set.
+	Min, Max geom.Vec3
+	Moves    int
+}
+
+// Estimator simulates a program line by line to estimate its run time and extents.
+// Rapid moves without a feed rate use the rapid rate (mm/min). Acceleration is ignored,
+// so the estimate is a lower bound.
+type Estimator struct {
+	Sim
+	st Stats
+}
+
+func NewEstimator(rapidRate float64) *Estimator {
+	e := &Estimator{st: Stats{
+		Min: geom.Vec(math.Inf(1), math.Inf(1), math.Inf(1)),
+		Max: geom.Vec(math.Inf(-1), math.Inf(-1), math.Inf(-1)),
+	}}
+	st := &e.st
+	e.OnMotion = func(m Motion) {
+		if !m.ToKnown[0] || !m.ToKnown[1] {
+			return
+		}
+		st.Moves++
+		st.Min.X, st.Max.X = math.Min(st.Min.X, m.To.X), math.Max(st.Max.X, m.To.X)
+		st.Min.Y, st.Max.Y = math.Min(st.Min.Y, m.To.Y), math.Max(st.Max.Y, m.To.Y)
+		if m.ToKnown[2] {
+			st.Min.Z, st.Max.Z = math.Min(st.Min.Z, m.To.Z), math.Max(st.Max.Z, m.To.Z)
+		}
+		if !m.KnownXY() {
+			return
+		}
+		feed := m.Feed
+		if m.Rapid || feed <= 0 {
+			feed = rapidRate
+		}
+		d := m.To.Sub(m.From)
+		if !m.Known[2] {
+			d.Z = 0
+		}
+		st.Seconds += math.Sqrt(d.Dot(d)) / feed * 60
+	}
+	return e
+}
+
+// Stats returns the estimates of the lines fed so far.
+func (e *Estimator) Stats() Stats {
+	st := e.st
+	st.Seconds += e.Dwell
+	if math.IsInf(st.Min.Z, 1) {
+		st.Min.Z, st.Max.Z = 0, 0
+	}
+	return st
+}
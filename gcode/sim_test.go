@@ -0,0 +1,61 @@
+package gcode
+
+import (
+	"math"
+	"testing"
+)
+
+func TestEstimatorLaser(t *testing.T) {
+	// The laser programs never set Z.
+	prog := []string{
+		"G21",
+		"G90",
+		"G0 X0 Y0",
+		"M3 S255",
+		"G1 X10 Y0 F600",
+		"G1 X10 Y20",
+		"M5",
+		"G0 X0 Y0",
+	}
+	e := NewEstimator(1200)
+	for _, line := range prog {
+		e.Feed(line)
+	}
+	st := e.Stats()
+	// 30 mm at 600 mm/min and 22.36 mm at 1200 mm/min.
+	want := 3 + math.Hypot(10, 20)/20
+	if math.Abs(st.Seconds-want) > 1e-9 {
+		t.Errorf("Seconds: got %f, want %f", st.Seconds, want)
+	}
+	if st.Moves != 4 {
+		t.Errorf("Moves: got %d, want 4", st.Moves)
+	}
+	if st.Min.X != 0 || st.Min.Y != 0 || st.Max.X != 10 || st.Max.Y != 20 {
+		t.Errorf("bounds: got %v - %v, want (0, 0) - (10, 20)", st.Min, st.Max)
+	}
+	if st.Min.Z != 0 || st.Max.Z != 0 {
+		t.Errorf("Z bounds: got %f - %f, want 0 - 0", st.Min.Z, st.Max.Z)
+	}
+}
+
+func TestEstimatorMill(t *testing.T) {
+	prog := []string{
+		"G0 Z5",
+		"G0 X0 Y0",
+		"G1 Z-1 F60",
+		"G1 X6 Y8 F600",
+	}
+	e := NewEstimator(1200)
+	for _, line := range prog {
+		e.Feed(line)
+	}
+	st := e.Stats()
+	// The move to X0 Y0 has no known start; 6 mm down at 60 mm/min and 10 mm at 600 mm/min.
+	want := 6.0 + 1
+	if math.Abs(st.Seconds-want) > 1e-9 {
+		t.Errorf("Seconds: got %f, want %f", st.Seconds, want)
+	}
+	if st.Min.Z != -1 || st.Max.Z != 5 {
+		t.Errorf("Z bounds: got %f - %f, want -1 - 5", st.Min.Z, st.Max.Z)
+	}
+}
@@ -0,0 +1,135 @@
+package gcode
+
+import (
+	"fmt"
+	"unicode"
+
+	"github.com/krasin/png2stencil/geom"
+)
+
+// strokeFont is the single-stroke font of TextPaths, so every glyph is engraved as a few lines
+// rather than outlined. A glyph is its strokes separated by semicolons, each stroke a polyline
+// of the points xy on the grid 5 units wide and 7 high, with Y pointing up. The lowercase
+// letters are engraved as the uppercase ones.
+var strokeFont = map[rune]string{
+	' ': "",
+	'A': "00 04 26 44 40; 02 42",
+	'B': "00 06 36 45 44 33 03; 33 42 41 30 00",
+	'C': "45 36 16 05 01 10 30 41",
+	'D': "00 06 26 44 42 20 00",
+	'E': "40 00 06 46; 03 33",
+	'F': "00 06 46; 03 33",
+	'G': "45 36 16 05 01 10 30 41 43 23",
+	'H': "00 06; 40 46; 03 43",
+	'I': "10 30; 20 26; 16 36",
+	'J': "46 41 30 10 01 02",
+	'K': "00 06; 46 02; 13 40",
+	'L': "06 00 40",
+	'M': "00 06 23 46 40",
+	'N': "00 06 40 46",
+	'O': "10 01 05 16 36 45 41 30 10",
+	'P': "00 06 36 45 44 33 03",
+	'Q': "10 01 05 16 36 45 41 30 10; 22 40",
+	'R': "00 06 36 45 44 33 03; 23 40",
+	'S': "45 36 16 05 04 13 33 42 41 30 10 01",
+	'T': "06 46; 26 20",
+	'U': "06 01 10 30 41 46",
+	'V': "06 20 46",
+	'W': "06 10 23 30 46",
+	'X': "00 46; 06 40",
+	'Y': "06 23 46; 23 20",
+	'Z': "06 46 00 40",
+	'0': "10 01 05 16 36 45 41 30 10; 01 45",
+	'1': "14 26 20; 10 30",
+	'2': "05 16 36 45 44 00 40",
+	'3': "05 16 36 45 44 33 13; 33 42 41 30 10 01",
+	'4': "30 36 02 42",
+	'5': "46 06 04 34 43 41 30 10 01",
+	'6': "45 36 16 05 01 10 30 41 42 33 13 02",
+	'7': "06 46 10",
+	'8': "13 04 05 16 36 45 44 33 13 02 01 10 30 41 42 33",
+	'9': "01 10 30 41 45 36 16 05 04 13 33 44",
+	'-': "03 43",
+	'+': "03 43; 21 25",
+	'_': "00 40",
+	'.': "20 21",
+	',': "21 20 11",
+	':': "21 22; 24 25",
+	'/': "00 46",
+	'(': "36 14 12 30",
+	')': "16 34 32 10",
+	'#': "12 16; 32 36; 02 42; 04 44",
+}
+
+// fontCapHeight and fontAdvance are the height of the glyphs and the distance between
+// the starts of the neighbouring ones, in the strokeFont units.
+const (
+	fontCapHeight = 6
+	fontAdvance   = 6
+)
+
+// CheckText fails if the font has no glyph for a character of the text.
+func CheckText(text string) error {
+	for _, r := range text {
+		if _, ok := strokeFont[unicode.ToUpper(r)]; !ok {
+			return fmt.Errorf("no glyph for %q in the font", r)
+		}
+	}
+	return nil
+}
+
+// TextPaths returns the strokes engraving the text in the single-stroke font, with the glyphs
+// capHeight high (in mm) and the lower left corner of the first one at at. The characters the font
+// doesn't have are skipped, see CheckText.
+func TextPaths(text string, at geom.Point, capHeight float64) [][]geom.Point {
+	scale := capHeight / fontCapHeight
+	var paths [][]geom.Point
+	x := at.X
+	for _, r := range text {
+		glyph := strokeFont[unicode.ToUpper(r)]
+		var path []geom.Point
+		for i := 0; i < len(glyph); i++ {
+			switch c := glyph[i]; {
+			case c == ';':
+				paths = append(paths, path)
+				path = nil
+			case c >= '0' && c <= '9':
+				// The points of the glyphs are pairs of digits.
+				gx, gy := float64(c-'0'), float64(glyph[i+1]-'0')
+				path = append(path, geom.Pt(x+gx*scale, at.Y+gy*scale))
+				i++
+			}
+		}
+		if len(path) > 0 {
+			paths = append(paths, path)
+		}
+		x += fontAdvance * scale
+	}
+	return paths
+}
+
+// Engrave generates the program body cutting along the paths (in the machine space) at z,
+// or with the laser, with no Z moves.
+func Engrave(e Emitter, c *Config, paths [][]geom.Point, z float64, laser bool) {
+	if laser {
+		e.Laser(false)
+	} else {
+		e.Retract(c.SafeHeight)
+	}
+	for _, path := range paths {
+		e.Rapid(path[0])
+		if laser {
+			e.Laser(true)
+		} else {
+			e.Plunge(z)
+		}
+		for _, p := range path[1:] {
+			e.Feed(p)
+		}
+		if laser {
+			e.Laser(false)
+		} else {
+			e.Retract(c.SafeHeight)
+		}
+	}
+}
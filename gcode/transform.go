@@ -0,0 +1,130 @@
+package gcode
+
+import (
+	"fmt"
+	"math"
+	"math/cmplx"
+	"strings"
+
+	"github.com/krasin/png2stencil/geom"
+)
+
+// Transform is a similarity transform of the plane: a rotation, a uniform scale and a translation.
+// It's kept as complex numbers, with p' = S*p + T.
+type Transform struct {
+	S, T complex128
+}
+
+// FitTransform returns the transform taking the design points closest to the measured ones,
+// in the least squares sense, and the RMS distance between them after the transform.
+// It needs at least two distinct design points.
+func FitTransform(design, measured []geom.Point) (Transform, float64, error) {
+	if len(design) != len(measured) {
+		return Transform{}, 0, fmt.Errorf("%d design points, but %d measured ones", len(design), len(measured))
+	}
+	if len(design) < 2 {
+		return Transform{}, 0, fmt.Errorf("need at least two points, got %d", len(design))
+	}
+	c := func(p geom.Point) complex128 { return complex(p.X, p.Y) }
+	var dc, mc complex128
+	for i := range design {
+		dc += c(design[i])
+		mc += c(measured[i])
+	}
+	dc /= complex(float64(len(design)), 0)
+	mc /= complex(float64(len(design)), 0)
+	var num complex128
+	var den float64
+	for i := range design {
+		d, m := c(design[i])-dc, c(measured[i])-mc
+		num += cmplx.Conj(d) * m
+		den += real(d)*real(d) + imag(d)*imag(d)
+	}
+	if den < 1e-12 {
+		return Transform{}, 0, fmt.Errorf("the design points are all the same")
+	}
+	t := Transform{S: num / complex(den, 0)}
+	t.T = mc - t.S*dc
+	var sum float64
+	for i := range design {
+		sum += math.Pow(cmplx.Abs(c(t.Apply(design[i]))-c(measured[i])), 2)
+	}
+	return t, math.Sqrt(sum / float64(len(design))), nil
+}
+
+// Apply transforms the point.
+func (t Transform) Apply(p geom.Point) geom.Point {
+	q := t.S*complex(p.X, p.Y) + t.T
+	return geom.Pt(real(q), imag(q))
+}
+
+// Rotation returns the rotation angle (in degrees, counterclockwise).
+func (t Transform) Rotation() float64 {
+	return cmplx.Phase(t.S) * 180 / math.Pi
+}
+
+// Scale returns the scale factor.
+func (t Transform) Scale() float64 {
+	return cmplx.Abs(t.S)
+}
+
+// Transformer rewrites a program line by line, transforming the XY of every move, and the center
+// offsets of the arcs, so it cuts a blank placed on the bed slightly off. The other lines are passed
+// as they are.
+type Transformer struct {
+	t      Transform
+	motion int
+	pos    geom.Point
+	known  [2]bool
+}
+
+// NewTransformer returns a transformer of the programs with the transform.
+func NewTransformer(t Transform) *Transformer {
+	return &Transformer{t: t, motion: -1}
+}
+
+// Feed passes the transformed line to add.
+func (r *Transformer) Feed(line string, add func(code string)) {
+	words := ParseLine(line)
+	var set bool
+	var offset complex128
+	var rest []string
+	for _, w := range words {
+		switch w.Letter {
+		case 'G':
+			if w.Value == 0 || w.Value == 1 || w.Value == 2 || w.Value == 3 {
+				r.motion = int(w.Value)
+			} else {
+				rest = append(rest, fmt.Sprintf("G%g", w.Value))
+			}
+		case 'X':
+			r.pos.X, r.known[0], set = w.Value, true, true
+		case 'Y':
+			r.pos.Y, r.known[1], set = w.Value, true, true
+		case 'I':
+			offset += complex(w.Value, 0)
+		case 'J':
+			offset += complex(0, w.Value)
+		case 'Z', 'F':
+			rest = append(rest, fmt.Sprintf("%c%f", w.Letter, w.Value))
+		default:
+			rest = append(rest, fmt.Sprintf("%c%g", w.Letter, w.Value))
+		}
+	}
+	// A rotation mixes the axes, so the point is transformed only once both are known.
+	if r.motion < 0 || !set || !(r.known[0] && r.known[1]) {
+		add(line)
+		return
+	}
+	p := r.t.Apply(r.pos)
+	code := fmt.Sprintf("G%d X%f Y%f", r.motion, p.X, p.Y)
+	if r.motion >= 2 {
+		// The offset is a vector, so it's only rotated and scaled.
+		offset *= r.t.S
+		code += fmt.Sprintf(" I%f J%f", real(offset), imag(offset))
+	}
+	if len(rest) > 0 {
+		code += " " + strings.Join(rest, " ")
+	}
+	add(code)
+}
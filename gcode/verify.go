@@ -0,0 +1,98 @@
+package gcode
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/krasin/png2stencil/geom"
+)
+
+// verifyEps is the tolerance (in mm) for comparing coordinates against the limits.
+const verifyEps = 1e-6
+
+// Violation is a broken invariant of a generated program.
+type Violation struct {
+	Line int
+	Code string // the offending line
+	Msg  string
+}
+
+func (v Violation) String() string {
+	return fmt.Sprintf("line %d: %s", v.Line, v.Msg)
+}
+
+// Verifier re-parses a generated program line by line and checks that it never goes below
+// the mill height, that it's retracted to the safe height before travel moves, and that all moves
+// stay inside the image bounds (expanded by margin).
+type Verifier struct {
+	Sim
+	Violations []Violation
+}
+
+// NewVerifier returns a verifier of the programs within the rectangle with the corners a and b
+// (in the machine space). cutsXY tells if XY moves at the mill rate or slower are expected to cut;
+// otherwise every XY move is a travel. If checkZ is false (as in the laser mode), Z is not checked.
+func NewVerifier(c *Config, a, b geom.Point, margin float64, cutsXY, checkZ bool) *Verifier {
+	minX, maxX := math.Min(a.X, b.X)-margin-verifyEps, math.Max(a.X, b.X)+margin+verifyEps
+	minY, maxY := math.Min(a.Y, b.Y)-margin-verifyEps, math.Max(a.Y, b.Y)+margin+verifyEps
+
+	v := &Verifier{}
+	v.OnMotion = func(m Motion) {
+		add := func(format string, args ...interface{}) {
+			v.Violations = append(v.Violations, Violation{m.Line, m.Code, fmt.Sprintf(format, args...)})
+		}
+		// Every axis is checked once it's set: X and Y together, and Z on its own, since the
+		// laser programs never set it.
+		if checkZ && m.ToKnown[2] && m.To.Z < c.MillHeight+c.ZOffset-verifyEps {
+			add("Z%f is below the mill height %f", m.To.Z, c.MillHeight+c.ZOffset)
+		}
+		if !m.ToKnown[0] || !m.ToKnown[1] {
+			return
+		}
+		if m.To.X < minX || m.To.X > maxX || m.To.Y < minY || m.To.Y > maxY {
+			add("X%f Y%f is outside of the image bounds", m.To.X, m.To.Y)
+		}
+		if !m.KnownXY() || !m.Known[2] {
+			return
+		}
+		movesXY := m.From.X != m.To.X || m.From.Y != m.To.Y
+		travel := m.Rapid || !cutsXY || m.Feed > c.MillRate
+		if checkZ && movesXY && travel && math.Min(m.From.Z, m.To.Z) < c.SafeHeight+c.ZOffset-verifyEps {
+			add("travel move at Z%f, below the safe height %f", math.Min(m.From.Z, m.To.Z), c.SafeHeight+c.ZOffset)
+		}
+	}
+	return v
+}
+
+// LimitChecker collects the moves of a program which leave the machine envelope.
+// Only the moves along the offending axis are reported.
+type LimitChecker struct {
+	Sim
+	Violations []Violation
+}
+
+// NewLimitChecker returns a checker for the given limits. The limits set to NaN are not checked.
+func NewLimitChecker(maxX, maxY, minZ float64) *LimitChecker {
+	c := &LimitChecker{}
+	c.OnMotion = func(m Motion) {
+		var msgs []string
+		// An axis is checked once set, and then only where it moves.
+		moved := func(axis int, from, to float64) bool {
+			return m.ToKnown[axis] && (!m.Known[axis] || from != to)
+		}
+		if !math.IsNaN(maxX) && m.To.X > maxX+verifyEps && moved(0, m.From.X, m.To.X) {
+			msgs = append(msgs, fmt.Sprintf("X%f exceeds --max_x=%f", m.To.X, maxX))
+		}
+		if !math.IsNaN(maxY) && m.To.Y > maxY+verifyEps && moved(1, m.From.Y, m.To.Y) {
+			msgs = append(msgs, fmt.Sprintf("Y%f exceeds --max_y=%f", m.To.Y, maxY))
+		}
+		if !math.IsNaN(minZ) && m.To.Z < minZ-verifyEps && moved(2, m.From.Z, m.To.Z) {
+			msgs = append(msgs, fmt.Sprintf("Z%f is below --min_z=%f", m.To.Z, minZ))
+		}
+		if len(msgs) > 0 {
+			c.Violations = append(c.Violations, Violation{m.Line, m.Code, strings.Join(msgs, ", ")})
+		}
+	}
+	return c
+}
@@ -0,0 +1,41 @@
+package gcode
+
+import (
+	"testing"
+
+	"github.com/krasin/png2stencil/geom"
+)
+
+func TestVerifierLaserBounds(t *testing.T) {
+	c := &Config{TravelRate: 1000, MillRate: 100}
+	// The laser programs never set Z, so it's not checked.
+	v := NewVerifier(c, geom.Pt(0, 0), geom.Pt(10, 10), 0, true, false)
+	for _, line := range []string{
+		"G0 X1 Y1",
+		"M3 S255",
+		"G1 X9 Y1 F100",
+		"G1 X12 Y1",
+		"M5",
+	} {
+		v.Feed(line)
+	}
+	if len(v.Violations) != 1 || v.Violations[0].Line != 4 {
+		t.Fatalf("got violations %v, want one on line 4", v.Violations)
+	}
+}
+
+func TestVerifierFirstMove(t *testing.T) {
+	c := &Config{TravelRate: 1000, MillRate: 100, MillHeight: -0.1, SafeHeight: 2}
+	v := NewVerifier(c, geom.Pt(0, 0), geom.Pt(10, 10), 0, true, true)
+	// The start of the first move isn't known, but its end is.
+	for _, line := range []string{
+		"G0 Z2",
+		"G0 X-5 Y1",
+		"G0 X1 Y1",
+	} {
+		v.Feed(line)
+	}
+	if len(v.Violations) != 1 || v.Violations[0].Line != 2 {
+		t.Fatalf("got violations %v, want one on line 2", v.Violations)
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bufio"
+	"log/slog"
+	"math"
+	"os"
+
+	"github.com/krasin/png2stencil/gcode"
+	"github.com/krasin/png2stencil/geom"
+	"github.com/krasin/png2stencil/stencil"
+)
+
+// gcodeOutput streams a generated program to the output file, verifying and measuring it on
+// the way, so even a panel-scale program is never held in memory. The program goes to
+// a temporary file first, which replaces the output only once the program passed the checks.
+type gcodeOutput struct {
+	name string // empty in the dry run
+	f    *os.File
+	w    *bufio.Writer
+	err  error
+
+	// Lines is the number of lines written so far.
+	Lines int
+
+	verifier  *gcode.Verifier
+	limits    *gcode.LimitChecker
+	estimator *gcode.Estimator
+	// transformer, if not nil, fits the verified program to the --fiducials, and leveler
+	// adds the --height_map to it then.
+	transformer *gcode.Transformer
+	leveler     *gcode.Leveler
+}
+
+// newGCodeOutput creates the temporary file for the output name, unless it's empty.
+// cutsXY and margin are passed to the verifier, which checks the moves against the bounds lo and hi,
+// like the ones of stencilBounds.
+// If fid is not nil, the program is transformed with it after the verification, and then, if hm is not
+// nil, leveled with it. So the verifier checks the program as designed, and the limit checker as run.
+func newGCodeOutput(name string, cutsXY bool, margin float64, lo, hi geom.Point, fid *gcode.Transform, hm *gcode.HeightMap) (*gcodeOutput, error) {
+	// The moves in the positive direction are offset by the backlash.
+	margin += math.Max(*backlashX, *backlashY)
+	o := &gcodeOutput{
+		name:      name,
+		verifier:  gcode.NewVerifier(gcodeConfig(), lo, hi, margin, cutsXY, *mode != stencil.ModeLaser),
+		limits:    gcode.NewLimitChecker(*maxX, *maxY, *minZ),
+		estimator: gcode.NewEstimator(*travelRate),
+	}
+	o.estimator.DwellSeconds = *dialect == gcode.DialectGRBL
+	if fid != nil {
+		o.transformer = gcode.NewTransformer(*fid)
+	}
+	if hm != nil {
+		o.leveler = gcode.NewLeveler(hm, gcodeConfig(), *levelSegment)
+	}
+	if name == "" {
+		return o, nil
+	}
+	f, err := os.OpenFile(o.tmpName(), os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, errorf(exitWriteFailed, "failed to write result g-code file %q: %w", name, err)
+	}
+	o.f = f
+	o.w = bufio.NewWriter(f)
+	return o, nil
+}
+
+func (o *gcodeOutput) tmpName() string {
+	return o.name + ".tmp"
+}
+
+// add appends a line to the program. The lines are separated by newlines, with no newline after the last one.
+func (o *gcodeOutput) add(code string) {
+	o.verifier.Feed(code)
+	if o.transformer != nil {
+		o.transformer.Feed(code, o.level)
+		return
+	}
+	o.level(code)
+}
+
+// level passes the line to write, leveled if there's a height map.
+func (o *gcodeOutput) level(code string) {
+	if o.leveler != nil {
+		o.leveler.Feed(code, o.write)
+		return
+	}
+	o.write(code)
+}
+
+// write appends a line to the output, after the verification, the transform and the leveling.
+func (o *gcodeOutput) write(code string) {
+	o.limits.Feed(code)
+	o.estimator.Feed(code)
+	if o.w != nil && o.err == nil {
+		if o.Lines > 0 {
+			o.err = o.w.WriteByte('\n')
+		}
+		if o.err == nil {
+			_, o.err = o.w.WriteString(code)
+		}
+	}
+	o.Lines++
+}
+
+// Stats returns the estimated run time and extents of the program.
+func (o *gcodeOutput) Stats() gcode.Stats {
+	return o.estimator.Stats()
+}
+
+// check finishes writing the program and fails, removing the temporary file, if it can't
+// be written, breaks any invariant or exceeds the machine limits.
+func (o *gcodeOutput) check() error {
+	if o.f != nil {
+		if o.err == nil {
+			o.err = o.w.Flush()
+		}
+		if err := o.f.Close(); o.err == nil {
+			o.err = err
+		}
+		if o.err != nil || len(o.verifier.Violations) > 0 || len(o.limits.Violations) > 0 {
+			os.Remove(o.tmpName())
+		}
+		if o.err != nil {
+			return errorf(exitWriteFailed, "failed to write result g-code file %q: %w", o.name, o.err)
+		}
+	}
+	if err := checkGCode(o.verifier); err != nil {
+		return err
+	}
+	return checkLimits(o.limits)
+}
+
+// warn logs the warnings about the checked program.
+func (o *gcodeOutput) warn() {
+	if o.leveler != nil && o.leveler.Outside > 0 {
+		slog.Warn("Moves outside of the height map use the height at its edge", "moves", o.leveler.Outside)
+	}
+}
+
+// commit replaces the output file with the checked program.
+func (o *gcodeOutput) commit() error {
+	if err := os.Rename(o.tmpName(), o.name); err != nil {
+		return errorf(exitWriteFailed, "failed to write result g-code file %q: %w", o.name, err)
+	}
+	slog.Info("Saved G-code", "file", o.name, "lines", o.Lines)
+	return nil
+}
@@ -0,0 +1,38 @@
+package geom
+
+import "math"
+
+// Dedupe returns the indices of the points to keep, in order, dropping every point closer than
+// eps to one kept before it, so the coincident points are visited once. The points are hashed
+// into the cells of eps, so only the 3x3 cells around each one are compared.
+func Dedupe(pts []Point, eps float64) []int {
+	if !(eps > 0) {
+		res := make([]int, len(pts))
+		for i := range res {
+			res[i] = i
+		}
+		return res
+	}
+	type cell struct{ x, y int64 }
+	cellOf := func(p Point) cell {
+		return cell{int64(math.Floor(p.X / eps)), int64(math.Floor(p.Y / eps))}
+	}
+	kept := make(map[cell][]int)
+	var res []int
+next:
+	for i, p := range pts {
+		c := cellOf(p)
+		for dy := int64(-1); dy <= 1; dy++ {
+			for dx := int64(-1); dx <= 1; dx++ {
+				for _, j := range kept[cell{c.x + dx, c.y + dy}] {
+					if math.Hypot(pts[j].X-p.X, pts[j].Y-p.Y) < eps {
+						continue next
+					}
+				}
+			}
+		}
+		kept[c] = append(kept[c], i)
+		res = append(res, i)
+	}
+	return res
+}
@@ -0,0 +1,57 @@
+// Package geom has the geometric primitives shared by the stencil packages.
+package geom
+
+import "math"
+
+// Point is a point on the plane (in mm, unless stated otherwise).
+type Point struct {
+	X, Y float64
+}
+
+// Pt is shorthand for Point{X: x, Y: y}.
+func Pt(x, y float64) Point {
+	return Point{x, y}
+}
+
+// Vec3 is a point or a vector in the machine space.
+type Vec3 struct {
+	X, Y, Z float64
+}
+
+// Vec is shorthand for Vec3{X: x, Y: y, Z: z}.
+func Vec(x, y, z float64) Vec3 {
+	return Vec3{x, y, z}
+}
+
+func (a Vec3) Sub(b Vec3) Vec3 { return Vec3{a.X - b.X, a.Y - b.Y, a.Z - b.Z} }
+
+func (a Vec3) Cross(b Vec3) Vec3 {
+	return Vec3{a.Y*b.Z - a.Z*b.Y, a.Z*b.X - a.X*b.Z, a.X*b.Y - a.Y*b.X}
+}
+
+func (a Vec3) Dot(b Vec3) float64 { return a.X*b.X + a.Y*b.Y + a.Z*b.Z }
+
+// Inside tells if the point (x, y) is inside of the circle with the center (cx, cy) and the radius r.
+func Inside(cx, cy, r, x, y float64) bool {
+	return (x-cx)*(x-cx)+(y-cy)*(y-cy) <= r*r
+}
+
+// PathLength returns the length of the polyline.
+func PathLength(path []Point) float64 {
+	l := 0.0
+	for i := 1; i < len(path); i++ {
+		l += math.Hypot(path[i].X-path[i-1].X, path[i].Y-path[i-1].Y)
+	}
+	return l
+}
+
+// Frame converts the points from the image space (in mm, Y pointing down)
+// to the machine space (in mm, Y pointing up).
+type Frame struct {
+	// X and Height are the machine X and Y of the image space origin.
+	X, Height float64
+}
+
+func (f Frame) ToMachine(p Point) Point {
+	return Point{f.X + p.X, f.Height - p.Y}
+}
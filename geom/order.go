@@ -0,0 +1,139 @@
+package geom
+
+import "math"
+
+// pointGrid is a uniform grid index over a set of points, which supports removing the points
+// and finding the nearest remaining one. With about one point per cell, the nearest neighbor
+// search only looks at a few cells around the query, so ordering even a panel with the tens
+// of thousands of holes takes a fraction of a second.
+type pointGrid struct {
+	pts      []Point
+	min      Point
+	cell     float64
+	cols     int
+	rows     int
+	cells    [][]int // point indices in each cell
+	left     int
+	position []int // position of each point in its cell
+}
+
+func newPointGrid(pts []Point) *pointGrid {
+	g := &pointGrid{pts: pts, left: len(pts), position: make([]int, len(pts))}
+	if len(pts) == 0 {
+		return g
+	}
+	max := pts[0]
+	g.min = pts[0]
+	for _, p := range pts {
+		g.min.X = math.Min(g.min.X, p.X)
+		g.min.Y = math.Min(g.min.Y, p.Y)
+		max.X = math.Max(max.X, p.X)
+		max.Y = math.Max(max.Y, p.Y)
+	}
+	w, h := max.X-g.min.X, max.Y-g.min.Y
+	g.cell = math.Sqrt(w * h / float64(len(pts)))
+	if g.cell <= 0 || math.IsNaN(g.cell) {
+		// All points are on a line.
+		g.cell = math.Max(w, h)/float64(len(pts)) + 1e-9
+	}
+	g.cols = int(w/g.cell) + 1
+	g.rows = int(h/g.cell) + 1
+	g.cells = make([][]int, g.cols*g.rows)
+	for i, p := range pts {
+		k := g.cellOf(p)
+		g.position[i] = len(g.cells[k])
+		g.cells[k] = append(g.cells[k], i)
+	}
+	return g
+}
+
+func (g *pointGrid) coords(p Point) (cx, cy int) {
+	cx = imin(imax(int((p.X-g.min.X)/g.cell), 0), g.cols-1)
+	cy = imin(imax(int((p.Y-g.min.Y)/g.cell), 0), g.rows-1)
+	return cx, cy
+}
+
+func (g *pointGrid) cellOf(p Point) int {
+	cx, cy := g.coords(p)
+	return cy*g.cols + cx
+}
+
+func (g *pointGrid) remove(i int) {
+	k := g.cellOf(g.pts[i])
+	c := g.cells[k]
+	last := c[len(c)-1]
+	c[g.position[i]] = last
+	g.position[last] = g.position[i]
+	g.cells[k] = c[:len(c)-1]
+	g.left--
+}
+
+// nearest returns the index of the remaining point closest to p, or -1 if there are none.
+// The cells are visited in the growing square rings around p, until the ring is farther
+// than the closest point found so far.
+func (g *pointGrid) nearest(p Point) int {
+	if g.left == 0 {
+		return -1
+	}
+	cx, cy := g.coords(p)
+	best, bestDist := -1, math.Inf(1)
+	for r := 0; ; r++ {
+		if best >= 0 && float64(r-1)*g.cell > math.Sqrt(bestDist) {
+			return best
+		}
+		if cx-r < 0 && cy-r < 0 && cx+r >= g.cols && cy+r >= g.rows {
+			return best
+		}
+		for y := cy - r; y <= cy+r; y++ {
+			if y < 0 || y >= g.rows {
+				continue
+			}
+			for x := cx - r; x <= cx+r; x++ {
+				if x < 0 || x >= g.cols {
+					continue
+				}
+				if y != cy-r && y != cy+r && x != cx-r && x != cx+r {
+					// Inside of the ring, already visited.
+					x = cx + r - 1
+					continue
+				}
+				for _, i := range g.cells[y*g.cols+x] {
+					dx, dy := g.pts[i].X-p.X, g.pts[i].Y-p.Y
+					if d := dx*dx + dy*dy; d < bestDist || d == bestDist && i < best {
+						best, bestDist = i, d
+					}
+				}
+			}
+		}
+	}
+}
+
+// NearestOrder returns the indices of the points in the greedy nearest neighbor order,
+// starting from the point closest to start.
+func NearestOrder(pts []Point, start Point) []int {
+	g := newPointGrid(pts)
+	res := make([]int, 0, len(pts))
+	for cur := start; ; {
+		i := g.nearest(cur)
+		if i < 0 {
+			return res
+		}
+		g.remove(i)
+		res = append(res, i)
+		cur = pts[i]
+	}
+}
+
+func imin(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func imax(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+
+	"github.com/krasin/png2stencil/geom"
+	"github.com/krasin/png2stencil/stencil"
+	"github.com/krasin/png2stencil/stencilimg"
+)
+
+func gerberCoord(v float64) int64 {
+	return int64(math.Floor(v*1e6 + 0.5))
+}
+
+// signedArea returns the polygon area, positive for the counter-clockwise polygons.
+func signedArea(poly []geom.Point) float64 {
+	var a float64
+	for i, p := range poly {
+		q := poly[(i+1)%len(poly)]
+		a += p.X*q.Y - q.X*p.Y
+	}
+	return a / 2
+}
+
+// writeGerber writes the as-milled apertures as an RS-274X file in the machine space. The milled
+// circles are flashed with a circular aperture of the tool diameter; the contours, if any, are
+// saved as regions, with the inner boundaries (holes) in the clear polarity.
+func writeGerber(out io.Writer, centers []geom.Point, diameter float64, contours [][]geom.Point) error {
+	w := bufio.NewWriter(out)
+
+	fmt.Fprint(w, "G04 As-milled apertures generated by png2stencil*\n")
+	w.WriteString("%FSLAX46Y46*%\n%MOMM*%\n%LPD*%\n")
+	if len(centers) > 0 {
+		fmt.Fprintf(w, "%%ADD10C,%f*%%\nD10*\n", diameter)
+		for _, c := range centers {
+			m := toMachine(c)
+			fmt.Fprintf(w, "X%dY%dD03*\n", gerberCoord(m.X), gerberCoord(m.Y))
+		}
+	}
+
+	var outer, inner [][]geom.Point
+	for _, c := range contours {
+		var poly []geom.Point
+		for _, p := range c {
+			poly = append(poly, toMachine(p))
+		}
+		// traceContours keeps the region on the right, so in the machine space
+		// the outer boundaries are clockwise.
+		if signedArea(poly) < 0 {
+			outer = append(outer, poly)
+		} else {
+			inner = append(inner, poly)
+		}
+	}
+	region := func(poly []geom.Point) {
+		fmt.Fprint(w, "G36*\n")
+		for i, p := range append(poly, poly[0]) {
+			op := "D01"
+			if i == 0 {
+				op = "D02"
+			}
+			fmt.Fprintf(w, "X%dY%d%s*\n", gerberCoord(p.X), gerberCoord(p.Y), op)
+		}
+		fmt.Fprint(w, "G37*\n")
+	}
+	if len(outer) > 0 {
+		fmt.Fprint(w, "G01*\n")
+	}
+	for _, poly := range outer {
+		region(poly)
+	}
+	if len(inner) > 0 {
+		w.WriteString("%LPC*%\n")
+		for _, poly := range inner {
+			region(poly)
+		}
+	}
+	fmt.Fprint(w, "M02*\n")
+	return w.Flush()
+}
+
+// saveGerber saves what will actually be cut: the milled circles in the dispense mode,
+// or the aperture contours in the modes which cut along them.
+func saveGerber(name string, centers []geom.Point, base stencilimg.PixelMask) error {
+	var contours [][]geom.Point
+	if *mode != stencil.ModeDispense {
+		centers = nil
+		contours = stencilimg.TraceContours(base, *pxSize/float64(*n))
+	}
+	err := writeFile(name, func(w io.Writer) error {
+		return writeGerber(w, centers, cutDiameter(), contours)
+	})
+	if err != nil {
+		return errorf(exitWriteFailed, "failed to save Gerber file %q: %w", name, err)
+	}
+	return nil
+}
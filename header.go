@@ -0,0 +1,76 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/krasin/png2stencil/gcode"
+	"github.com/krasin/png2stencil/stencil"
+)
+
+// fileSHA256 returns the hex SHA-256 of the file.
+func fileSHA256(name string) (string, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return "", errorf(exitBadInput, "failed to open input file: %w", err)
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", errorf(exitBadInput, "failed to read input file: %w", err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// jobComments returns the comments at the start of the G-code, which tell how it was made,
+// so a program found on the machine later is understood without the command line: the
+// generator, the input with its hash, the tools, the estimate of the measured program and
+// the flags set, whether on the command line, in the environment, the config or the presets.
+func jobComments(plan *stencil.Plan, est gcode.Stats) ([]string, error) {
+	sum, err := fileSHA256(*input)
+	if err != nil {
+		return nil, err
+	}
+	b := plan.Base.Bounds()
+	res := []string{
+		"Generated by " + readBuildInfo().String(),
+		fmt.Sprintf("Input: %s, %dx%d px of %g mm, sha256 %s", *input, b.Dx()/(*n), b.Dy()/(*n), *pxSize, sum),
+		fmt.Sprintf("Mode: %s, dialect: %s", *mode, *dialect),
+	}
+	tool := fmt.Sprintf("Tool: T1 %g mm", *toolDiameter)
+	if *toolName != "" {
+		tool += " " + *toolName
+	}
+	if *runout != 0 {
+		tool += fmt.Sprintf(", cuts %g mm", cutDiameter())
+	}
+	res = append(res, tool)
+	if *regTool != "" {
+		// The flags are checked by convertOptions already.
+		t, _ := registrationTool()
+		res = append(res, fmt.Sprintf("Registration hole tool: T%d %g mm %s", t.Number, t.Diameter, t.Name))
+	}
+	st := &plan.Stats
+	if *mode == stencil.ModeDispense {
+		res = append(res, fmt.Sprintf("Apertures: %d, circles: %d, coverage: %.1f%%", len(st.Apertures), st.Circles(), 100*st.Coverage()))
+	} else {
+		paths, length := cutPaths(plan)
+		res = append(res, fmt.Sprintf("Apertures: %d, cut paths: %d, %.1f mm", len(st.Apertures), paths, length))
+	}
+	res = append(res,
+		fmt.Sprintf("Estimated time: %v", time.Duration(est.Seconds*float64(time.Second)).Round(time.Second)))
+	if est.Moves > 0 {
+		res = append(res, fmt.Sprintf("Bounding box: (%.3f, %.3f, %.3f) - (%.3f, %.3f, %.3f) mm",
+			est.Min.X, est.Min.Y, est.Min.Z, est.Max.X, est.Max.Y, est.Max.Z))
+	}
+	res = append(res, "Flags:")
+	flag.Visit(func(f *flag.Flag) {
+		res = append(res, fmt.Sprintf("  --%s=%s", f.Name, f.Value))
+	})
+	return res, nil
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/krasin/png2stencil/gcode"
+	"github.com/krasin/png2stencil/packer"
+	"github.com/krasin/png2stencil/stencil"
+)
+
+// commands are the subcommands with their usage, in the order they are listed in the help.
+var commands = []struct {
+	name, args, doc string
+}{
+	{cmdConvert, "[flags]", "convert --input and write the G-code to --output, and the other outputs; the default"},
+	{cmdInit, "[flags]", "ask for the input, the machine, the tool and the material, write them to --config, and preview the conversion"},
+	{cmdPreview, "[flags]", "write the debug images and the other outputs, but not the G-code"},
+	{cmdCheck, "[flags]", "validate the flags and --input without converting it"},
+	{cmdStats, "[flags]", "print the statistics without writing any outputs, same as --dry_run"},
+	{cmdBench, "[flags]", "pack the synthetic boards (or --input) with each search and lattice, and compare"},
+	{cmdBatch, "[flags] input.png...", "convert several inputs at a time, with a G-code file per input in the --output directory"},
+	{cmdNest, "[flags] input.png...", "lay several inputs out on the --sheet_x by --sheet_y blank and write a single G-code program cutting them all to --output"},
+	{cmdCompletion, "bash|zsh|fish", "print the shell completion script"},
+	{cmdVersion, "", "print the version and the revision of the build"},
+	{cmdSelftest, "[flags]", "convert the embedded sample board in every mode and dialect, check the results and print the timings"},
+	{cmdServe, "[flags]", "serve the conversion API on --listen: POST /convert with the PNG and the JSON params, get the G-code, the stats and a preview; GET / is a live preview page with the parameter sliders"},
+	{cmdSend, "[flags] program.nc", "stream the G-code program (or - for stdin) to the GRBL controller on --port; SIGUSR1 holds and resumes the feed"},
+	{cmdProbe, "[flags]", "write the program probing the surface on a grid over --input to --output"},
+	{cmdHeightmap, "[flags] probe.log", "turn the log of the probing program into the --height_map file at --output"},
+}
+
+// flagGroups are the sections of the flags in the help. The flags not in any group are listed last.
+var flagGroups = []struct {
+	title string
+	names []string
+}{
+	{"Input", []string{"input", "input_top", "input_bottom", "mirror", "background", "px_size", "n", "max_pixels", "max_partial", "connectivity"}},
+	{"Tool and packing", []string{"tool_diameter", "tools", "tool", "runout", "search", "strategy", "adaptive_n",
+		"coverage_target", "min_coverage", "max_uncovered", "min_web", "circle_samples", "tolerance", "edge_tolerance", "enlarge_small", "merge_distance", "order", "jobs"}},
+	{"Machine", []string{"mode", "machine", "material", "dialect", "mill_height", "safe_height", "z_reference", "breakthrough", "mill_rate", "travel_rate",
+		"dispense_time", "laser_cmd", "laser_power", "passes", "hatch_spacing", "knife_offset", "knife_angle", "arc_tolerance", "registration_holes", "corner_holes", "registration_diameter", "registration_tool",
+		"label", "label_at", "label_height", "label_depth", "label_power", "serial", "serial_at", "serial_module", "serial_dot", "tool_change", "drill_rpm", "mill_rpm", "coolant",
+		"fiducials", "height_map", "level_segment", "probe_spacing", "probe_depth", "probe_rate", "max_x", "max_y", "min_z", "backlash_x", "backlash_y", "sheet_x", "sheet_y", "sheet_gap", "max_feed", "max_z_feed", "feed_limit", "port", "baud"}},
+	{"Hooks", []string{"hook_first_cut", "hook_before_tool_change", "hook_after_tool_change", "hook_every", "hook_every_n", "hook_end"}},
+	{"Outputs", []string{"output", "force", "debug_images", "output_stl", "thickness", "volume_report", "release_report", "report", "output_dxf",
+		"output_hpgl", "output_camotics", "output_gerber", "output_pdf", "output_svg"}},
+	{"Run", []string{"config", "watch", "dry_run", "checkpoint", "cache_dir", "batch_size", "listen", "verbose", "log_level",
+		"log_format", "cpuprofile", "memprofile", "pprof_addr"}},
+}
+
+// flagEnums returns the values of the flags which take one of a few, for the completion.
+func flagEnums() map[string][]string {
+	return map[string][]string{
+		"background":   {"black", "white"},
+		"mode":         {stencil.ModeDispense, stencil.ModeLaser, stencil.ModeKnife},
+		"dialect":      gcode.DialectNames(),
+		"laser_cmd":    {gcode.LaserM3, gcode.LaserM106},
+		"tool_change":  {gcode.ToolChangeM0, gcode.ToolChangeM6},
+		"coolant":      {gcode.CoolantFlood, gcode.CoolantMist, gcode.CoolantAir},
+		"z_reference":  {gcode.ZReferenceTop, gcode.ZReferenceBottom},
+		"feed_limit":   {feedLimitError, feedLimitClamp},
+		"connectivity": {"4", "8"},
+		"strategy":     packer.StrategyNames(),
+		"search":       {packer.SearchCoarse, packer.SearchFull},
+		"order":        {stencil.OrderComponents, stencil.OrderNearest},
+		"machine":      machineNames(),
+		"material":     materialNames(),
+		"log_level":    {"error", "info", "debug"},
+		"log_format":   {"text", "json"},
+	}
+}
+
+// fileFlag tells if the flag takes a file name.
+func fileFlag(name string) bool {
+	switch name {
+	case "input", "input_top", "input_bottom", "output", "volume_report", "release_report", "report", "checkpoint", "cache_dir", "cpuprofile", "memprofile", "config", "tools", "machine", "material", "port", "height_map":
+		return true
+	}
+	return strings.HasPrefix(name, "output_")
+}
+
+func usage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "Usage of %s:\n", os.Args[0])
+	for _, c := range commands {
+		name := c.name
+		if name == cmdConvert {
+			name = "[" + name + "]"
+		}
+		fmt.Fprintf(out, "  %s: %s\n", strings.Join(strings.Fields(os.Args[0]+" "+name+" "+c.args), " "), c.doc)
+	}
+	listed := make(map[string]bool)
+	for _, g := range flagGroups {
+		fmt.Fprintf(out, "\n%s flags:\n", g.title)
+		for _, name := range g.names {
+			if f := flag.Lookup(name); f != nil {
+				printFlag(out, f)
+				listed[name] = true
+			}
+		}
+	}
+	first := true
+	flag.VisitAll(func(f *flag.Flag) {
+		if listed[f.Name] {
+			return
+		}
+		if first {
+			fmt.Fprintf(out, "\nOther flags:\n")
+			first = false
+		}
+		printFlag(out, f)
+	})
+	fmt.Fprintf(out, "\nThe flags not given on the command line are taken from the %s<FLAG> environment variables,\n", envPrefix)
+	fmt.Fprintf(out, "like %sPX_SIZE=0.05, then from the --config file, the --tool, the --material and the --machine profile.\n", envPrefix)
+	fmt.Fprintf(out, "\nExit codes:\n")
+	for _, e := range exitCodeDocs {
+		fmt.Fprintf(out, "  %3d  %s\n", e.code, e.doc)
+	}
+}
+
+// printFlag prints the flag like flag.PrintDefaults does, but without the NaN defaults
+// of the flags which must be set.
+func printFlag(out io.Writer, f *flag.Flag) {
+	name, doc := flag.UnquoteUsage(f)
+	s := "  -" + f.Name
+	if name != "" {
+		s += " " + name
+	}
+	s += "\n    \t" + strings.ReplaceAll(doc, "\n", "\n    \t")
+	switch {
+	case f.DefValue == "" || f.DefValue == "false" || f.DefValue == "0" || f.DefValue == "NaN":
+	case name == "string":
+		s += fmt.Sprintf(" (default %q)", f.DefValue)
+	default:
+		s += fmt.Sprintf(" (default %v)", f.DefValue)
+	}
+	fmt.Fprintln(out, s)
+}
+
+// runCompletion implements the completion subcommand: it prints the completion script of the shell,
+// which completes the subcommands, the flags, the enum values and the file names.
+func runCompletion(args []string) error {
+	if len(args) != 1 {
+		return errorf(exitBadFlags, "completion needs the shell: bash, zsh or fish")
+	}
+	var cmds, flags []string
+	for _, c := range commands {
+		cmds = append(cmds, c.name)
+	}
+	flag.VisitAll(func(f *flag.Flag) { flags = append(flags, "--"+f.Name) })
+	enums := flagEnums()
+	var enumNames []string
+	for name := range enums {
+		enumNames = append(enumNames, name)
+	}
+	sort.Strings(enumNames)
+	w := os.Stdout
+	switch args[0] {
+	case "bash", "zsh":
+		if args[0] == "zsh" {
+			fmt.Fprintln(w, "autoload -U +X bashcompinit && bashcompinit")
+		}
+		fmt.Fprintln(w, "_png2stencil() {")
+		fmt.Fprintln(w, `	local cur="${COMP_WORDS[COMP_CWORD]}" prev="${COMP_WORDS[COMP_CWORD-1]}"`)
+		// The = is a word of its own, since it's in COMP_WORDBREAKS.
+		fmt.Fprintln(w, `	if [[ $cur == "=" ]]; then cur=""; elif [[ $prev == "=" ]]; then prev="${COMP_WORDS[COMP_CWORD-2]}"; fi`)
+		fmt.Fprintln(w, `	case "$prev" in`)
+		for _, name := range enumNames {
+			fmt.Fprintf(w, "\t--%s|-%s) COMPREPLY=($(compgen -W %q -- \"$cur\")); return;;\n", name, name, strings.Join(enums[name], " "))
+		}
+		var files []string
+		flag.VisitAll(func(f *flag.Flag) {
+			if fileFlag(f.Name) && enums[f.Name] == nil {
+				files = append(files, "--"+f.Name, "-"+f.Name)
+			}
+		})
+		fmt.Fprintf(w, "\t%s) COMPREPLY=($(compgen -f -- \"$cur\")); return;;\n", strings.Join(files, "|"))
+		fmt.Fprintln(w, "\tesac")
+		fmt.Fprintf(w, "\tif [[ $COMP_CWORD -eq 1 && $cur != -* ]]; then COMPREPLY=($(compgen -W %q -- \"$cur\")); return; fi\n", strings.Join(cmds, " "))
+		fmt.Fprintf(w, "\tif [[ $cur == -* ]]; then COMPREPLY=($(compgen -W %q -- \"$cur\")); return; fi\n", strings.Join(flags, " "))
+		fmt.Fprintln(w, `	COMPREPLY=($(compgen -f -- "$cur"))`)
+		fmt.Fprintln(w, "}")
+		fmt.Fprintln(w, "complete -F _png2stencil png2stencil")
+	case "fish":
+		fmt.Fprintln(w, "complete -c png2stencil -f")
+		for _, c := range commands {
+			fmt.Fprintf(w, "complete -c png2stencil -n __fish_use_subcommand -a %s -d %s\n", c.name, fishQuote(c.doc))
+		}
+		flag.VisitAll(func(f *flag.Flag) {
+			_, doc := flag.UnquoteUsage(f)
+			s := fmt.Sprintf("complete -c png2stencil -l %s -d %s", f.Name, fishQuote(doc))
+			if vals := enums[f.Name]; vals != nil {
+				s += " -x -a " + fishQuote(strings.Join(vals, " "))
+			} else if fileFlag(f.Name) {
+				s += " -r -F"
+			} else if name, _ := flag.UnquoteUsage(f); name != "" {
+				s += " -x"
+			}
+			fmt.Fprintln(w, s)
+		})
+	default:
+		return errorf(exitBadFlags, "unknown shell: %s", args[0])
+	}
+	return nil
+}
+
+// fishQuote quotes the string for fish.
+func fishQuote(s string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, "'", `\'`).Replace(s) + "'"
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/krasin/png2stencil/gcode"
+)
+
+// hooks returns the --hook_* snippets, or nil if none is set. The two characters \n in a snippet
+// separate its lines, as a newline is awkward to pass on the command line.
+func hooks() *gcode.Hooks {
+	lines := func(s string) string { return strings.ReplaceAll(s, `\n`, "\n") }
+	h := &gcode.Hooks{
+		FirstCut:         lines(*hookFirstCut),
+		BeforeToolChange: lines(*hookBeforeTC),
+		AfterToolChange:  lines(*hookAfterTC),
+		Every:            lines(*hookEvery),
+		EveryN:           *hookEveryN,
+		End:              lines(*hookEnd),
+	}
+	if *h == (gcode.Hooks{EveryN: *hookEveryN}) {
+		return nil
+	}
+	return h
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+
+	"github.com/krasin/png2stencil/geom"
+	"github.com/krasin/png2stencil/stencil"
+	"github.com/krasin/png2stencil/stencilimg"
+)
+
+// hpglUnitsPerMM is the HP-GL plotter unit resolution (0.025 mm per unit).
+const hpglUnitsPerMM = 40
+
+func hpglUnits(v float64) int {
+	return int(math.Floor(v*hpglUnitsPerMM + 0.5))
+}
+
+// writeHPGL writes the toolpath as an HP-GL program: the pen moves up between
+// apertures and traces each milled circle. If paths are given (as in the knife and
+// the laser modes), they are traced instead of the circles.
+func writeHPGL(out io.Writer, centers []geom.Point, r float64, paths [][]geom.Point) error {
+	w := bufio.NewWriter(out)
+
+	fmt.Fprint(w, "IN;SP1;\n")
+	if paths != nil {
+		for _, path := range paths {
+			for i, p := range path {
+				m := toMachine(p)
+				cmd := "PD"
+				if i == 0 {
+					cmd = "PU"
+				}
+				fmt.Fprintf(w, "%s%d,%d;", cmd, hpglUnits(m.X), hpglUnits(m.Y))
+			}
+			fmt.Fprint(w, "\n")
+		}
+	} else {
+		for _, c := range centers {
+			m := toMachine(c)
+			fmt.Fprintf(w, "PU%d,%d;CI%d;\n", hpglUnits(m.X), hpglUnits(m.Y), hpglUnits(r))
+		}
+	}
+	fmt.Fprint(w, "PU;SP0;\n")
+	return w.Flush()
+}
+
+// saveHPGL saves the cuts of the plan for the cutters: the milled circles in the dispense mode,
+// the knife paths in the knife mode, and the aperture contours of the base image in the laser mode.
+func saveHPGL(name string, base stencilimg.PixelMask, plan *stencil.Plan) error {
+	var centers []geom.Point
+	var paths [][]geom.Point
+	switch *mode {
+	case stencil.ModeDispense:
+		centers = plan.Centers
+	case stencil.ModeKnife:
+		paths = plan.Paths
+	case stencil.ModeLaser:
+		for _, c := range stencilimg.TraceContours(base, *pxSize/float64(*n)) {
+			paths = append(paths, append(c, c[0]))
+		}
+	}
+	err := writeFile(name, func(w io.Writer) error {
+		return writeHPGL(w, centers, cutDiameter()/2, paths)
+	})
+	if err != nil {
+		return errorf(exitWriteFailed, "failed to save HP-GL file %q: %w", name, err)
+	}
+	return nil
+}
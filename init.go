@@ -0,0 +1,263 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/krasin/png2stencil/gcode"
+	"github.com/krasin/png2stencil/stencil"
+)
+
+// defaultConfigName is the config file the init subcommand writes, unless --config is set.
+const defaultConfigName = "png2stencil.toml"
+
+// wizard asks the questions of the init subcommand, and collects the flag values from the answers.
+type wizard struct {
+	in   *bufio.Scanner
+	out  io.Writer
+	vals map[string]string
+}
+
+// ask prints the question with the default answer, if any, and returns the answer, or the default
+// if it's empty. The question is repeated until check, if not nil, accepts the answer.
+func (w *wizard) ask(question, def string, check func(string) error) (string, error) {
+	for {
+		if def != "" {
+			fmt.Fprintf(w.out, "%s [%s]: ", question, def)
+		} else {
+			fmt.Fprintf(w.out, "%s: ", question)
+		}
+		if !w.in.Scan() {
+			if err := w.in.Err(); err != nil {
+				return "", errorf(exitBadInput, "failed to read the answer: %w", err)
+			}
+			return "", errorf(exitBadInput, "no answer to %q", question)
+		}
+		answer := strings.TrimSpace(w.in.Text())
+		if answer == "" {
+			answer = def
+		}
+		if check == nil {
+			return answer, nil
+		}
+		if err := check(answer); err != nil {
+			fmt.Fprintf(w.out, "  %v\n", err)
+			continue
+		}
+		return answer, nil
+	}
+}
+
+// set asks the question and sets the flag to the answer, unless it's empty.
+func (w *wizard) set(name, question, def string, check func(string) error) error {
+	v, err := w.ask(question, def, check)
+	if err != nil {
+		return err
+	}
+	if v != "" {
+		w.vals[name] = v
+	}
+	return nil
+}
+
+// oneOf accepts one of the values.
+func oneOf(vals ...string) func(string) error {
+	return func(s string) error {
+		for _, v := range vals {
+			if s == v {
+				return nil
+			}
+		}
+		return fmt.Errorf("expected one of: %s", strings.Join(vals, ", "))
+	}
+}
+
+// isNumber accepts a number, or an empty answer if optional is set.
+func isNumber(positive, optional bool) func(string) error {
+	return func(s string) error {
+		if s == "" && optional {
+			return nil
+		}
+		v, err := strconv.ParseFloat(s, 64)
+		switch {
+		case err != nil:
+			return fmt.Errorf("expected a number")
+		case positive && !(v > 0):
+			return fmt.Errorf("expected a positive number")
+		}
+		return nil
+	}
+}
+
+// isPNG accepts a readable PNG file.
+func isPNG(s string) error {
+	if s == "" {
+		return fmt.Errorf("expected a file name")
+	}
+	f, err := os.Open(s)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := stencil.Decode(f); err != nil {
+		return fmt.Errorf("not a PNG file: %v", err)
+	}
+	return nil
+}
+
+// writeTOML writes the flag values as a TOML config, quoting the values of the string flags.
+func writeTOML(w io.Writer, vals map[string]string) error {
+	var names []string
+	for name := range vals {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fmt.Fprintf(w, "# Written by png2stencil %s; the command line flags override these.\n", cmdInit)
+	for _, name := range names {
+		v := vals[name]
+		if _, ok := flag.Lookup(name).Value.(flag.Getter).Get().(string); ok {
+			v = strconv.Quote(v)
+		}
+		if _, err := fmt.Fprintf(w, "%s = %s\n", name, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runInit implements the init subcommand: it asks for the input, the machine, the tool and the
+// material, writes the answers to the --config file, and previews the conversion with it.
+func runInit(args []string) (int, error) {
+	// The --config is written, not read, so only the command line flags are parsed until it is.
+	flag.CommandLine.Parse(args)
+	w := &wizard{in: bufio.NewScanner(os.Stdin), out: os.Stdout, vals: make(map[string]string)}
+	fmt.Fprintln(w.out, "This writes a config with the flags for your machine and board; empty answers take the [defaults].")
+
+	if err := w.set("input", "Input PNG with the solder paste map", *input, isPNG); err != nil {
+		return 0, err
+	}
+	if err := w.set("background", "Background color of the PNG (black or white)", "black", oneOf("black", "white")); err != nil {
+		return 0, err
+	}
+	if err := w.set("px_size", "Size of a pixel (mm), like 0.0254 for 1000 DPI", "", isNumber(true, false)); err != nil {
+		return 0, err
+	}
+
+	machines := machineNames()
+	if err := w.set("machine", "Machine profile ("+strings.Join(machines, ", ")+"), or none to enter the rates",
+		"none", oneOf(append(machines, "none")...)); err != nil {
+		return 0, err
+	}
+	if w.vals["machine"] == "none" {
+		delete(w.vals, "machine")
+		if err := w.set("dialect", "Controller dialect ("+strings.Join(gcode.DialectNames(), ", ")+")", *dialect, oneOf(gcode.DialectNames()...)); err != nil {
+			return 0, err
+		}
+		if err := w.set("travel_rate", "Travel rate (mm/min)", "", isNumber(true, false)); err != nil {
+			return 0, err
+		}
+		if err := w.set("mill_rate", "Mill rate (mm/min)", "", isNumber(true, false)); err != nil {
+			return 0, err
+		}
+		if err := w.set("safe_height", "Safe height to travel at (mm above the material)", "", isNumber(false, false)); err != nil {
+			return 0, err
+		}
+	}
+
+	modes := []string{stencil.ModeDispense, stencil.ModeLaser, stencil.ModeKnife}
+	if err := w.set("mode", "Mode ("+strings.Join(modes, ", ")+")", *mode, oneOf(modes...)); err != nil {
+		return 0, err
+	}
+	m := w.vals["mode"]
+	toolQuestion := "Tool diameter (mm)"
+	switch m {
+	case stencil.ModeDispense:
+		toolQuestion = "Paste dot diameter (mm)"
+	case stencil.ModeLaser:
+		toolQuestion = "Laser spot diameter (mm)"
+	}
+	if err := w.set("tool_diameter", toolQuestion, "", isNumber(true, false)); err != nil {
+		return 0, err
+	}
+	if m != stencil.ModeDispense {
+		materials := materialNames()
+		if err := w.set("material", "Material preset ("+strings.Join(materials, ", ")+"), or none to enter its thickness",
+			"none", oneOf(append(materials, "none")...)); err != nil {
+			return 0, err
+		}
+		if w.vals["material"] == "none" {
+			delete(w.vals, "material")
+		}
+	}
+	// The preset sets the thickness and the cutting height.
+	preset := w.vals["material"] != ""
+	if !preset {
+		if err := w.set("thickness", "Material thickness (mm), or empty if not known", "", isNumber(true, true)); err != nil {
+			return 0, err
+		}
+	}
+	switch m {
+	case stencil.ModeDispense:
+		if err := w.set("mill_height", "Dispensing height (mm above the board)", "", isNumber(false, false)); err != nil {
+			return 0, err
+		}
+	case stencil.ModeLaser:
+		if err := w.set("hatch_spacing", "Distance between the hatching lines (mm)", w.vals["tool_diameter"], isNumber(true, false)); err != nil {
+			return 0, err
+		}
+	case stencil.ModeKnife:
+		def := ""
+		if t, err := strconv.ParseFloat(w.vals["thickness"], 64); err == nil {
+			def = fmt.Sprint(-(t + *breakthrough))
+		}
+		if !preset {
+			if err := w.set("mill_height", "Cutting height (mm, negative below the material top)", def, isNumber(false, false)); err != nil {
+				return 0, err
+			}
+		}
+		if err := w.set("knife_offset", "Drag knife offset (mm)", "", isNumber(false, false)); err != nil {
+			return 0, err
+		}
+	}
+
+	name := *configFile
+	if name == "" {
+		name = defaultConfigName
+	}
+	name, err := w.ask("Config file to write", name, func(s string) error {
+		if s == "" {
+			return fmt.Errorf("expected a file name")
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	if _, err := os.Stat(name); err == nil {
+		yes, err := w.ask(name+" exists; overwrite it (yes or no)", "no", oneOf("yes", "no"))
+		if err != nil {
+			return 0, err
+		}
+		if yes != "yes" {
+			return 0, errorf(exitBadFlags, "not overwriting %s", name)
+		}
+	}
+	err = writeFile(name, func(out io.Writer) error {
+		return writeTOML(out, w.vals)
+	})
+	if err != nil {
+		return 0, errorf(exitWriteFailed, "failed to write config %q: %w", name, err)
+	}
+	fmt.Fprintf(w.out, "Wrote %s. Previewing it; convert with: png2stencil --config %s --output stencil.nc\n", name, name)
+
+	if err := parseFlags(append(args, "--config="+name)); err != nil {
+		return 0, err
+	}
+	return convert(cmdPreview)
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"os"
+
+	"github.com/krasin/png2stencil/gcode"
+)
+
+// loadHeightMap reads the --height_map file, if the name is not empty.
+func loadHeightMap(name string) (*gcode.HeightMap, error) {
+	if name == "" {
+		return nil, nil
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, errorf(exitBadInput, "failed to open height map: %w", err)
+	}
+	defer f.Close()
+	hm, err := gcode.ParseHeightMap(f)
+	if err != nil {
+		return nil, errorf(exitBadInput, "failed to parse height map %q: %w", name, err)
+	}
+	return hm, nil
+}
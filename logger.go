@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+)
+
+// jsonLogs is set when the logs are written as JSON lines.
+var jsonLogs bool
+
+// warnings are the warnings logged so far, whatever the log level, for the --report.
+var (
+	warningsMu sync.Mutex
+	warnings   []string
+)
+
+// loggedWarnings returns the warnings logged so far, like "Poor paste release: aperture=3 x=1.5".
+func loggedWarnings() []string {
+	warningsMu.Lock()
+	defer warningsMu.Unlock()
+	return append([]string(nil), warnings...)
+}
+
+// warningRecorder passes the records to the handler, keeping the warnings.
+type warningRecorder struct {
+	slog.Handler
+}
+
+func (r warningRecorder) Enabled(ctx context.Context, l slog.Level) bool {
+	return l == slog.LevelWarn || r.Handler.Enabled(ctx, l)
+}
+
+func (r warningRecorder) Handle(ctx context.Context, rec slog.Record) error {
+	if rec.Level == slog.LevelWarn {
+		var b strings.Builder
+		b.WriteString(rec.Message)
+		sep := ": "
+		rec.Attrs(func(a slog.Attr) bool {
+			fmt.Fprintf(&b, "%s%s=%v", sep, a.Key, a.Value)
+			sep = " "
+			return true
+		})
+		warningsMu.Lock()
+		warnings = append(warnings, b.String())
+		warningsMu.Unlock()
+	}
+	if !r.Handler.Enabled(ctx, rec.Level) {
+		return nil
+	}
+	return r.Handler.Handle(ctx, rec)
+}
+
+func (r warningRecorder) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return warningRecorder{r.Handler.WithAttrs(attrs)}
+}
+
+func (r warningRecorder) WithGroup(name string) slog.Handler {
+	return warningRecorder{r.Handler.WithGroup(name)}
+}
+
+// setupLogger installs the default leveled logger writing to stderr
+// in either text or JSON format.
+func setupLogger(level, format string) error {
+	var l slog.Level
+	switch level {
+	case "error":
+		l = slog.LevelError
+	case "info":
+		l = slog.LevelInfo
+	case "debug":
+		l = slog.LevelDebug
+	default:
+		return errorf(exitBadFlags, "unknown log level: %s", level)
+	}
+	var h slog.Handler
+	switch format {
+	case "text":
+		h = slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{
+			Level: l,
+			ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+				// Timestamps are just noise for a command line tool.
+				if a.Key == slog.TimeKey && len(groups) == 0 {
+					return slog.Attr{}
+				}
+				return a
+			},
+		})
+	case "json":
+		// Keep the timestamps, since JSON logs are archived.
+		h = slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: l})
+		jsonLogs = true
+	default:
+		return errorf(exitBadFlags, "unknown log format: %s", format)
+	}
+	slog.SetDefault(slog.New(warningRecorder{h}))
+	return nil
+}
+
+func logEnabled(l slog.Level) bool {
+	return slog.Default().Enabled(context.Background(), l)
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Feed limit handling, see limitFeeds.
+const (
+	feedLimitError = "error"
+	feedLimitClamp = "clamp"
+)
+
+// machineProfiles are the bundled --machine presets: the flag values of the popular machines.
+// The travel rate is the fastest feed the machine keeps its precision at, the max feed is the
+// fastest X and Y one of the stock controller settings, and the limits are the working area
+// with the origin at its front left corner.
+var machineProfiles = map[string]map[string]string{
+	"3018-grbl": {
+		"dialect":     "grbl",
+		"max_x":       "300",
+		"max_y":       "180",
+		"max_feed":    "1000",
+		"safe_height": "2",
+		"travel_rate": "1000",
+		"mill_rate":   "300",
+	},
+	"nomad": {
+		"dialect":     "grbl",
+		"max_x":       "203",
+		"max_y":       "203",
+		"max_feed":    "2540",
+		"safe_height": "2",
+		"travel_rate": "2000",
+		"mill_rate":   "500",
+	},
+	"prusa-mk3": {
+		"dialect":     "marlin",
+		"max_x":       "250",
+		"max_y":       "210",
+		"max_feed":    "12000",
+		"safe_height": "1",
+		"travel_rate": "3000",
+		"mill_rate":   "600",
+	},
+}
+
+// machineNames returns the names of the bundled machine profiles, sorted.
+func machineNames() []string {
+	var names []string
+	for name := range machineProfiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// loadMachine returns the flag values of the --machine profile: a bundled one, or a TOML file
+// with the same keys, if the name ends with .toml.
+func loadMachine(name string) (map[string]string, error) {
+	if strings.HasSuffix(name, ".toml") {
+		vals, err := loadConfig(name)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := vals["machine"]; ok {
+			return nil, errorf(exitBadFlags, "a machine profile can't set the machine, in %s", name)
+		}
+		return vals, nil
+	}
+	vals, ok := machineProfiles[name]
+	if !ok {
+		return nil, errorf(exitBadFlags, "unknown machine: %s; known ones are %s, or a .toml file", name, strings.Join(machineNames(), ", "))
+	}
+	return vals, nil
+}
+
+// limitFeeds checks the rate flags with the names against --max_feed, and against --max_z_feed too
+// if zMoves tells they move Z. With --feed_limit=clamp, the rates above the limits are lowered to
+// them with a warning, otherwise they fail the run.
+func limitFeeds(zMoves bool, names ...string) error {
+	limit := *maxFeed
+	if zMoves && (math.IsNaN(limit) || *maxZFeed < limit) {
+		limit = *maxZFeed
+	}
+	switch *feedLimit {
+	case feedLimitError, feedLimitClamp:
+	default:
+		return errorf(exitBadFlags, "unknown feed limit handling: %s", *feedLimit)
+	}
+	if math.IsNaN(limit) {
+		return nil
+	}
+	var over []string
+	for _, name := range names {
+		f := flag.Lookup(name)
+		rate, err := strconv.ParseFloat(f.Value.String(), 64)
+		if err != nil || rate <= limit {
+			continue
+		}
+		if *feedLimit == feedLimitClamp {
+			slog.Warn("Feed rate clamped to the machine limit", "flag", name, "rate", rate, "limit", limit)
+			f.Value.Set(fmt.Sprint(limit))
+			continue
+		}
+		over = append(over, fmt.Sprintf("--%s=%v", name, rate))
+	}
+	if len(over) > 0 {
+		return errorf(exitBadFlags, "%s: above the machine feed limit of %v mm/min; lower the rates, or use --feed_limit=clamp",
+			strings.Join(over, " and "), limit)
+	}
+	return nil
+}
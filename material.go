@@ -0,0 +1,81 @@
+package main
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/krasin/png2stencil/stencil"
+)
+
+// materialPresets are the bundled --material presets: the flag values cutting the stencil sheets
+// in the laser and the knife modes. The mill height cuts --breakthrough below the sheet, the
+// passes and the laser power are for a 5 W diode laser, and the spindle speeds and the mill
+// rates are for a 0.2 mm end mill in the metal sheets; the plastic ones are cut by a drag knife.
+var materialPresets = map[string]map[string]string{
+	"mylar_100um": {
+		"thickness":   "0.1",
+		"mill_height": "-0.15",
+		"mill_rate":   "400",
+		"passes":      "2",
+		"laser_power": "180",
+	},
+	"kapton": {
+		"thickness":   "0.125",
+		"mill_height": "-0.175",
+		"mill_rate":   "300",
+		"passes":      "3",
+		"laser_power": "200",
+	},
+	"brass_100um": {
+		"thickness":   "0.1",
+		"mill_height": "-0.15",
+		"mill_rate":   "100",
+		"mill_rpm":    "12000",
+		"passes":      "20",
+		"laser_power": "255",
+	},
+	"stainless_120um": {
+		"thickness":   "0.12",
+		"mill_height": "-0.17",
+		"mill_rate":   "60",
+		"mill_rpm":    "15000",
+		"passes":      "40",
+		"laser_power": "255",
+	},
+}
+
+// materialNames returns the names of the bundled material presets, sorted.
+func materialNames() []string {
+	var names []string
+	for name := range materialPresets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// loadMaterial returns the flag values of the --material preset: a bundled one, or a TOML file
+// with the same keys, if the name ends with .toml. The spindle speed is left out outside of the
+// knife mode, where the spindle doesn't turn.
+func loadMaterial(name string) (map[string]string, error) {
+	var vals map[string]string
+	if strings.HasSuffix(name, ".toml") {
+		var err error
+		if vals, err = loadConfig(name); err != nil {
+			return nil, err
+		}
+		if _, ok := vals["material"]; ok {
+			return nil, errorf(exitBadFlags, "a material preset can't set the material, in %s", name)
+		}
+	} else if vals = materialPresets[name]; vals == nil {
+		return nil, errorf(exitBadFlags, "unknown material: %s; known ones are %s, or a .toml file", name, strings.Join(materialNames(), ", "))
+	}
+	res := make(map[string]string)
+	for k, v := range vals {
+		if k == "mill_rpm" && *mode != stencil.ModeKnife {
+			continue
+		}
+		res[k] = v
+	}
+	return res, nil
+}
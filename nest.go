@@ -0,0 +1,234 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/krasin/png2stencil/gcode"
+	"github.com/krasin/png2stencil/geom"
+	"github.com/krasin/png2stencil/stencil"
+)
+
+// nestForbidden are the flags naming the single-input outputs, which the nest subcommand doesn't
+// write, and --watch.
+var nestForbidden = batchForbidden
+
+// nested is a stencil laid out on the sheet.
+type nested struct {
+	input string
+	plan  *stencil.Plan
+	// lo and hi are the stencil bounds in its own machine space, see stencilBounds, and at is
+	// where its lo goes on the sheet.
+	lo, hi, at geom.Point
+	code       int
+}
+
+func (s *nested) size() geom.Point { return geom.Pt(s.hi.X-s.lo.X, s.hi.Y-s.lo.Y) }
+
+// sheetSize returns the --sheet_x and --sheet_y (in mm).
+func sheetSize() (geom.Point, error) {
+	if err := requireFlags("sheet_x", "sheet_y"); err != nil {
+		return geom.Point{}, err
+	}
+	return geom.Pt(*sheetX, *sheetY), nil
+}
+
+// shelfPack lays the stencils out on the sheet of the size in shelves: the tallest first, left to
+// right along the bottom edge, and the next shelf above the tallest of the previous one, gap (in mm)
+// apart and from the edges. It sets the at of every stencil, or fails if one doesn't fit.
+func shelfPack(stencils []*nested, size geom.Point, gap float64) error {
+	order := make([]*nested, len(stencils))
+	copy(order, stencils)
+	sort.SliceStable(order, func(i, j int) bool { return order[i].size().Y > order[j].size().Y })
+	x, y, shelf := gap, gap, 0.0
+	for _, s := range order {
+		sz := s.size()
+		if x+sz.X+gap > size.X && x > gap {
+			x, y, shelf = gap, y+shelf+gap, 0
+		}
+		if x+sz.X+gap > size.X || y+sz.Y+gap > size.Y {
+			return errorf(exitLimits, "%s (%.1fx%.1f mm) doesn't fit on the %gx%g mm sheet with the others",
+				s.input, sz.X, sz.Y, size.X, size.Y)
+		}
+		s.at = geom.Pt(x, y)
+		x += sz.X + gap
+		if sz.Y > shelf {
+			shelf = sz.Y
+		}
+	}
+	return nil
+}
+
+// nestComments returns the comments at the start of the G-code of the sheet, like jobComments:
+// the generator, the sheet, every input with its hash and place, the estimate and the flags set.
+func nestComments(stencils []*nested, size geom.Point, est gcode.Stats) ([]string, error) {
+	area := 0.0
+	for _, s := range stencils {
+		sz := s.size()
+		area += sz.X * sz.Y
+	}
+	res := []string{
+		"Generated by " + readBuildInfo().String(),
+		fmt.Sprintf("Sheet: %gx%g mm, %d stencils, %.1f%% used", size.X, size.Y, len(stencils), 100*area/(size.X*size.Y)),
+	}
+	for i, s := range stencils {
+		sum, err := fileSHA256(s.input)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, fmt.Sprintf("Stencil %d: %s at (%.3f, %.3f) mm, sha256 %s", i+1, s.input, s.at.X, s.at.Y, sum))
+	}
+	res = append(res,
+		fmt.Sprintf("Mode: %s, dialect: %s", *mode, *dialect),
+		fmt.Sprintf("Estimated time: %v", time.Duration(est.Seconds*float64(time.Second)).Round(time.Second)))
+	if est.Moves > 0 {
+		res = append(res, fmt.Sprintf("Bounding box: (%.3f, %.3f, %.3f) - (%.3f, %.3f, %.3f) mm",
+			est.Min.X, est.Min.Y, est.Min.Z, est.Max.X, est.Max.Y, est.Max.Z))
+	}
+	res = append(res, "Flags:")
+	flag.Visit(func(f *flag.Flag) {
+		// The --input is set to the first one by runNest.
+		if f.Name != "input" {
+			res = append(res, fmt.Sprintf("  --%s=%s", f.Name, f.Value))
+		}
+	})
+	return res, nil
+}
+
+// runNest implements the nest subcommand: every input is converted with the same flags, the
+// stencils are laid out on the --sheet_x by --sheet_y blank with shelfPack, and a single program
+// cutting them all, the registration holes of all first, is written to --output. The placements
+// are printed in a table. It returns the most severe exit code of the stencils.
+func runNest(args []string) (int, error) {
+	if err := parseFlags(args); err != nil {
+		return 0, err
+	}
+	inputs := flag.Args()
+	if len(inputs) == 0 {
+		return 0, errorf(exitBadFlags, "no inputs given to nest")
+	}
+	var forbidden error
+	flag.Visit(func(f *flag.Flag) {
+		for _, name := range nestForbidden {
+			if f.Name == name && forbidden == nil {
+				forbidden = errorf(exitBadFlags, "--%s is not supported in nest", name)
+			}
+		}
+	})
+	if forbidden != nil {
+		return 0, forbidden
+	}
+	// The flags are checked against the first input; all of them are converted with the same.
+	flag.Set("input", inputs[0])
+	writeGCode := !*dryRun
+	opts, err := checkFlags(writeGCode)
+	if err != nil {
+		return 0, err
+	}
+	size, err := sheetSize()
+	if err != nil {
+		return 0, err
+	}
+	if writeGCode {
+		if err := checkOverwrite(*output); err != nil {
+			return 0, err
+		}
+	}
+	hm, err := loadHeightMap(*heightMap)
+	if err != nil {
+		return 0, err
+	}
+	fid, err := loadFiducials()
+	if err != nil {
+		return 0, err
+	}
+
+	var stencils []*nested
+	for _, name := range inputs {
+		in, err := loadPNG(name)
+		if err != nil {
+			return 0, err
+		}
+		imgW, imgH = in.Bounds().Dx()*(*n), in.Bounds().Dy()*(*n)
+		plan, err := stencil.Convert(context.Background(), in, opts)
+		if err != nil {
+			return 0, fmt.Errorf("failed to convert %q: %w", name, err)
+		}
+		if err := checkWebs(plan); err != nil {
+			return 0, err
+		}
+		if err := checkUncovered(plan); err != nil {
+			return 0, err
+		}
+		s := &nested{input: name, plan: plan, code: resultCode(&plan.Stats)}
+		s.lo, s.hi = stencilBounds(plan.RegistrationHoles())
+		stencils = append(stencils, s)
+	}
+	if err := shelfPack(stencils, size, *sheetGap); err != nil {
+		return 0, err
+	}
+	var plans []*stencil.Plan
+	holes, paths := 0, 0
+	for _, s := range stencils {
+		plans = append(plans, s.plan.Shifted(geom.Pt(s.at.X-s.lo.X, s.at.Y-s.lo.Y)))
+		count, _ := cutPaths(s.plan)
+		paths += count
+		if *mode == stencil.ModeDispense {
+			holes += len(s.plan.Centers)
+		}
+	}
+
+	margin := 0.0
+	if *mode == stencil.ModeKnife {
+		margin = *knifeOffset
+	}
+	lo, hi := geom.Pt(0, 0), size
+	out, err := newGCodeOutput("", *mode != stencil.ModeDispense, margin, lo, hi, fid, hm)
+	if err != nil {
+		return 0, err
+	}
+	if err := stencil.SheetProgram(out.add, plans...); err != nil {
+		return 0, err
+	}
+	if err := out.check(); err != nil {
+		return 0, err
+	}
+	outName := ""
+	if writeGCode {
+		// As in convert, the header tells the estimate of the measured program.
+		outName = *output
+		if plans[0].Comments, err = nestComments(stencils, size, out.Stats()); err != nil {
+			return 0, err
+		}
+		if out, err = newGCodeOutput(outName, *mode != stencil.ModeDispense, margin, lo, hi, fid, hm); err != nil {
+			return 0, err
+		}
+		if err := stencil.SheetProgram(out.add, plans...); err != nil {
+			return 0, err
+		}
+		if err := out.check(); err != nil {
+			return 0, err
+		}
+		if err := out.commit(); err != nil {
+			return 0, err
+		}
+	}
+	out.warn()
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "input\tx\ty\twidth\theight\tapertures\tcoverage\texit")
+	code := exitOK
+	for _, s := range stencils {
+		sz, st := s.size(), &s.plan.Stats
+		fmt.Fprintf(tw, "%s\t%.1f\t%.1f\t%.1f\t%.1f\t%d\t%.1f%%\t%d\n", s.input, s.at.X, s.at.Y, sz.X, sz.Y,
+			len(st.Apertures), 100*st.Coverage(), s.code)
+		code = worseCode(code, s.code)
+	}
+	tw.Flush()
+	return code, printSummary(outName, out.Lines, out.Stats(), holes, paths)
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+// outputName is what the --output template is executed with.
+type outputName struct {
+	// InputBase is the --input file name without the directory and the extension.
+	InputBase string
+	// Tool is the --tool_diameter, and ToolName is the --tool, if any.
+	Tool     float64
+	ToolName string
+	Mode     string
+	Dialect  string
+	// Machine and Material are the names of the profile and the preset, if any, without
+	// the directory and the extension for the .toml files.
+	Machine  string
+	Material string
+	// N is the number of subpixels.
+	N int
+}
+
+// isTemplate tells if the file name is a text/template, like {{.InputBase}}.nc.
+func isTemplate(name string) bool {
+	return strings.Contains(name, "{{")
+}
+
+// baseName returns the file name without the directory and the extension.
+func baseName(name string) string {
+	name = filepath.Base(name)
+	return strings.TrimSuffix(name, filepath.Ext(name))
+}
+
+// expandOutput replaces the --output template, if it's one, with the file name it makes
+// for the other flags, like {{.InputBase}}_{{.Tool}}mm_{{.Dialect}}.nc for a_0.3mm_grbl.nc.
+func expandOutput() error {
+	if !isTemplate(*output) {
+		return nil
+	}
+	tmpl, err := template.New("output").Parse(*output)
+	if err != nil {
+		return errorf(exitBadFlags, "bad --output template: %w", err)
+	}
+	data := outputName{
+		InputBase: baseName(*input),
+		Tool:      *toolDiameter,
+		ToolName:  *toolName,
+		Mode:      *mode,
+		Dialect:   *dialect,
+		N:         *n,
+	}
+	if *machine != "" {
+		data.Machine = baseName(*machine)
+	}
+	if *material != "" {
+		data.Material = baseName(*material)
+	}
+	var b strings.Builder
+	if err := tmpl.Execute(&b, data); err != nil {
+		return errorf(exitBadFlags, "bad --output template: %w", err)
+	}
+	if b.Len() == 0 {
+		return errorf(exitBadFlags, "--output template %q makes an empty file name", *output)
+	}
+	*output = b.String()
+	return nil
+}
@@ -0,0 +1,118 @@
+package packer
+
+import (
+	"math"
+)
+
+// edt1d computes the squared Euclidean distance transform of a sampled function f
+// (Felzenszwalb & Huttenlocher) into d, using v and z as scratch space.
+// f[0] must be finite, which the background padding of distanceField guarantees.
+func edt1d(f, d []float64, v []int, z []float64) {
+	k := 0
+	v[0] = 0
+	z[0] = math.Inf(-1)
+	z[1] = math.Inf(1)
+	for q := 1; q < len(f); q++ {
+		var s float64
+		for {
+			p := v[k]
+			s = ((f[q] + float64(q*q)) - (f[p] + float64(p*p))) / float64(2*q-2*p)
+			if s > z[k] || k == 0 {
+				break
+			}
+			k--
+		}
+		k++
+		v[k] = q
+		z[k] = s
+		z[k+1] = math.Inf(1)
+	}
+	k = 0
+	for q := range f {
+		for z[k+1] < float64(q) {
+			k++
+		}
+		d[q] = float64((q-v[k])*(q-v[k])) + f[v[k]]
+	}
+}
+
+// distanceField holds the squared distance (in pixels) from the center of every pixel of
+// a component to the center of the nearest pixel outside of it.
+type distanceField struct {
+	x0, y0 int // image coordinates of the field origin
+	w, h   int
+	d2     []float64
+}
+
+// newDistanceField computes the distance transform of the component mask. The field is padded
+// with a ring of background, since everything outside of the mask is background too.
+func newDistanceField(c *Component) *distanceField {
+	b := c.mask().Bounds()
+	df := &distanceField{x0: b.Min.X - 1, y0: b.Min.Y - 1, w: b.Dx() + 2, h: b.Dy() + 2}
+	df.d2 = make([]float64, df.w*df.h)
+	for y := 0; y < df.h; y++ {
+		for x := 0; x < df.w; x++ {
+			ix, iy := df.x0+x, df.y0+y
+			if c.Mask.Get(ix, iy) {
+				df.d2[y*df.w+x] = math.Inf(1)
+			}
+		}
+	}
+	size := imax(df.w, df.h)
+	f := make([]float64, size)
+	d := make([]float64, size)
+	v := make([]int, size)
+	z := make([]float64, size+1)
+	for x := 0; x < df.w; x++ {
+		for y := 0; y < df.h; y++ {
+			f[y] = df.d2[y*df.w+x]
+		}
+		edt1d(f[:df.h], d[:df.h], v, z)
+		for y := 0; y < df.h; y++ {
+			df.d2[y*df.w+x] = d[y]
+		}
+	}
+	for y := 0; y < df.h; y++ {
+		row := df.d2[y*df.w : (y+1)*df.w]
+		copy(f, row)
+		edt1d(f[:df.w], d[:df.w], v, z)
+		copy(row, d[:df.w])
+	}
+	return df
+}
+
+// at returns the distance (in pixels) at the image pixel (x, y), 0 outside of the field.
+func (df *distanceField) at(x, y int) float64 {
+	x -= df.x0
+	y -= df.y0
+	if x < 0 || y < 0 || x >= df.w || y >= df.h {
+		return 0
+	}
+	return math.Sqrt(df.d2[y*df.w+x])
+}
+
+// fits tells if a circle with a center in (x, y) and a radius r fits into the component,
+// with exactly the same result as checkCircle. checkCircle tests the points inside of the
+// pixels, so the distance to the nearest background sample differs from the distance between
+// the pixel centers by less than a pixel diagonal (sqrt(2) ~ 1.42 pixels). The distance field
+// decides outside of that band, and checkCircle is only run for the circles within it.
+// The circle may protrude by the EdgeTolerance, so it's the circle smaller by it which must fit.
+func (c *Component) fits(x, y, r, pxSize float64) bool {
+	c.distOnce.Do(func() {
+		c.dist = newDistanceField(c)
+		c.sat = newSummedArea(c.mask())
+	})
+	r -= c.params.EdgeTolerance
+	if !within(x, y, r, c.params.Tolerance, c.width, c.height) {
+		return false
+	}
+	d := c.dist.at(int(x/pxSize), int(y/pxSize))
+	rp := r / pxSize
+	switch {
+	case d-1.42 > rp:
+		return true
+	case d+1.42 < rp:
+		return false
+	}
+	return checkCircle(c.mask(), c.sat, c.width, c.height, pxSize, x, y, r, c.params.Tolerance, c.params.Samples)
+}
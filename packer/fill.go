@@ -0,0 +1,216 @@
+package packer
+
+import (
+	"math"
+
+	"github.com/krasin/png2stencil/geom"
+	"github.com/krasin/png2stencil/stencilimg"
+)
+
+// Built-in packing strategies, see RegisterStrategy.
+const (
+	StrategyTriangle = "triangle"
+	StrategyQuad     = "quad"
+)
+
+func fillQuad(c *Component, ox, oy float64) []geom.Point {
+	bbox := c.box
+	width := c.width
+	height := c.height
+	dx := c.params.ToolDiameter
+	dy := c.params.ToolDiameter
+	var centers []geom.Point
+	for i := 0; ; i++ {
+		cx := ox + float64(i)*dx
+		if cx >= width {
+			break
+		}
+		if !c.inBox(cx, bbox.Min.X, bbox.Max.X) {
+			continue
+		}
+		for j := 0; ; j++ {
+			cy := oy + float64(j)*dy
+			if cy >= height {
+				break
+			}
+			if !c.inBox(cy, bbox.Min.Y, bbox.Max.Y) {
+				continue
+			}
+			if c.fits(cx, cy, c.params.ToolDiameter/2, c.px) {
+				centers = append(centers, geom.Pt(cx, cy))
+			}
+		}
+	}
+	return centers
+}
+
+func fillTriangle(c *Component, ox, oy float64) []geom.Point {
+	bbox := c.box
+	width := c.width
+	height := c.height
+
+	dy := c.params.ToolDiameter / 2
+	dx := dy * 1.73205080757 // sqrt(3)
+	var centers []geom.Point
+	for i := 0; ; i++ {
+		cx := ox + float64(i)*dx
+		if cx >= width {
+			break
+		}
+		if !c.inBox(cx, bbox.Min.X, bbox.Max.X) {
+			continue
+		}
+		for j := 0; ; j++ {
+			cy := oy + float64(j)*dy
+			if cy >= height {
+				break
+			}
+			if !c.inBox(cy, bbox.Min.Y, bbox.Max.Y) {
+				continue
+			}
+			if (i+j)%2 == 1 {
+				continue
+			}
+			if c.fits(cx, cy, c.params.ToolDiameter/2, c.px) {
+				centers = append(centers, geom.Pt(cx, cy))
+			}
+		}
+	}
+	return centers
+}
+
+// inBox tells if the center coordinate v (in mm) is within the pixels from min to max inclusive (the
+// bounding box of the component along the axis), give or take the Tolerance. The centers outside
+// of the bounding box never fit, so they are not tested.
+func (c *Component) inBox(v float64, min, max int) bool {
+	tol := c.params.Tolerance
+	return v >= float64(min)*c.px-tol && v <= float64(max+1)*c.px+tol
+}
+
+// checkCircle checks that a circle with a center in (x, y) and a radius r fits to the base image
+// of the given size (in mm) and all pixels it hits are high, see hits.
+// base may be a part of the whole image (like a component mask); the pixels outside of it are considered low.
+// If sa (the integral image of base) is not nil, it's used to decide most circles without the pixel scan:
+// the circle surely fits if its bounding square has only high pixels, and surely does not if there's a low pixel
+// fully inside of its inscribed square.
+// The circles not within the image, or with a NaN or infinite center, don't fit, so the pixel indices are never
+// negative; the ones past the edges of base are outside of its bounds, and BitMask.Get reports them low.
+func checkCircle(base *stencilimg.BitMask, sa *summedArea, width, height, pxSize, x, y, r, tol float64, samples int) bool {
+	if !within(x, y, r, tol, width, height) {
+		return false
+	}
+	x0 := int((x - r) / pxSize)
+	y0 := int((y - r) / pxSize)
+	x1 := int((x + r) / pxSize)
+	y1 := int((y + r) / pxSize)
+	if sa != nil {
+		if sa.others(x0, y0, x1, y1) == 0 {
+			return true
+		}
+		h := r / math.Sqrt2
+		ix0 := int(math.Ceil((x - h) / pxSize))
+		iy0 := int(math.Ceil((y - h) / pxSize))
+		ix1 := int(math.Floor((x+h)/pxSize)) - 1
+		iy1 := int(math.Floor((y+h)/pxSize)) - 1
+		if sa.others(ix0, iy0, ix1, iy1) > 0 {
+			return false
+		}
+	}
+	for cy := y0; cy <= y1; cy++ {
+		for cx := x0; cx <= x1; cx++ {
+			if !hits(x, y, r, cx, cy, pxSize, samples) {
+				continue
+			}
+			if !base.Get(cx, cy) {
+				// circle hits background
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// hits tells if the circle with a center in (x, y) and a radius r hits the pixel (cx, cy): if any of
+// its samples*samples points, spread evenly over the pixel, is inside of the circle. With 0 samples,
+// the test is exact: the circle hits the pixel if they overlap at all.
+func hits(x, y, r float64, cx, cy int, pxSize float64, samples int) bool {
+	px, py := float64(cx)*pxSize, float64(cy)*pxSize
+	if samples == 0 {
+		// The point of the pixel nearest to the center.
+		return geom.Inside(x, y, r, math.Max(px, math.Min(x, px+pxSize)), math.Max(py, math.Min(y, py+pxSize)))
+	}
+	step := pxSize / float64(samples)
+	for i := 0; i < samples; i++ {
+		for j := 0; j < samples; j++ {
+			if geom.Inside(x, y, r, px+(float64(i)+0.5)*step, py+(float64(j)+0.5)*step) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// within tells if the circle with a center in (x, y) and a radius r is within the image of the given
+// size (in mm), give or take tol. The negated comparisons reject the NaN centers too.
+func within(x, y, r, tol, width, height float64) bool {
+	return x >= r-tol && x <= width-r+tol && y >= r-tol && y <= height-r+tol
+}
+
+// clampIndex converts the pixel coordinate to an index in [0, n), clamping it to the edges.
+// Converting a NaN or an out of range float to int is implementation-specific, so it's clamped first.
+func clampIndex(v float64, n int) int {
+	switch {
+	case !(v > 0):
+		return 0
+	case v > float64(n-1):
+		return n - 1
+	}
+	return int(v)
+}
+
+// CutMask returns a w*h mask of the base image pixels which centers are inside
+// of at least one of the circles. The circles may be partly or fully outside of the image.
+func CutMask(w, h int, pxSize float64, centers []geom.Point, r float64) []bool {
+	mask := make([]bool, w*h)
+	if w <= 0 || h <= 0 {
+		return mask
+	}
+	for _, c := range centers {
+		x0 := clampIndex((c.X-r)/pxSize, w)
+		y0 := clampIndex((c.Y-r)/pxSize, h)
+		x1 := clampIndex((c.X+r)/pxSize, w)
+		y1 := clampIndex((c.Y+r)/pxSize, h)
+		for cy := y0; cy <= y1; cy++ {
+			for cx := x0; cx <= x1; cx++ {
+				if geom.Inside(c.X, c.Y, r, (float64(cx)+0.5)*pxSize, (float64(cy)+0.5)*pxSize) {
+					mask[cy*w+cx] = true
+				}
+			}
+		}
+	}
+	return mask
+}
+
+// coverage returns the number of set pixels in the mask and how many of them
+// are covered by the circles (by their centers, same as in CutMask).
+func coverage(mask *stencilimg.BitMask, pxSize float64, centers []geom.Point, r float64) (area, covered int) {
+	b := mask.Bounds()
+	w, h := b.Dx(), b.Dy()
+	local := make([]geom.Point, len(centers))
+	for i, c := range centers {
+		local[i] = geom.Pt(c.X-float64(b.Min.X)*pxSize, c.Y-float64(b.Min.Y)*pxSize)
+	}
+	cut := CutMask(w, h, pxSize, local, r)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			if !mask.Get(b.Min.X+x, b.Min.Y+y) {
+				continue
+			}
+			area++
+			if cut[y*w+x] {
+				covered++
+			}
+		}
+	}
+	return area, covered
+}
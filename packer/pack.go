@@ -0,0 +1,511 @@
+// Package packer places the dispensing circles into the apertures of a stencil image.
+package packer
+
+import (
+	"image"
+	"log/slog"
+	"math"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/krasin/png2stencil/geom"
+	"github.com/krasin/png2stencil/stencilimg"
+)
+
+// Lattice offset searches.
+const (
+	// SearchCoarse searches a coarse grid of the offsets refined around the best ones.
+	SearchCoarse = "coarse"
+	// SearchFull tries all offsets, which is about 10 times slower.
+	SearchFull = "full"
+)
+
+// Params are the parameters of the circle packing.
+type Params struct {
+	// PxSize is the size of an input pixel side (in mm).
+	PxSize float64
+	// N is the number of linear subpixels for each input pixel.
+	N int
+	// ToolDiameter is the diameter of the circles (in mm).
+	ToolDiameter float64
+	// Search is the lattice offset search: SearchCoarse or SearchFull.
+	Search string
+	// CoverageTarget stops searching the lattice offsets of a component once a packing
+	// covers this share of its area (0..1].
+	CoverageTarget float64
+	// Adaptive packs the components much bigger than the tool with fewer subpixels than N,
+	// where the precision matters less.
+	Adaptive bool
+	// Strategies are the names of the packing strategies to try, in this order. All registered
+	// strategies are tried if empty, see RegisterStrategy.
+	Strategies []string
+	// Connectivity tells if the diagonally touching pixels are in the same component.
+	Connectivity stencilimg.Connectivity
+	// EdgeTolerance is how far (in mm) the circles may protrude beyond the components, so the fine
+	// pitch pads missing a circle by a subpixel are not left unfilled. It's less than the tool radius.
+	EdgeTolerance float64
+	// Samples is the number of the sample points along each pixel side the circles are tested
+	// against: a circle doesn't fit if any sample of a low pixel is inside of it. More samples
+	// are more accurate and slower; 0 is the exact test, where a circle must not overlap any
+	// low pixel at all.
+	Samples int
+	// Tolerance (in mm) is how far apart the coordinates may be and still compare equal, so the
+	// rounding errors don't decide if a circle on the edge of the image or a component is tested.
+	Tolerance float64
+}
+
+// Component is a connected aperture of the base image with its own copy of the pixels,
+// so the components can be packed independently. The pixels are only kept while
+// the component is processed, so the memory does not grow with the image size.
+type Component struct {
+	ID   int
+	Seed image.Point
+	// BBox is the bounding box with the inclusive Max.
+	BBox image.Rectangle
+
+	params *Params
+	// width and height are the base image size (in mm).
+	width, height float64
+	strategies    []namedStrategy
+
+	// The component is packed at k subpixels per input pixel, which is N or less, see subpixels.
+	// px is the subpixel size (in mm) and box is the bounding box in the subpixels.
+	k   int
+	px  float64
+	box image.Rectangle
+
+	// src is the input mask at the input resolution and reg is the component in it.
+	src *stencilimg.BitMask
+	reg stencilimg.Region
+
+	maskOnce sync.Once
+	// Mask has only the pixels of the component set. Its bounds are the component bounding box.
+	// It's built on the first call of mask.
+	Mask *stencilimg.BitMask
+	area int // number of set pixels in Mask
+
+	// stop is the first search task which found a good enough packing (MaxInt32 if none yet).
+	stop int32
+
+	distOnce sync.Once
+	dist     *distanceField
+	sat      *summedArea
+}
+
+// mask returns the component pixels at its subpixel resolution.
+func (c *Component) mask() *stencilimg.BitMask {
+	c.maskOnce.Do(func() {
+		m := stencilimg.RegionMask(c.src, c.reg, c.params.Connectivity)
+		c.Mask = stencilimg.NewBitMask(image.Rect(c.box.Min.X, c.box.Min.Y, c.box.Max.X+1, c.box.Max.Y+1))
+		// Every input row is magnified once; the other k-1 rows are its copies.
+		k := c.k
+		for sy := c.reg.BBox.Min.Y; sy <= c.reg.BBox.Max.Y; sy++ {
+			for sx := c.reg.BBox.Min.X; sx <= c.reg.BBox.Max.X; sx++ {
+				if m.Get(sx, sy) {
+					for x := sx * k; x < (sx+1)*k; x++ {
+						c.Mask.Set(x, sy*k)
+					}
+				}
+			}
+			for y := sy*k + 1; y < (sy+1)*k; y++ {
+				c.Mask.CopyRow(y, sy*k)
+			}
+		}
+		c.area = c.Mask.Count()
+	})
+	return c.Mask
+}
+
+// Center returns the center of the component bounding box (in mm).
+func (c *Component) Center() geom.Point {
+	px := c.params.PxSize / float64(c.params.N)
+	return geom.Pt(float64(c.BBox.Min.X+c.BBox.Max.X+1)/2*px, float64(c.BBox.Min.Y+c.BBox.Max.Y+1)/2*px)
+}
+
+// release drops the component pixels, once it's processed.
+func (c *Component) release() {
+	c.Mask = nil
+	c.dist = nil
+	c.sat = nil
+}
+
+// Packing is the best circle placement found for a component.
+type Packing struct {
+	Strategy string
+	Centers  []geom.Point
+}
+
+// Better tells if p is a strictly better packing than q.
+func (p Packing) Better(q Packing) bool {
+	return len(p.Centers) > len(q.Centers)
+}
+
+// scaleBox magnifies an inclusive bounding box k times.
+func scaleBox(r image.Rectangle, k int) image.Rectangle {
+	return image.Rectangle{Min: r.Min.Mul(k), Max: r.Max.Add(image.Pt(1, 1)).Mul(k).Sub(image.Pt(1, 1))}
+}
+
+// Components at least adaptiveTools tool diameters wide in both directions are big enough
+// for the subpixels not to matter much: they are packed with as few subpixels per input
+// pixel as keep adaptiveSubpixels subpixels per tool diameter.
+const (
+	adaptiveTools     = 3
+	adaptiveSubpixels = 8
+)
+
+// subpixels returns the number of subpixels per input pixel to pack the region with.
+// It's always a divisor of N, so a component pixel is a whole number of base pixels.
+func (p *Params) subpixels(r stencilimg.Region) int {
+	if !p.Adaptive {
+		return p.N
+	}
+	size := float64(imin(r.BBox.Dx(), r.BBox.Dy())+1) * p.PxSize
+	if size < adaptiveTools*p.ToolDiameter {
+		return p.N
+	}
+	for k := 1; k < p.N; k++ {
+		if p.N%k == 0 && p.ToolDiameter/(p.PxSize/float64(k)) >= adaptiveSubpixels {
+			return k
+		}
+	}
+	return p.N
+}
+
+// Segment extracts the connected components of the input mask src (at the input resolution).
+// Each input pixel is N*N subpixels of the base image, so the components are the same at
+// both resolutions, but the labeling is N*N times cheaper at the input one.
+func Segment(src *stencilimg.BitMask, p *Params) []*Component {
+	n := p.N
+	basePxSize := p.PxSize / float64(n)
+	width := float64(src.Bounds().Dx()*n) * basePxSize
+	height := float64(src.Bounds().Dy()*n) * basePxSize
+	ss := p.strategies()
+	regs := stencilimg.LabelRegions(src, p.Connectivity)
+	comps := make([]*Component, len(regs))
+	for i, r := range regs {
+		k := p.subpixels(r)
+		comps[i] = &Component{
+			ID:         i,
+			Seed:       r.Seed.Mul(n),
+			BBox:       scaleBox(r.BBox, n),
+			params:     p,
+			width:      width,
+			height:     height,
+			strategies: ss,
+			k:          k,
+			px:         p.PxSize / float64(k),
+			box:        scaleBox(r.BBox, k),
+			src:        src,
+			reg:        r,
+			stop:       math.MaxInt32,
+		}
+	}
+	return comps
+}
+
+// Coverage returns the number of the component pixels and how many of them are covered
+// by the circles, in the base pixels.
+func (c *Component) Coverage(centers []geom.Point) (area, covered int) {
+	area, covered = coverage(c.mask(), c.px, centers, c.params.ToolDiameter/2)
+	s := (c.params.N / c.k) * (c.params.N / c.k)
+	return area * s, covered * s
+}
+
+func imin(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func imax(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// Fill the components with circles
+// For now, use the dumbest algorithm: triangular tiling with a center in (0,0) and angle = 0
+// See http://en.wikipedia.org/wiki/File:Triangular_tiling_circle_packing.png for the insight
+const shiftN = 32
+
+// maxCircles returns an upper bound of the number of circles fitting into the component.
+// Every pixel square inside of a circle is set, and the circles do not overlap, so each
+// circle has at least the area of the circle of radius r - px*sqrt(2) for itself.
+func (c *Component) maxCircles(px, r float64) int {
+	c.mask()
+	if r <= px*math.Sqrt2 {
+		return math.MaxInt32
+	}
+	inner := r - px*math.Sqrt2
+	return int(float64(c.area) * px * px / (math.Pi * inner * inner))
+}
+
+// enough tells if the packing is so good that the other offsets are not worth trying: either it
+// covers the CoverageTarget share of the component, or no packing can have more circles.
+func (c *Component) enough(p Packing) bool {
+	r := c.params.ToolDiameter / 2
+	if len(p.Centers) >= c.maxCircles(c.px, r-c.params.EdgeTolerance) {
+		return true
+	}
+	// Each circle covers at most the pixels which centers are within its radius.
+	outer := r/c.px + math.Sqrt2
+	if float64(len(p.Centers))*math.Pi*outer*outer < c.params.CoverageTarget*float64(c.area) {
+		return false
+	}
+	_, covered := coverage(c.mask(), c.px, p.Centers, r)
+	return float64(covered) >= c.params.CoverageTarget*float64(c.area)
+}
+
+// packRow tries the lattice offsets of the i-th row of the offset grid and returns the best packing.
+func packRow(c *Component, i int) Packing {
+	shift := c.params.ToolDiameter / float64(shiftN)
+	var best Packing
+	try := func(name string, centers []geom.Point) bool {
+		p := Packing{name, centers}
+		if !p.Better(best) {
+			return false
+		}
+		best = p
+		return c.enough(p)
+	}
+	for j := 0; j < shiftN; j++ {
+		if c.stopped(i) {
+			return best
+		}
+		for _, s := range c.strategies {
+			if try(s.name, s.Fill(c, float64(i)*shift, float64(j)*shift)) {
+				c.stopAt(i)
+				return best
+			}
+		}
+	}
+	return best
+}
+
+// The coarse search tries every coarseStep-th offset of the grid first, then refines up to
+// coarseCandidates best of them (with at most coarseSlack circles less than the best one)
+// by moving to the best neighbor at the halved step, until the step is a single grid cell.
+const (
+	coarseStep       = 8
+	coarseCandidates = 8
+	coarseSlack      = 1
+)
+
+// packCoarse runs the coarse-to-fine offset search of the i-th strategy. It needs at most 208
+// fills instead of the 1024 of the full grid (usually much less, since the small
+// components have few candidates), and loses less than 1% of the circles on real boards.
+func packCoarse(c *Component, i int) Packing {
+	shift := c.params.ToolDiameter / float64(shiftN)
+	st := c.strategies[i]
+	tried := make(map[image.Point]int)
+	var best Packing
+	done := false
+	// try returns the number of circles at the offset, and tells if it's good enough to stop.
+	try := func(o image.Point) int {
+		o = image.Pt((o.X%shiftN+shiftN)%shiftN, (o.Y%shiftN+shiftN)%shiftN)
+		if k, ok := tried[o]; ok {
+			return k
+		}
+		p := Packing{st.name, st.Fill(c, float64(o.X)*shift, float64(o.Y)*shift)}
+		tried[o] = len(p.Centers)
+		if p.Better(best) {
+			best = p
+			done = c.enough(p)
+		}
+		return len(p.Centers)
+	}
+
+	type candidate struct {
+		o image.Point
+		k int
+	}
+	var cands []candidate
+	for x := 0; x < shiftN; x += coarseStep {
+		for y := 0; y < shiftN; y += coarseStep {
+			if c.stopped(i) {
+				return best
+			}
+			o := image.Pt(x, y)
+			cands = append(cands, candidate{o, try(o)})
+			if done {
+				c.stopAt(i)
+				return best
+			}
+		}
+	}
+	sort.SliceStable(cands, func(a, b int) bool { return cands[a].k > cands[b].k })
+	for k := range cands {
+		if k == coarseCandidates || cands[k].k < cands[0].k-coarseSlack {
+			cands = cands[:k]
+			break
+		}
+	}
+	for step := coarseStep / 2; step >= 1; step /= 2 {
+		for ci := range cands {
+			cur := cands[ci]
+			for dx := -step; dx <= step; dx += step {
+				for dy := -step; dy <= step; dy += step {
+					if c.stopped(i) {
+						return best
+					}
+					o := cur.o.Add(image.Pt(dx, dy))
+					if k := try(o); k > cands[ci].k {
+						cands[ci] = candidate{o, k}
+					}
+					if done {
+						c.stopAt(i)
+						return best
+					}
+				}
+			}
+		}
+	}
+	return best
+}
+
+// searchTasks returns the number of independent search tasks of a component.
+func (c *Component) searchTasks() int {
+	if c.params.Search == SearchFull {
+		return shiftN
+	}
+	return len(c.strategies)
+}
+
+// searchTask runs the i-th search task of the component.
+func (c *Component) searchTask(i int) Packing {
+	if c.params.Search == SearchFull {
+		return packRow(c, i)
+	}
+	return packCoarse(c, i)
+}
+
+// stopped tells if the search task i may be skipped, since an earlier one has found a good enough packing.
+func (c *Component) stopped(i int) bool {
+	return int32(i) > atomic.LoadInt32(&c.stop)
+}
+
+// stopAt records that the search task i has found a good enough packing. The later tasks are
+// skipped, while the earlier ones are still run, so the result does not depend on the order
+// the tasks are processed in.
+func (c *Component) stopAt(i int) {
+	for {
+		s := atomic.LoadInt32(&c.stop)
+		if int32(i) >= s || atomic.CompareAndSwapInt32(&c.stop, s, int32(i)) {
+			return
+		}
+	}
+}
+
+type packTask struct {
+	comp, task int
+}
+
+type packResult struct {
+	packTask
+	Packing
+}
+
+// Store keeps the solved components, so an interrupted packing can resume.
+type Store interface {
+	// Lookup returns the packing of the k-th component with the seed (x, y), if it was solved.
+	Lookup(k, x, y int) (Packing, bool)
+	// Add records the packing of the solved k-th component with the seed (x, y).
+	Add(k, x, y int, p Packing)
+}
+
+// PackAll packs all components using the given number of workers. The search tasks of every
+// component (see searchTask) are independent, so even a single big component is spread over the workers.
+// The task results are merged in order, and the ties are always resolved in favor of the earlier
+// task, so the result is byte-identical to the sequential search whatever the number of workers.
+// Components already solved in the store (if not nil) are not packed again, and the others are
+// added to it. done is called for every component, in no particular order, as soon as it's packed;
+// the component pixels are released right after that. Once stop is closed, no new tasks are
+// started, and the components not finished by then are reported as not solved.
+func PackAll(comps []*Component, jobs int, store Store, stop <-chan struct{},
+	done func(c *Component, p Packing, elapsed time.Duration)) (res []Packing, solved []bool) {
+	res = make([]Packing, len(comps))
+	solved = make([]bool, len(comps))
+	rows := make([][]Packing, len(comps))
+	left := make([]int, len(comps))
+	started := make([]time.Time, len(comps))
+
+	var todo []packTask
+	for k, c := range comps {
+		if p, ok := lookup(store, c); ok {
+			res[k] = p
+			solved[k] = true
+			done(c, res[k], 0)
+			c.release()
+			continue
+		}
+		rows[k] = make([]Packing, c.searchTasks())
+		left[k] = c.searchTasks()
+		for i := 0; i < c.searchTasks(); i++ {
+			todo = append(todo, packTask{k, i})
+		}
+	}
+
+	tasks := make(chan packTask)
+	results := make(chan packResult)
+	var wg sync.WaitGroup
+	for w := 0; w < jobs; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for t := range tasks {
+				results <- packResult{t, comps[t.comp].searchTask(t.task)}
+			}
+		}()
+	}
+	go func() {
+	loop:
+		for _, t := range todo {
+			if started[t.comp].IsZero() {
+				started[t.comp] = time.Now()
+			}
+			select {
+			case tasks <- t:
+			case <-stop:
+				break loop
+			}
+		}
+		close(tasks)
+		wg.Wait()
+		close(results)
+	}()
+
+	for r := range results {
+		k := r.comp
+		rows[k][r.task] = r.Packing
+		left[k]--
+		if left[k] > 0 {
+			continue
+		}
+		for _, p := range rows[k][:imin(int(comps[k].stop)+1, len(rows[k]))] {
+			if p.Better(res[k]) {
+				res[k] = p
+			}
+		}
+		rows[k] = nil
+		solved[k] = true
+		if store != nil {
+			store.Add(k, comps[k].Seed.X, comps[k].Seed.Y, res[k])
+		}
+		done(comps[k], res[k], time.Since(started[k]))
+		comps[k].release()
+	}
+	slog.Debug("Packed all components", "components", len(comps), "tasks", len(todo), "jobs", jobs)
+	return res, solved
+}
+
+// lookup returns the packing of the component from the store, which may be nil.
+func lookup(store Store, c *Component) (Packing, bool) {
+	if store == nil {
+		return Packing{}, false
+	}
+	return store.Lookup(c.ID, c.Seed.X, c.Seed.Y)
+}
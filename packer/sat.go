@@ -0,0 +1,47 @@
+package packer
+
+import (
+	"image"
+
+	"github.com/krasin/png2stencil/stencilimg"
+)
+
+// summedArea is an integral image over the set pixels of a mask, to count them
+// in any rectangle in O(1).
+type summedArea struct {
+	rect image.Rectangle
+	w    int
+	s    []int32
+}
+
+func newSummedArea(img *stencilimg.BitMask) *summedArea {
+	b := img.Bounds()
+	sa := &summedArea{rect: b, w: b.Dx() + 1}
+	sa.s = make([]int32, (b.Dx()+1)*(b.Dy()+1))
+	for y := 0; y < b.Dy(); y++ {
+		var row int32
+		for x := 0; x < b.Dx(); x++ {
+			if img.Get(b.Min.X+x, b.Min.Y+y) {
+				row++
+			}
+			sa.s[(y+1)*sa.w+x+1] = sa.s[y*sa.w+x+1] + row
+		}
+	}
+	return sa
+}
+
+// others returns the number of pixels not set in the rectangle with the inclusive
+// corners (x0, y0) and (x1, y1). The pixels outside of the mask count too.
+func (sa *summedArea) others(x0, y0, x1, y1 int) int {
+	if x1 < x0 || y1 < y0 {
+		return 0
+	}
+	total := (x1 - x0 + 1) * (y1 - y0 + 1)
+	r := image.Rect(x0, y0, x1+1, y1+1).Intersect(sa.rect)
+	if r.Empty() {
+		return total
+	}
+	r = r.Sub(sa.rect.Min)
+	in := sa.s[r.Max.Y*sa.w+r.Max.X] - sa.s[r.Min.Y*sa.w+r.Max.X] - sa.s[r.Max.Y*sa.w+r.Min.X] + sa.s[r.Min.Y*sa.w+r.Min.X]
+	return total - int(in)
+}
@@ -0,0 +1,112 @@
+package packer
+
+import (
+	"fmt"
+
+	"github.com/krasin/png2stencil/geom"
+	"github.com/krasin/png2stencil/stencilimg"
+)
+
+// Strategy is a circle packing algorithm. The offset search calls Fill with the offsets
+// (ox, oy) in [0, ToolDiameter) of every component, and keeps the packing with the most circles.
+// A strategy which has no use for the offset may ignore it.
+type Strategy interface {
+	// Fill returns the centers of the circles (in mm) placed into the component. Every circle
+	// must fit into it, see Component.Fits. Fill is called concurrently for the same component.
+	Fill(c *Component, ox, oy float64) []geom.Point
+}
+
+// StrategyFunc adapts an ordinary function to the Strategy interface.
+type StrategyFunc func(c *Component, ox, oy float64) []geom.Point
+
+// Fill calls f(c, ox, oy).
+func (f StrategyFunc) Fill(c *Component, ox, oy float64) []geom.Point {
+	return f(c, ox, oy)
+}
+
+type namedStrategy struct {
+	name string
+	Strategy
+}
+
+// strategies are the registered packing strategies, in the order they are tried by default.
+// The coarse search has one task per strategy.
+var strategies []namedStrategy
+
+func init() {
+	RegisterStrategy(StrategyTriangle, StrategyFunc(fillTriangle))
+	RegisterStrategy(StrategyQuad, StrategyFunc(fillQuad))
+}
+
+// RegisterStrategy makes the packing strategy available by the name, which is recorded
+// in the packings it places. It's meant to be called from init functions, and panics
+// if the name is already taken.
+func RegisterStrategy(name string, s Strategy) {
+	if HasStrategy(name) {
+		panic(fmt.Sprintf("packer: strategy %q registered twice", name))
+	}
+	strategies = append(strategies, namedStrategy{name, s})
+}
+
+// HasStrategy tells if there's a registered packing strategy with the name.
+func HasStrategy(name string) bool {
+	for _, s := range strategies {
+		if s.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// StrategyNames returns the names of the registered strategies, in the order they are tried by default.
+func StrategyNames() []string {
+	var names []string
+	for _, s := range strategies {
+		names = append(names, s.name)
+	}
+	return names
+}
+
+// strategies returns the strategies to try, in the order of Params.Strategies.
+func (p *Params) strategies() []namedStrategy {
+	if len(p.Strategies) == 0 {
+		return strategies
+	}
+	var res []namedStrategy
+	for _, name := range p.Strategies {
+		for _, s := range strategies {
+			if s.name == name {
+				res = append(res, s)
+			}
+		}
+	}
+	return res
+}
+
+// Tool returns the diameter of the circles (in mm).
+func (c *Component) Tool() float64 {
+	return c.params.ToolDiameter
+}
+
+// PxSize returns the size of the component pixels (in mm). It's Params.PxSize/Params.N, or more
+// for the big components, see Params.Adaptive.
+func (c *Component) PxSize() float64 {
+	return c.px
+}
+
+// Rect returns the bounding box of the component (in mm).
+func (c *Component) Rect() (min, max geom.Point) {
+	return geom.Pt(float64(c.box.Min.X)*c.px, float64(c.box.Min.Y)*c.px),
+		geom.Pt(float64(c.box.Max.X+1)*c.px, float64(c.box.Max.Y+1)*c.px)
+}
+
+// Pixels returns the component mask at its pixel size. The pixels are only kept while
+// the component is packed.
+func (c *Component) Pixels() *stencilimg.BitMask {
+	return c.mask()
+}
+
+// Fits tells if the circle of the Tool diameter centered at p (in mm) fits into the component.
+func (c *Component) Fits(p geom.Point) bool {
+	return c.fits(p.X, p.Y, c.params.ToolDiameter/2, c.px)
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log/slog"
+
+	"github.com/krasin/png2stencil/geom"
+	"github.com/krasin/png2stencil/stencil"
+)
+
+// writeVolumeReport writes a CSV with the paste volume each aperture deposits, compared to
+// the volume of the full pad, for a stencil of the given thickness (in mm).
+func writeVolumeReport(out io.Writer, st *stencil.Stats, thick float64) error {
+	basePxSize := *pxSize / float64(*n)
+	pxArea := basePxSize * basePxSize
+	w := csv.NewWriter(out)
+	w.Write([]string{"aperture", "x_mm", "y_mm", "pad_area_mm2", "open_area_mm2", "ideal_volume_mm3", "volume_mm3", "volume_ratio"})
+	for _, c := range st.Apertures {
+		p := toMachine(geom.Pt(float64(c.X)*basePxSize, float64(c.Y)*basePxSize))
+		w.Write([]string{
+			fmt.Sprint(c.ID),
+			fmt.Sprintf("%.3f", p.X),
+			fmt.Sprintf("%.3f", p.Y),
+			fmt.Sprintf("%.4f", float64(c.Area)*pxArea),
+			fmt.Sprintf("%.4f", float64(c.Covered)*pxArea),
+			fmt.Sprintf("%.5f", float64(c.Area)*pxArea*thick),
+			fmt.Sprintf("%.5f", float64(c.Covered)*pxArea*thick),
+			fmt.Sprintf("%.3f", ratio(c.Covered, c.Area)),
+		})
+	}
+	w.Flush()
+	return w.Error()
+}
+
+func saveVolumeReport(name string, st *stencil.Stats) error {
+	err := writeFile(name, func(w io.Writer) error {
+		return writeVolumeReport(w, st, *thickness)
+	})
+	if err != nil {
+		return errorf(exitWriteFailed, "failed to save paste volume report %q: %w", name, err)
+	}
+	return nil
+}
+
+// writeReleaseReport writes a CSV with the IPC-7525 area and aspect ratios of each aperture, for
+// a stencil of the given thickness (in mm), and warns about the ones releasing the paste poorly.
+func writeReleaseReport(out io.Writer, plan *stencil.Plan, thick float64) error {
+	w := csv.NewWriter(out)
+	w.Write([]string{"aperture", "x_mm", "y_mm", "width_mm", "length_mm", "area_mm2", "perimeter_mm", "area_ratio", "aspect_ratio", "ok"})
+	for _, r := range plan.Release(thick) {
+		p := plan.Frame.ToMachine(r.At)
+		if !r.OK() {
+			slog.Warn("Poor paste release", "aperture", r.ID, "x", p.X, "y", p.Y, "area_ratio", r.AreaRatio, "aspect_ratio", r.AspectRatio)
+		}
+		w.Write([]string{
+			fmt.Sprint(r.ID),
+			fmt.Sprintf("%.3f", p.X),
+			fmt.Sprintf("%.3f", p.Y),
+			fmt.Sprintf("%.3f", r.Width),
+			fmt.Sprintf("%.3f", r.Length),
+			fmt.Sprintf("%.4f", r.Area),
+			fmt.Sprintf("%.3f", r.Perimeter),
+			fmt.Sprintf("%.3f", r.AreaRatio),
+			fmt.Sprintf("%.3f", r.AspectRatio),
+			fmt.Sprint(r.OK()),
+		})
+	}
+	w.Flush()
+	return w.Error()
+}
+
+func saveReleaseReport(name string, plan *stencil.Plan) error {
+	err := writeFile(name, func(w io.Writer) error {
+		return writeReleaseReport(w, plan, *thickness)
+	})
+	if err != nil {
+		return errorf(exitWriteFailed, "failed to save paste release report %q: %w", name, err)
+	}
+	return nil
+}
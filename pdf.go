@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+
+	"github.com/krasin/png2stencil/geom"
+	"github.com/krasin/png2stencil/stencilimg"
+)
+
+const (
+	ptPerMM   = 72 / 25.4
+	pdfMargin = 10.0 // mm
+	bezierK   = 0.5522847498
+)
+
+// pdfContent builds a PDF content stream. All coordinates are in mm in the machine space.
+type pdfContent struct {
+	bytes.Buffer
+}
+
+func (c *pdfContent) moveTo(p geom.Point) { fmt.Fprintf(c, "%.4f %.4f m\n", p.X, p.Y) }
+func (c *pdfContent) lineTo(p geom.Point) { fmt.Fprintf(c, "%.4f %.4f l\n", p.X, p.Y) }
+
+func (c *pdfContent) circle(p geom.Point, r float64) {
+	k := r * bezierK
+	fmt.Fprintf(c, "%.4f %.4f m\n", p.X+r, p.Y)
+	fmt.Fprintf(c, "%.4f %.4f %.4f %.4f %.4f %.4f c\n", p.X+r, p.Y+k, p.X+k, p.Y+r, p.X, p.Y+r)
+	fmt.Fprintf(c, "%.4f %.4f %.4f %.4f %.4f %.4f c\n", p.X-k, p.Y+r, p.X-r, p.Y+k, p.X-r, p.Y)
+	fmt.Fprintf(c, "%.4f %.4f %.4f %.4f %.4f %.4f c\n", p.X-r, p.Y-k, p.X-k, p.Y-r, p.X, p.Y-r)
+	fmt.Fprintf(c, "%.4f %.4f %.4f %.4f %.4f %.4f c\n", p.X+k, p.Y-r, p.X+r, p.Y-k, p.X+r, p.Y)
+	c.WriteString("h\n")
+}
+
+func (c *pdfContent) polyline(pts []geom.Point, closed bool) {
+	for i, p := range pts {
+		if i == 0 {
+			c.moveTo(p)
+		} else {
+			c.lineTo(p)
+		}
+	}
+	if closed {
+		c.WriteString("h\n")
+	}
+}
+
+// writePDF writes a single page PDF with the apertures and the toolpath at the exact physical
+// scale, so it can be printed at 100% and laid over the board. width and height are the image
+// size (in mm).
+func writePDF(w io.Writer, width, height float64, contours [][]geom.Point, centers []geom.Point, r float64, paths [][]geom.Point) error {
+	var c pdfContent
+	// Switch to mm with the image origin at the margin.
+	fmt.Fprintf(&c, "%.6f 0 0 %.6f %.4f %.4f cm\n", ptPerMM, ptPerMM, pdfMargin*ptPerMM, pdfMargin*ptPerMM)
+	c.WriteString("0.05 w\n")
+
+	// Image frame.
+	fmt.Fprintf(&c, "0.6 G 0 0 %.4f %.4f re S\n", width, height)
+
+	// Apertures from the input image.
+	c.WriteString("0.85 g\n")
+	for _, poly := range contours {
+		var pts []geom.Point
+		for _, p := range poly {
+			pts = append(pts, toMachine(p))
+		}
+		c.polyline(pts, true)
+	}
+	if len(contours) > 0 {
+		c.WriteString("f*\n")
+	}
+
+	// Milled circles.
+	c.WriteString("1 0 0 RG\n")
+	for _, p := range centers {
+		c.circle(toMachine(p), r)
+		c.WriteString("S\n")
+	}
+
+	// Toolpath.
+	c.WriteString("0 0 1 RG 0.02 w\n")
+	for _, path := range paths {
+		var pts []geom.Point
+		for _, p := range path {
+			pts = append(pts, toMachine(p))
+		}
+		c.polyline(pts, false)
+		c.WriteString("S\n")
+	}
+
+	// 10 mm scale bar below the image to verify the print scale.
+	c.WriteString("0 G 0.2 w\n")
+	c.polyline([]geom.Point{geom.Pt(0, -3), geom.Pt(0, -5), geom.Pt(10, -5), geom.Pt(10, -3)}, false)
+	c.WriteString("S\n")
+	fmt.Fprintf(&c, "BT /F1 2.5 Tf 12 -5 Td (10 mm) Tj ET\n")
+
+	pageW := (width + 2*pdfMargin) * ptPerMM
+	pageH := (height + 2*pdfMargin) * ptPerMM
+	objs := []string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+		fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %.4f %.4f] /Contents 4 0 R /Resources << /Font << /F1 5 0 R >> >> >>", pageW, pageH),
+		fmt.Sprintf("<< /Length %d >>\nstream\n%sendstream", c.Len(), c.String()),
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica >>",
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("%PDF-1.4\n")
+	var offsets []int
+	for i, obj := range objs {
+		offsets = append(offsets, buf.Len())
+		fmt.Fprintf(&buf, "%d 0 obj\n%s\nendobj\n", i+1, obj)
+	}
+	xref := buf.Len()
+	fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f \n", len(objs)+1)
+	for _, off := range offsets {
+		fmt.Fprintf(&buf, "%010d 00000 n \n", off)
+	}
+	fmt.Fprintf(&buf, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objs)+1, xref)
+	_, err := buf.WriteTo(w)
+	return err
+}
+
+// savePDF saves the 1:1 preview. If there are no cutting paths (as in the dispense mode),
+// the toolpath is the travel between the milled circles.
+func savePDF(name string, base stencilimg.PixelMask, centers []geom.Point, paths [][]geom.Point) error {
+	basePxSize := *pxSize / float64(*n)
+	if paths == nil && len(centers) > 1 {
+		paths = [][]geom.Point{centers}
+	}
+	contours := stencilimg.TraceContours(base, basePxSize)
+	width := float64(base.Bounds().Dx()) * basePxSize
+	height := float64(base.Bounds().Dy()) * basePxSize
+	err := writeFile(name, func(w io.Writer) error {
+		return writePDF(w, width, height, contours, centers, cutDiameter()/2, paths)
+	})
+	if err != nil {
+		return errorf(exitWriteFailed, "failed to save PDF file %q: %w", name, err)
+	}
+	return nil
+}
@@ -1,363 +1,867 @@
 package main
 
 import (
+	"bufio"
+	"context"
 	"flag"
 	"fmt"
 	"image"
 	"image/color"
 	"image/draw"
 	"image/png"
-	"io/ioutil"
+	"io"
+	"log/slog"
 	"math"
 	"os"
+	"os/signal"
+	"runtime"
 	"strings"
+	"syscall"
 	"time"
+
+	"github.com/krasin/png2stencil/gcode"
+	"github.com/krasin/png2stencil/geom"
+	"github.com/krasin/png2stencil/packer"
+	"github.com/krasin/png2stencil/stencil"
+	"github.com/krasin/png2stencil/stencilimg"
 )
 
 var (
 	input        = flag.String("input", "", "Input PNG file with a solder paste map")
-	output       = flag.String("output", "", "Output G-code file")
+	inputTop     = flag.String("input_top", "", "Input PNG file with the top solder paste map of a dual-sided job, which converts it and the --input_bottom in one run, writing the outputs with _top and _bottom in their names")
+	inputBottom  = flag.String("input_bottom", "", "Input PNG file with the bottom solder paste map of a dual-sided job, exported as seen from the top with the same bounds as the --input_top; it's mirrored")
+	mirror       = flag.Bool("mirror", false, "Flip the input left to right, for the bottom side exported as seen from the top")
+	output       = flag.String("output", "", "Output G-code file, or a text/template of its name with .InputBase, .Tool, .ToolName, .Mode, .Dialect, .Machine, .Material and .N, like {{.InputBase}}_{{.Tool}}mm_{{.Dialect}}.nc")
 	pxSize       = flag.Float64("px_size", math.NaN(), "Size of a pixel side (in mm)")
 	toolDiameter = flag.Float64("tool_diameter", math.NaN(), "Tool diameter (in mm)")
+	runout       = flag.Float64("runout", 0, "Measured cut width minus the tool diameter (in mm), like 0.06 for a 0.8 mm bit cutting 0.86 mm; the geometry uses the cut width, and the tool change prompts the --tool_diameter")
 	millHeight   = flag.Float64("mill_height", math.NaN(), "Mill height (in mm)")
 	safeHeight   = flag.Float64("safe_height", math.NaN(), "Safe height to move between mill points (in mm)")
 	millRate     = flag.Float64("mill_rate", math.NaN(), "Mill rate (mm/min)")
 	travelRate   = flag.Float64("travel_rate", math.NaN(), "Travel rate (mm/min)")
 	n            = flag.Int("n", 1, "Number of linear subpixels for each pixel, when searching for an optimal milling positions")
+	maxPixels    = flag.Int64("max_pixels", stencil.DefaultMaxPixels, "Largest image to convert, magnified --n times (in pixels), so a huge input fails early instead of exhausting the memory; 0 for no limit")
+	maxPartial   = flag.Float64("max_partial", 0.1, "Warn if more than this share (0..1) of the non-background pixels are partially colored, like the anti-aliased edges, which all become apertures; 0 not to check")
 	background   = flag.String("background", "", "Background color: black or white")
 	dispenseTime = flag.Duration("dispense_time", 50*time.Millisecond, "Time to keep the dispenser valve opened for each shot")
-
-	flagsNotSet []string
+	outputSTL    = flag.String("output_stl", "", "Optional output STL file with a printable stencil mesh")
+	thickness    = flag.Float64("thickness", math.NaN(), "Stencil thickness (in mm)")
+	breakthrough = flag.Float64("breakthrough", 0.05, "How deep below the material the knife must cut, given the --thickness, in the knife mode (in mm); a --mill_height not reaching it is refused")
+	zReference   = flag.String("z_reference", gcode.ZReferenceTop, "Where Z is zeroed: top (on the material surface) or bottom (on the spoilboard under the material, so the --thickness is added to the heights); --mill_height and --safe_height are relative to the material surface either way")
+	volumeReport = flag.String("volume_report", "", "Optional output CSV file with the paste volume of each aperture, given the stencil --thickness")
+	jobReportOut = flag.String("report", "", "Optional job report with the preview, the statistics, the warnings and the flags, for the work orders: Markdown, or HTML if the name ends with .html")
+	relReport    = flag.String("release_report", "", "Optional output CSV file with the IPC-7525 area and aspect ratios of each aperture, given the stencil --thickness; the ones below 0.66 and 1.5 release the paste poorly")
+	outputDXF    = flag.String("output_dxf", "", "Optional output R12 DXF file with apertures and toolpath on separate layers, in mm")
+	outputHPGL   = flag.String("output_hpgl", "", "Optional output HP-GL file for plotters and cutters")
+	outputCAM    = flag.String("output_camotics", "", "Optional output CAMotics project file to simulate the G-code")
+	outputGerber = flag.String("output_gerber", "", "Optional output RS-274X file with the as-milled apertures")
+	outputPDF    = flag.String("output_pdf", "", "Optional output PDF file with a 1:1 scale preview")
+	outputSVG    = flag.String("output_svg", "", "Optional output SVG file with a preview of the apertures, the circles and the toolpath in mm")
+	mode         = flag.String("mode", "dispense", "Machine mode: dispense (Z plunges with a dispenser valve), laser (hatching with the laser on/off) or knife (drag knife contours)")
+	laserCmd     = flag.String("laser_cmd", "m3", "Laser on/off commands: m3 (M3 S/M5) or m106 (M106 S/M107)")
+	laserPower   = flag.Int("laser_power", 255, "Laser power (S value)")
+	passes       = flag.Int("passes", 1, "Number of laser passes over each aperture")
+	hatchSpacing = flag.Float64("hatch_spacing", math.NaN(), "Distance between laser hatching lines (in mm)")
+	knifeOffset  = flag.Float64("knife_offset", math.NaN(), "Distance from the drag knife axis to the blade tip (in mm)")
+	knifeAngle   = flag.Float64("knife_angle", 10, "Minimal direction change (in degrees) to swivel the drag knife around a corner")
+	verbose      = flag.Bool("verbose", false, "Print the timing of every component, same as --log_level=debug")
+	logLevel     = flag.String("log_level", "error", "Log level: error, info or debug")
+	ckptFile     = flag.String("checkpoint", "", "Optional checkpoint file to periodically save the solved components to, and to resume from")
+	force        = flag.Bool("force", false, "Overwrite the existing output files, which fail the run before the conversion otherwise")
+	dryRun       = flag.Bool("dry_run", false, "Analyze the input and print the statistics without writing G-code or debug images")
+	minWeb       = flag.Float64("min_web", 0, "Optional minimal width of the stencil material between the apertures (in mm), like 0.15, as the thinner webs tear; the thinner ones are reported and fail the run")
+	maxUncovered = flag.Float64("max_uncovered", 1, "Largest share of the aperture area (0..1) the circles may leave uncovered in the dispense mode; a run leaving more fails without writing the outputs, unlike with --min_coverage")
+	minCoverage  = flag.Float64("min_coverage", 0, "Minimal share of the aperture area covered by circles (0..1); exit with a warning code if not reached")
+	maxX         = flag.Float64("max_x", math.NaN(), "Optional machine X travel limit (in mm)")
+	maxY         = flag.Float64("max_y", math.NaN(), "Optional machine Y travel limit (in mm)")
+	minZ         = flag.Float64("min_z", math.NaN(), "Optional machine Z travel limit (in mm)")
+	arcTolerance = flag.Float64("arc_tolerance", 0, "Optional distance (in mm) within which the closed knife paths, like the ones of the round pads, are fitted to a circle cut with a single G2/G3 arc, which also cuts the holes; about the pixel size works for the traced contours; 0 for the straight moves only")
+	backlashX    = flag.Float64("backlash_x", 0, "Slack (in mm) of the X drive, taken up with an extra move every time the X motion reverses; 0 for none")
+	backlashY    = flag.Float64("backlash_y", 0, "Slack (in mm) of the Y drive, see --backlash_x")
+	sheetX       = flag.Float64("sheet_x", math.NaN(), "Optional width (in mm) of the material blank from the machine origin, which the stencil must fit with its holes, label and serial code; the nest subcommand lays the stencils out on it")
+	sheetY       = flag.Float64("sheet_y", math.NaN(), "Optional height (in mm) of the material blank from the machine origin, see --sheet_x")
+	maxFeed      = flag.Float64("max_feed", math.NaN(), "Optional fastest X and Y feed of the machine (mm/min), which the controller would cap the faster rates at or alarm on")
+	maxZFeed     = flag.Float64("max_z_feed", math.NaN(), "Optional fastest Z feed of the machine (mm/min), like --max_feed; the plunges are at the --mill_rate, and the retracts at the --travel_rate")
+	feedLimit    = flag.String("feed_limit", feedLimitError, "What to do with the rates above --max_feed and --max_z_feed: error or clamp (lower them to the limits with a warning)")
+	jobs         = flag.Int("jobs", runtime.GOMAXPROCS(0), "Number of parallel packing workers; the output is the same for any number")
+	logFormat    = flag.String("log_format", "text", "Log format: text or json")
+	search       = flag.String("search", "coarse", "Lattice offset search: coarse (a coarse grid refined around the best offsets) or full (all offsets, about 10 times slower)")
+	adaptiveN    = flag.Bool("adaptive_n", true, "Pack the components much bigger than the tool with fewer subpixels than --n, where the precision matters less")
+	strategy     = flag.String("strategy", "", "Comma-separated packing strategies to try, in this order; all of them if empty: "+strings.Join(packer.StrategyNames(), ", "))
+	covTarget    = flag.Float64("coverage_target", 1, "Stop searching the lattice offsets of a component once a packing covers this share of its area (0..1]")
+	connectivity = flag.Int("connectivity", 4, "Pixel connectivity of the apertures: 4 (the pixels touching diagonally are separate apertures) or 8 (they are one)")
+	samples      = flag.Int("circle_samples", 1, "Number of the sample points along each pixel side the circles are tested against; more are more accurate, but slower; 0 for the exact test, where the circles don't overlap the background pixels at all")
+	tolerance    = flag.Float64("tolerance", stencil.DefaultTolerance, "How far apart the coordinates may be and still compare equal (in mm), in the circle tests against the image and the aperture edges, and in merging the circle centers")
+	edgeTol      = flag.Float64("edge_tolerance", 0, "How far the circles may protrude beyond the apertures (in mm), like 0.02, so the fine pitch pads missing a circle by a subpixel are filled")
+	mergeDist    = flag.Float64("merge_distance", 0.01, "Merge the circle centers closer than this (in mm), so the machine doesn't plunge twice at the same spot; 0 to keep them all")
+	enlargeSmall = flag.Bool("enlarge_small", false, "Plunge once at the center of every aperture no circle fits into, in the dispense mode, since a slightly oversized dot is usually better than none")
+	order        = flag.String("order", "components", "Order of the circles: components (one component after another, in the column-major order of their first pixels) or nearest (greedy nearest neighbor from the machine origin)")
+	debugImages  = flag.Bool("debug_images", true, "Save the base, out and uncovered debug images; they take a lot of memory for large inputs at high --n")
+	batchSize    = flag.Int("batch_size", 2, "Number of inputs converted at a time by the batch and the serve subcommands")
+	sheetGap     = flag.Float64("sheet_gap", 2, "Distance (in mm) the nest subcommand keeps between the stencils and from the sheet edges")
+	cacheDir     = flag.String("cache_dir", defaultCacheDir(), "Directory to cache the packings in, so the reruns with other machining parameters are instant; the 100 most recent are kept, and a cache which can't be used is skipped with a warning; empty to disable")
+	cpuProfile   = flag.String("cpuprofile", "", "Optional output file for the CPU profile")
+	memProfile   = flag.String("memprofile", "", "Optional output file for the heap profile, written at exit")
+	pprofAddr    = flag.String("pprof_addr", "", "Optional address (like localhost:6060) to serve net/http/pprof on during the run")
+	configFile   = flag.String("config", "", "Optional TOML file with the values of the other flags, like px_size = 0.05; the command line flags override them")
+	machine      = flag.String("machine", "", "Optional machine profile with the dialect, the rates, the travel limits and the safe height, which the other flags override: "+strings.Join(machineNames(), ", ")+", or a .toml file")
+	material     = flag.String("material", "", "Optional stencil sheet preset with the thickness, the mill height, the mill rate, the spindle speed, the laser passes and power, in the laser and the knife modes, which the other flags override: "+strings.Join(materialNames(), ", ")+", or a .toml file")
+	toolsFile    = flag.String("tools", "", "Optional TOML tool library with a table per tool: diameter, flutes, max_plunge_rate, rpm and optional chip_load")
+	toolName     = flag.String("tool", "", "Optional name of the tool in the --tools library, which sets --tool_diameter and --mill_rate")
+	watch        = flag.Bool("watch", false, "Convert again every time the --input, the --config, the --tools, the --machine or the --material file changes, until interrupted")
+	port         = flag.String("port", "", "Serial port of the GRBL controller the send subcommand streams the program to, like /dev/ttyUSB0")
+	baud         = flag.Int("baud", 115200, "Baud rate of the --port")
+	heightMap    = flag.String("height_map", "", "Optional probed height map of the work surface, with an x y z line per grid point (in mm); its height is added to every Z of the G-code")
+	levelSegment = flag.Float64("level_segment", 1, "Longest cutting move (in mm) with the --height_map; the longer ones are split, so Z follows the surface")
+	fiducials    = flag.String("fiducials", "", "Optional fiducial positions as designed (in the G-code space) and as measured on the machine, like 10,10=10.2,9.9;90,60=90.3,59.5; the G-code is rotated, scaled and moved to fit them")
+	regHoles     = flag.String("registration_holes", "", "Optional centers of the registration holes for the frame pins (in the G-code space), like 5,5;95,5, cut before the apertures in the laser and the knife modes")
+	regTool      = flag.String("registration_tool", "", "Optional name of the tool in the --tools library to mill the --registration_holes with, changed to before them and back after them, in the knife mode")
+	drillRPM     = flag.Float64("drill_rpm", 0, "Optional spindle speed (M3 S) of the --registration_holes the tool fills, which are just plunged, in the knife mode; 0 turns the spindle off for them")
+	millRPM      = flag.Float64("mill_rpm", 0, "Optional spindle speed (M3 S) of the milled --registration_holes and apertures in the knife mode; 0 turns the spindle off for them, as for a drag knife")
+	coolant      = flag.String("coolant", "", "Optional coolant on while cutting in the laser and the knife modes: flood (M8), mist (M7) or air (M7, the air blast is usually wired as mist)")
+	toolChange   = flag.String("tool_change", "m0", "Tool change commands: m0 (pause with a prompt to change the tool and re-zero Z) or m6 (M6 T for a tool changer)")
+	label        = flag.String("label", "", "Optional text engraved on the stencil after the apertures in the laser and the knife modes, like \"BOARD-REV-C TOP\", in a single-stroke font of A-Z, 0-9 and -+_.,:/()#")
+	labelAt      = flag.String("label_at", "", "Lower left corner of the --label (in the G-code space), like 2,2")
+	labelHeight  = flag.Float64("label_height", 3, "Height of the --label letters (in mm)")
+	labelDepth   = flag.Float64("label_depth", math.NaN(), "Depth of the --label engraving below the material top (in mm) in the knife mode, shallower than the cut")
+	labelPower   = flag.Int("label_power", 0, "Laser power (S) of the single --label pass in the laser mode; 0 is the --laser_power")
+	serial       = flag.String("serial", "", "Optional ID, like SN-0042, cut after the apertures in the laser and the knife modes as a Data Matrix code of the holes for the traceability; up to 44 characters, fewer if not digits")
+	serialAt     = flag.String("serial_at", "", "Lower left corner of the --serial code (in the G-code space), like 2,2")
+	serialModule = flag.Float64("serial_module", 0.5, "Distance between the --serial code holes (in mm)")
+	serialDot    = flag.Float64("serial_dot", math.NaN(), "Diameter of the --serial code holes (in mm); 0.6 of the --serial_module if not set")
+	cornerInset  = flag.Float64("corner_holes", 0, "Optional distance (in mm) from the image edges to the centers of the --registration_holes added at its four corners for the frame, cut before the apertures; 0 for none")
+	regDiameter  = flag.Float64("registration_diameter", math.NaN(), "Diameter of the --registration_holes and the --corner_holes (in mm)")
+	hookFirstCut = flag.String("hook_first_cut", "", "Optional G-code added before the first plunge or laser cut, like M8 for the vacuum; a text/template with .Cuts and .Tool, \\n separates the lines")
+	hookBeforeTC = flag.String("hook_before_tool_change", "", "Optional G-code added before every tool change, like --hook_first_cut")
+	hookAfterTC  = flag.String("hook_after_tool_change", "", "Optional G-code added after every tool change, like --hook_first_cut")
+	hookEvery    = flag.String("hook_every", "", "Optional G-code added after every --hook_every_n cuts, like M0 to refill the paste; like --hook_first_cut")
+	hookEveryN   = flag.Int("hook_every_n", 0, "Number of cuts between the --hook_every snippets")
+	hookEnd      = flag.String("hook_end", "", "Optional G-code added at the end of the program, like M9; like --hook_first_cut")
+	probeSpacing = flag.Float64("probe_spacing", 10, "Largest distance between the probed points of the probe subcommand (in mm)")
+	probeDepth   = flag.Float64("probe_depth", 2, "Depth below the Z zero the probe subcommand gives up at (in mm)")
+	probeRate    = flag.Float64("probe_rate", 50, "Probing feed rate (mm/min)")
+	listen       = flag.String("listen", "localhost:8080", "Address the serve subcommand listens on")
+	dialect      = flag.String("dialect", gcode.DialectMarlin, "G-code dialect of the controller: "+strings.Join(gcode.DialectNames(), ", "))
+
+	// imgW and imgH are the base image size (in base pixels). The base image space starts at
+	// the top left corner of the input, whatever its Bounds().Min is.
+	imgW, imgH int
 )
 
-type Point struct {
-	X, Y float64
-}
+// Subcommands. Without one, the input is converted.
+const (
+	cmdConvert = "convert"
+	cmdPreview = "preview"
+	cmdCheck   = "check"
+	cmdStats   = "stats"
+	cmdBench   = "bench"
+	cmdBatch   = "batch"
+	cmdNest    = "nest"
+
+	cmdCompletion = "completion"
+	cmdVersion    = "version"
+	cmdSelftest   = "selftest"
+	cmdServe      = "serve"
+	cmdSend       = "send"
+	cmdProbe      = "probe"
+	cmdHeightmap  = "heightmap"
+	cmdInit       = "init"
+)
 
-func checkFloat64(name string, val float64) {
-	if math.IsNaN(val) {
-		flagsNotSet = append(flagsNotSet, name)
+func main() {
+	flag.Usage = usage
+	cmd, args := cmdConvert, os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		cmd, args = args[0], args[1:]
 	}
-}
-
-func checkString(name string, val string) {
-	if val == "" {
-		flagsNotSet = append(flagsNotSet, name)
+	switch cmd {
+	case cmdBench:
+		exitWith(exitOK, runBench(args))
+	case cmdBatch:
+		exitWith(runBatch(args))
+	case cmdNest:
+		exitWith(runNest(args))
+	case cmdCompletion:
+		exitWith(exitOK, runCompletion(args))
+	case cmdVersion:
+		exitWith(exitOK, runVersion())
+	case cmdSelftest:
+		exitWith(exitOK, runSelftest(args))
+	case cmdServe:
+		exitWith(exitOK, runServe(args))
+	case cmdSend:
+		exitWith(exitOK, runSend(args))
+	case cmdProbe:
+		exitWith(exitOK, runProbe(args))
+	case cmdHeightmap:
+		exitWith(exitOK, runHeightmap(args))
+	case cmdInit:
+		exitWith(runInit(args))
+	case cmdConvert, cmdPreview, cmdStats:
+		if err := parseFlags(args); err != nil {
+			exitWith(0, err)
+		}
+		if *watch {
+			exitWith(exitOK, runWatch(cmd, args))
+		}
+		if *inputTop != "" || *inputBottom != "" {
+			exitWith(runSides(cmd, args))
+		}
+		exitWith(convert(cmd))
+	case cmdCheck:
+		if err := parseFlags(args); err != nil {
+			exitWith(0, err)
+		}
+		exitWith(exitOK, runCheck())
 	}
+	exitWith(0, errorf(exitBadFlags, "unknown subcommand: %s", cmd))
 }
 
-func checkDuration(name string, val time.Duration) {
-	if val == 0 {
-		flagsNotSet = append(flagsNotSet, name)
+// convert converts the --input image and writes the outputs. The stats subcommand is the same
+// as --dry_run, and the preview one writes all outputs but the G-code. It returns the exit code
+// of a run which produced the outputs (a warning, if any), or the error which stopped it.
+func convert(cmd string) (int, error) {
+	if cmd == cmdStats {
+		*dryRun = true
+	}
+	// The G-code is checked, but not written in the dry run and by the preview subcommand.
+	writeGCode := !*dryRun && cmd != cmdPreview
+	if cmd == cmdPreview && *outputCAM != "" {
+		return 0, errorf(exitBadFlags, "--output_camotics needs the G-code, which preview does not write")
+	}
+	opts, err := checkFlags(writeGCode)
+	if err != nil {
+		return 0, err
+	}
+	if err := checkOverwrite(outputFiles(writeGCode)...); err != nil {
+		return 0, err
+	}
+	if err := startProfiling(); err != nil {
+		return 0, err
 	}
-}
-
-func failf(format string, args ...interface{}) {
-	fmt.Fprintf(os.Stderr, format, args...)
-	os.Exit(1)
-}
 
-func main() {
-	// Checking flags
-	flag.Parse()
-	checkString("--input", *input)
-	checkString("--output", *output)
-	checkString("--background", *background)
-	checkFloat64("--px_size", *pxSize)
-	checkFloat64("--tool_diameter", *toolDiameter)
-	checkFloat64("--mill_height", *millHeight)
-	checkFloat64("--safe_height", *safeHeight)
-	checkFloat64("--mill_rate", *millRate)
-	checkFloat64("--travel_rate", *travelRate)
-	checkDuration("--dispense_time", *dispenseTime)
-
-	if len(flagsNotSet) > 0 {
-		failf("Some mandatory flags not set: %s.\n", strings.Join(flagsNotSet, ", "))
+	hm, err := loadHeightMap(*heightMap)
+	if err != nil {
+		return 0, err
+	}
+	fid, err := loadFiducials()
+	if err != nil {
+		return 0, err
 	}
 
 	// Reading input PNG image
-	in := mustLoadPNG(*input)
-
-	// Making a gray-scale image with all subpixels. I would prefer to make it a bit image,
-	// but image package does not have one, and it's probably unreasonable to implement just
-	// for this tiny utility.
-	var bk color.Color
-	switch *background {
-	case "black":
-		bk = color.Black
-	case "white":
-		bk = color.White
-	default:
-		failf("Unknown color: %s", *background)
+	in, err := loadPNG(*input)
+	if err != nil {
+		return 0, err
 	}
-	bkr, bkg, bkb, _ := bk.RGBA()
-
-	x0 := in.Bounds().Min.X
-	y0 := in.Bounds().Min.Y
-	y1 := in.Bounds().Max.Y
-
-	base := image.NewGray(image.Rect(0, 0, in.Bounds().Dx()*(*n), in.Bounds().Dy()*(*n)))
-	for i := range base.Pix {
-		x := x0 + (i%base.Stride) / *n
-		y := y0 + (i/base.Stride) / *n
-		cr, cg, cb, _ := in.At(x, y).RGBA()
-		if bkr == cr && bkg == cg && bkb == cb {
-			base.Pix[i] = 0
-		} else {
-			base.Pix[i] = 255
+	imgW, imgH = in.Bounds().Dx()*(*n), in.Bounds().Dy()*(*n)
+	// The corner holes are inside the image, so the sheet is checked before the packing.
+	if err := checkSheet(stencilBounds(opts.RegistrationHoles)); err != nil {
+		if writeGCode {
+			return 0, err
 		}
+		slog.Warn("The G-code would not be written", "error", err)
 	}
 
-	// Save base image for debug purposes
-	mustSavePNG("base.debug.png", base)
-
-	// Fill the base image with circles
-	// For now, use the dumbest algorithm: triangular tiling with a center in (0,0) and angle = 0
-	// See http://en.wikipedia.org/wiki/File:Triangular_tiling_circle_packing.png for the insight
-	shiftN := 32
-	shift := (*toolDiameter) / float64(shiftN)
-
-	var res []Point
-	for curX := 0; curX < base.Bounds().Dx(); curX++ {
-		for curY := 0; curY < base.Bounds().Dy(); curY++ {
-			if base.Pix[curY*base.Stride+curX] != 255 {
-				continue
+	prog := newProgress()
+	opts.Progress = prog.update
+	// The cache works as a checkpoint which is kept after the run. With both, the checkpoint
+	// is resumed from the cached components too, and the cache is updated at the end.
+	var cp, cache *checkpoint
+	if *ckptFile != "" || *cacheDir != "" {
+		key, err := packingKey()
+		if err != nil {
+			return 0, err
+		}
+		if *ckptFile != "" {
+			if cp, err = loadCheckpoint(*ckptFile, key); err != nil {
+				return 0, err
 			}
-			bbox := floodFill(base, 1, curX, curY)
-			var best []Point
-			try := func(centers []Point) {
-				if len(best) < len(centers) {
-					best = centers
-				}
+		}
+		if *cacheDir != "" {
+			if cache, err = openCache(key); err != nil {
+				slog.Warn("Not using the packing cache", "dir", *cacheDir, "err", err)
+				cache = nil
 			}
-
-			for i := 0; i < shiftN; i++ {
-				for j := 0; j < shiftN; j++ {
-					try(fillTriangle(base, 1, bbox, float64(i)*shift, float64(j)*shift))
-					try(fillQuad(base, 1, bbox, float64(i)*shift, float64(j)*shift))
+		}
+		if cache != nil {
+			if cp == nil {
+				cp = cache
+			}
+			for k, c := range cache.Components {
+				if _, ok := cp.Components[k]; !ok {
+					cp.Components[k] = c
 				}
 			}
-			res = append(res, best...)
-			floodFill(base, 254, curX, curY)
 		}
+		if cp != nil {
+			opts.Store = cp
+		}
+	}
+	// On the first Ctrl-C, the packing stops and the outputs are written for the components
+	// solved so far. The second one kills the process as usual.
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		if _, ok := <-sigs; ok {
+			signal.Reset(os.Interrupt, syscall.SIGTERM)
+			cancel()
+		}
+	}()
+	plan, err := stencil.Convert(ctx, in, opts)
+	if err != nil {
+		return 0, fmt.Errorf("failed to convert %q: %w", *input, err)
+	}
+	signal.Stop(sigs)
+	close(sigs)
+	if plan.Interrupted {
+		prog.stop()
+	} else {
+		signal.Reset(os.Interrupt, syscall.SIGTERM)
+	}
+	cancel()
+	if err := checkWebs(plan); err != nil {
+		return 0, err
+	}
+	if err := checkUncovered(plan); err != nil {
+		return 0, err
+	}
+	base := plan.Base
+	basePxSize := plan.PxSize
+	res := plan.Centers
+	st := plan.Stats
+
+	switch {
+	case cp != nil && cp == cache:
+		saveCache(cache)
+	case cp != nil:
+		if err := cp.save(); err != nil {
+			return 0, err
+		}
+	}
+
+	// Save base image for debug purposes
+	if !*dryRun && *debugImages {
+		if err := savePNG("base.debug.png", base); err != nil {
+			return 0, err
+		}
+	}
+
+	// Now, generate G-code
+	margin := 0.0
+	if *mode == stencil.ModeKnife {
+		margin = *knifeOffset
+	}
+	lo, hi := stencilBounds(plan.RegistrationHoles())
+	out, err := newGCodeOutput("", *mode != stencil.ModeDispense, margin, lo, hi, fid, hm)
+	if err != nil {
+		return 0, err
+	}
+	code := resultCode(&st)
+	if plan.Interrupted {
+		slog.Error("Interrupted, writing the partial results", "solved", len(st.Apertures), "components", plan.Components)
+		code = exitInterrupted
+	}
+	if err := plan.Program(out.add); err != nil {
+		return 0, err
+	}
+	if err := out.check(); err != nil {
+		return 0, err
+	}
+	outName := ""
+	if writeGCode {
+		// The header tells the estimate, so the program is checked and measured above,
+		// and written with the header now.
+		outName = *output
+		if plan.Comments, err = jobComments(plan, out.Stats()); err != nil {
+			return 0, err
+		}
+		if out, err = newGCodeOutput(outName, *mode != stencil.ModeDispense, margin, lo, hi, fid, hm); err != nil {
+			return 0, err
+		}
+		if err := plan.Program(out.add); err != nil {
+			return 0, err
+		}
+		if err := out.check(); err != nil {
+			return 0, err
+		}
+	}
+	out.warn()
+
+	paths := plan.Paths
+	cutPathCount, _ := cutPaths(plan)
+	holes := 0
+	if *mode == stencil.ModeDispense {
+		holes = len(res)
+	}
+	if *volumeReport != "" {
+		if err := saveVolumeReport(*volumeReport, &st); err != nil {
+			return 0, err
+		}
+	}
+	if *relReport != "" {
+		if err := saveReleaseReport(*relReport, plan); err != nil {
+			return 0, err
+		}
+	}
+	if *jobReportOut != "" {
+		if err := saveJobReport(*jobReportOut, plan, out.Stats(), out.Lines, holes, paths, outName, code); err != nil {
+			return 0, err
+		}
+	}
+	if *dryRun {
+		printReport(os.Stderr, plan, out.Stats(), out.Lines, holes)
+		return code, printSummary("", out.Lines, out.Stats(), holes, cutPathCount)
 	}
 
 	// Create debug output
-	basePxSize := *pxSize / float64(*n)
-	outImg := image.NewRGBA(base.Bounds())
-	draw.Draw(outImg, base.Bounds(), base, image.Point{0, 0}, draw.Src)
-	clr := color.RGBA{R: 255, A: 255}
-	for _, c := range res {
-		drawCircle(outImg, c.X/basePxSize, c.Y/basePxSize, (*toolDiameter)/2/basePxSize, clr)
-	}
-	mustSavePNG("out.debug.png", outImg)
-
-	// Now, generate drill G-code
-	var gcode []string
-	add := func(code string) { gcode = append(gcode, code) }
-	add("G21; Set units to millimeters")
-	add("G0 Z10")
-	//add("G0 X0 Y0")
-	//add("M3; Turn on spindle")
-	for _, c := range res {
-		add(fmt.Sprintf("G1 X%f Y%f F%f", c.X, float64(y1)*basePxSize-c.Y, *travelRate))
-		add(fmt.Sprintf("G1 Z%f F%f", *millHeight, *millRate))
-		add("M106 S255")
-		add(fmt.Sprintf("G4 P%d", int64(*dispenseTime/time.Millisecond)))
-		add("M107")
-		add(fmt.Sprintf("G1 Z%f F%f", *safeHeight, *travelRate))
-	}
-	//add("M5; Turn off spindle")
-
-	if err := ioutil.WriteFile(*output, []byte(strings.Join(gcode, "\n")), 0644); err != nil {
-		failf("Failed to write result g-code file %q: %v", *output, err)
+	if *debugImages {
+		outImg := image.NewRGBA(base.Bounds())
+		draw.Draw(outImg, base.Bounds(), base, base.Bounds().Min, draw.Src)
+		for i, c := range res {
+			drawCircle(outImg, c.X/basePxSize, c.Y/basePxSize, cutDiameter()/2/basePxSize, circleColor(plan.Strategies[i], i, len(res)))
+		}
+		if err := savePNG("out.debug.png", outImg); err != nil {
+			return 0, err
+		}
+		if err := savePNG("uncovered.debug.png", uncoveredImage(base, res)); err != nil {
+			return 0, err
+		}
+	}
+
+	if writeGCode {
+		if err := out.commit(); err != nil {
+			return 0, err
+		}
+	}
+
+	if *outputSTL != "" {
+		if err := saveSTL(*outputSTL, base); err != nil {
+			return 0, err
+		}
+	}
+	if *outputDXF != "" {
+		if err := saveDXF(*outputDXF, base, plan); err != nil {
+			return 0, err
+		}
+	}
+	if *outputHPGL != "" {
+		if err := saveHPGL(*outputHPGL, base, plan); err != nil {
+			return 0, err
+		}
+	}
+	if *outputCAM != "" {
+		if err := saveCAMotics(*outputCAM); err != nil {
+			return 0, err
+		}
+	}
+	if *outputGerber != "" {
+		if err := saveGerber(*outputGerber, res, base); err != nil {
+			return 0, err
+		}
+	}
+	if *outputPDF != "" {
+		if err := savePDF(*outputPDF, base, res, paths); err != nil {
+			return 0, err
+		}
+	}
+	if *outputSVG != "" {
+		if err := saveSVG(*outputSVG, base, res, paths); err != nil {
+			return 0, err
+		}
+	}
+
+	printReport(os.Stderr, plan, out.Stats(), out.Lines, holes)
+	if err := printSummary(outName, out.Lines, out.Stats(), holes, cutPathCount); err != nil {
+		return 0, err
+	}
+	if cache != nil && cache != cp && !plan.Interrupted {
+		cache.Components = cp.Components
+		saveCache(cache)
+	}
+	if *ckptFile != "" && !plan.Interrupted {
+		cp.remove()
 	}
+	return code, nil
 }
 
-func fillQuad(base *image.Gray, level byte, bbox image.Rectangle, ox, oy float64) []Point {
-	basePxSize := *pxSize / float64(*n)
-	width := float64(base.Bounds().Dx()) * basePxSize
-	height := float64(base.Bounds().Dy()) * basePxSize
-	dx := *toolDiameter
-	dy := *toolDiameter
-	var centers []Point
-	for i := 0; ; i++ {
-		cx := ox + float64(i)*dx
-		if cx >= width {
-			break
-		}
-		if cx < float64(bbox.Min.X-1)*basePxSize || cx >= float64(bbox.Max.X+1)*basePxSize {
-			//fmt.Printf("bbox={%f,%f}-{%f,%f}, cx: %f, skip...\n",
-			//	float64(bbox.Min.X)*basePxSize, float64(bbox.Min.Y)*basePxSize, float64(bbox.Max.X)*basePxSize, float64(bbox.Max.Y)*basePxSize, cx)
-			continue
+// checkFlags sets up the logger, checks the flags and returns the conversion options they set.
+// The --output is only needed if writeGCode is set.
+func checkFlags(writeGCode bool) (stencil.Options, error) {
+	if *verbose {
+		*logLevel = "debug"
+	}
+	if err := setupLogger(*logLevel, *logFormat); err != nil {
+		return stencil.Options{}, err
+	}
+	required := []string{"input", "background", "px_size", "tool_diameter", "mill_rate", "travel_rate"}
+	if writeGCode {
+		required = append(required, "output")
+	}
+	switch *mode {
+	case stencil.ModeDispense:
+		required = append(required, "mill_height", "safe_height")
+		if *material != "" {
+			return stencil.Options{}, errorf(exitBadFlags, "--material needs the %s or the %s mode", stencil.ModeLaser, stencil.ModeKnife)
 		}
-		for j := 0; ; j++ {
-			cy := oy + float64(j)*dy
-			if cy >= height {
-				break
-			}
-			if cy < float64(bbox.Min.Y-1)*basePxSize || cy >= float64(bbox.Max.Y+1)*basePxSize {
-				//fmt.Printf("bbox={%f,%f}-{%f,%f}, cy: %f, skip...\n",
-				//	float64(bbox.Min.X)*basePxSize, float64(bbox.Min.Y)*basePxSize, float64(bbox.Max.X)*basePxSize, float64(bbox.Max.Y)*basePxSize, cy)
-				continue
-			}
-			if checkCircle(base, level, basePxSize, cx, cy, (*toolDiameter)/2) {
-				centers = append(centers, Point{cx, cy})
-			}
+	case stencil.ModeLaser:
+		required = append(required, "hatch_spacing")
+		if *laserCmd != gcode.LaserM3 && *laserCmd != gcode.LaserM106 {
+			return stencil.Options{}, errorf(exitBadFlags, "unknown laser command: %s", *laserCmd)
 		}
+	case stencil.ModeKnife:
+		required = append(required, "mill_height", "safe_height", "knife_offset")
+	default:
+		return stencil.Options{}, errorf(exitBadFlags, "unknown mode: %s", *mode)
+	}
+	switch *zReference {
+	case gcode.ZReferenceTop:
+	case gcode.ZReferenceBottom:
+		required = append(required, "thickness")
+	default:
+		return stencil.Options{}, errorf(exitBadFlags, "unknown Z reference: %s", *zReference)
+	}
+	if *outputSTL != "" || *volumeReport != "" || *relReport != "" {
+		required = append(required, "thickness")
+	}
+	if err := requireFlags(required...); err != nil {
+		return stencil.Options{}, err
 	}
-	return centers
+	if err := expandOutput(); err != nil {
+		return stencil.Options{}, err
+	}
+	// The laser doesn't move Z, the other modes plunge at the mill rate and retract at the travel one.
+	if err := limitFeeds(*mode != stencil.ModeLaser, "travel_rate", "mill_rate"); err != nil {
+		return stencil.Options{}, err
+	}
+	opts, err := convertOptions()
+	if err != nil {
+		return stencil.Options{}, err
+	}
+	if err := opts.Validate(); err != nil {
+		return stencil.Options{}, errorf(exitBadFlags, "invalid flags: %w", err)
+	}
+
+	return opts, nil
 }
 
-func fillTriangle(base *image.Gray, level byte, bbox image.Rectangle, ox, oy float64) []Point {
+// imageSize returns the base image size (in mm).
+func imageSize() (w, h float64) {
 	basePxSize := *pxSize / float64(*n)
-	width := float64(base.Bounds().Dx()) * basePxSize
-	height := float64(base.Bounds().Dy()) * basePxSize
-
-	dy := (*toolDiameter) / 2
-	dx := dy * 1.73205080757 // sqrt(3)
-	var centers []Point
-	for i := 0; ; i++ {
-		cx := ox + float64(i)*dx
-		if cx >= width {
-			break
-		}
-		if cx < float64(bbox.Min.X-1)*basePxSize || cx >= float64(bbox.Max.X+1)*basePxSize {
-			continue
-		}
-		for j := 0; ; j++ {
-			cy := oy + float64(j)*dy
-			if cy >= height {
-				break
-			}
-			if cy < float64(bbox.Min.Y-1)*basePxSize || cy >= float64(bbox.Max.Y+1)*basePxSize {
-				continue
-			}
-			if (i+j)%2 == 1 {
-				continue
-			}
-			if checkCircle(base, level, basePxSize, cx, cy, (*toolDiameter)/2) {
-				centers = append(centers, Point{cx, cy})
-			}
+	return float64(imgW) * basePxSize, float64(imgH) * basePxSize
+}
+
+// frame returns the conversion from the base image space (in mm, Y pointing down)
+// to the machine space (in mm, Y pointing up).
+func frame() geom.Frame {
+	basePxSize := *pxSize / float64(*n)
+	return geom.Frame{Height: float64(imgH) * basePxSize}
+}
+
+// toMachine converts a point from the base image space to the machine space.
+func toMachine(p geom.Point) geom.Point {
+	return frame().ToMachine(p)
+}
+
+// convertOptions returns the conversion options set by the flags.
+func convertOptions() (stencil.Options, error) {
+	bk, err := backgroundColor()
+	if err != nil {
+		return stencil.Options{}, err
+	}
+	holes, err := registrationHoles()
+	if err != nil {
+		return stencil.Options{}, err
+	}
+	var holesTool *gcode.Tool
+	if *regTool != "" {
+		if holesTool, err = registrationTool(); err != nil {
+			return stencil.Options{}, err
 		}
 	}
-	return centers
+	labelPt, err := labelCorner()
+	if err != nil {
+		return stencil.Options{}, err
+	}
+	serialPt, err := serialCorner()
+	if err != nil {
+		return stencil.Options{}, err
+	}
+	return stencil.Options{
+		Background:           bk,
+		Packing:              *packParams(),
+		Mode:                 *mode,
+		Machine:              *gcodeConfig(),
+		KnifeOffset:          *knifeOffset,
+		KnifeAngle:           *knifeAngle,
+		Order:                *order,
+		Jobs:                 *jobs,
+		Comments:             []string{"Generated by " + readBuildInfo().String()},
+		RegistrationHoles:    holes,
+		RegistrationDiameter: *regDiameter,
+		CornerInset:          *cornerInset,
+		Mirror:               *mirror,
+		RegistrationTool:     holesTool,
+		Runout:               *runout,
+		Tool:                 gcode.Tool{Number: 1, Name: *toolName, Diameter: *toolDiameter},
+		Label:                *label,
+		LabelAt:              labelPt,
+		LabelHeight:          *labelHeight,
+		LabelDepth:           *labelDepth,
+		LabelPower:           *labelPower,
+		Serial:               *serial,
+		SerialAt:             serialPt,
+		SerialModule:         *serialModule,
+		SerialDot:            serialDotSize(),
+		EnlargeSmall:         *enlargeSmall,
+		MergeDistance:        *mergeDist,
+		MaxPartial:           *maxPartial,
+		MaxPixels:            *maxPixels,
+		Thickness:            materialThickness(),
+		Breakthrough:         *breakthrough,
+	}, nil
+}
+
+// packParams returns the packing parameters set by the flags.
+func packParams() *packer.Params {
+	return &packer.Params{
+		PxSize:         *pxSize,
+		N:              *n,
+		ToolDiameter:   cutDiameter(),
+		Search:         *search,
+		CoverageTarget: *covTarget,
+		Adaptive:       *adaptiveN,
+		Strategies:     strategyNames(),
+		Connectivity:   stencilimg.Connectivity(*connectivity),
+		EdgeTolerance:  *edgeTol,
+		Samples:        *samples,
+		Tolerance:      *tolerance,
+	}
+}
+
+// strategyNames returns the --strategy names, or nil if it's empty.
+func strategyNames() []string {
+	if *strategy == "" {
+		return nil
+	}
+	return strings.Split(*strategy, ",")
+}
+
+// gcodeConfig returns the machine parameters set by the flags.
+func gcodeConfig() *gcode.Config {
+	return &gcode.Config{
+		Frame:        frame(),
+		TravelRate:   *travelRate,
+		MillRate:     *millRate,
+		MillHeight:   *millHeight,
+		SafeHeight:   *safeHeight,
+		ZOffset:      zOffset(),
+		DispenseTime: *dispenseTime,
+		LaserCmd:     *laserCmd,
+		LaserPower:   *laserPower,
+		Passes:       *passes,
+		HatchSpacing: *hatchSpacing,
+		Dialect:      *dialect,
+		Hooks:        hooks(),
+		// The spindle speeds, the coolant and the tool change commands are checked by Options.Validate.
+		DrillRPM:      *drillRPM,
+		MillRPM:       *millRPM,
+		Coolant:       *coolant,
+		ArcTolerance:  *arcTolerance,
+		BacklashX:     *backlashX,
+		BacklashY:     *backlashY,
+		ToolChangeCmd: *toolChange,
+	}
 }
 
-func mustLoadPNG(name string) image.Image {
-	f, err := os.Open(*input)
+// cutDiameter returns the width the tool cuts: the --tool_diameter with the --runout.
+func cutDiameter() float64 {
+	return *toolDiameter + *runout
+}
+
+// materialThickness returns the --thickness, or 0 if it's not set.
+func materialThickness() float64 {
+	if math.IsNaN(*thickness) {
+		return 0
+	}
+	return *thickness
+}
+
+// zOffset returns the height of the material top above the Z zero of the --z_reference (in mm).
+func zOffset() float64 {
+	if *zReference == gcode.ZReferenceBottom {
+		return *thickness
+	}
+	return 0
+}
+
+// backgroundColor returns the --background color of the input image.
+func backgroundColor() (color.Color, error) {
+	switch *background {
+	case "black":
+		return color.Black, nil
+	case "white":
+		return color.White, nil
+	}
+	return nil, errorf(exitBadFlags, "unknown color: %s", *background)
+}
+
+func loadPNG(name string) (image.Image, error) {
+	f, err := os.Open(name)
 	if err != nil {
-		failf("Failed to open input file %q: %v", *input, err)
+		return nil, errorf(exitBadInput, "failed to open input file: %w", err)
 	}
 	defer f.Close()
-	img, err := png.Decode(f)
+	opts := stencil.Options{Packing: packer.Params{N: *n}, MaxPixels: *maxPixels}
+	img, err := opts.Decode(f)
 	if err != nil {
-		failf("Failed to decode a PNG file %q: %v", *input, err)
+		return nil, errorf(exitBadInput, "failed to decode a PNG file %q: %w", name, err)
 	}
-	return img
+	return img, nil
+}
+
+func savePNG(name string, img image.Image) error {
+	err := writeFile(name, func(w io.Writer) error {
+		return png.Encode(w, img)
+	})
+	if err != nil {
+		return errorf(exitWriteFailed, "failed to save PNG image to %q: %w", name, err)
+	}
+	return nil
+}
+
+// outputFiles returns the names of the files the conversion writes, given if it writes the G-code.
+func outputFiles(writeGCode bool) []string {
+	// The reports are written by the dry run too.
+	names := []string{*volumeReport, *relReport, *jobReportOut}
+	if *dryRun {
+		return names
+	}
+	if writeGCode {
+		names = append(names, *output)
+	}
+	if *debugImages {
+		names = append(names, "base.debug.png", "out.debug.png", "uncovered.debug.png")
+	}
+	return append(names, *outputSTL, *outputDXF, *outputHPGL, *outputCAM, *outputGerber, *outputPDF, *outputSVG)
 }
 
-func mustSavePNG(name string, img image.Image) {
+// checkOverwrite fails if any of the named files exists, unless --force is set. The empty names
+// are skipped.
+func checkOverwrite(names ...string) error {
+	if *force {
+		return nil
+	}
+	var exist []string
+	for _, name := range names {
+		if name == "" {
+			continue
+		}
+		if _, err := os.Stat(name); err == nil {
+			exist = append(exist, name)
+		}
+	}
+	if len(exist) > 0 {
+		return errorf(exitBadFlags, "refusing to overwrite %s; use --force", strings.Join(exist, ", "))
+	}
+	return nil
+}
+
+// writeFile creates or truncates the file and passes it to write, buffered. It fails if any of
+// the writes, or closing the file, fails.
+func writeFile(name string, write func(w io.Writer) error) error {
 	f, err := os.OpenFile(name, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
 	if err != nil {
-		failf("Failed to create file %q for saving a PNG image: %v", name, err)
+		return err
 	}
 	defer f.Close()
-
-	if err := png.Encode(f, img); err != nil {
-		failf("Failed to save PNG image to %q: %v", name, err)
+	w := bufio.NewWriter(f)
+	if err := write(w); err != nil {
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		return err
 	}
+	return f.Close()
 }
 
 func drawCircle(img *image.RGBA, x, y, r float64, c color.Color) {
-	fmt.Printf("drawCircle(x=%f, y=%f, r=%f, c=%v)\n", x, y, r, c)
+	slog.Debug("drawCircle", "x", x, "y", y, "r", r, "c", c)
 	x0 := int(x - r)
 	y0 := int(y - r)
 	x1 := int(x + r)
 	y1 := int(y + r)
 	for cy := y0; cy <= y1; cy++ {
 		for cx := x0; cx <= x1; cx++ {
-			if inside(x, y, r, float64(cx), float64(cy)) {
+			if geom.Inside(x, y, r, float64(cx), float64(cy)) {
 				img.Set(cx, cy, c)
 			}
 		}
 	}
 }
 
-// checkCircle checks that a circle with a center in (x, y) and a radius r fits to the base image and all pixels are high.
-func checkCircle(base *image.Gray, level byte, pxSize, x, y, r float64) bool {
-	width := float64(base.Bounds().Dx()) * pxSize
-	height := float64(base.Bounds().Dy()) * pxSize
-	if x < r || x > width-r || y < r || y > height-r {
-		return false
-	}
-	x0 := int((x - r) / pxSize)
-	y0 := int((y - r) / pxSize)
-	x1 := int((x + r) / pxSize)
-	y1 := int((y + r) / pxSize)
-	for cy := y0; cy <= y1; cy++ {
-		i0 := cy * base.Stride
-		for cx := x0; cx <= x1; cx++ {
-			if !inside(x, y, r, (x-r)+float64(cx-x0)*pxSize, (y-r)+float64(cy-y0)*pxSize) {
-				continue
-			}
-			if base.Pix[i0+cx] != level {
-				// circle hits background
-				//fmt.Printf("checkCircle(pxSize=%f, x=%f, y=%f, r=%f, i0=%d, cx=%d, base.Pix[i0+cx]=%d\n",
-				//	pxSize, x, y, r, i0, cx, base.Pix[i0+cx])
-				return false
-			}
-		}
-	}
-	return true
+// strategyColors are the debug image colors of the circles placed by each strategy.
+var strategyColors = map[string]color.RGBA{
+	packer.StrategyTriangle: {R: 255, A: 255},
+	packer.StrategyQuad:     {B: 255, G: 128, A: 255},
 }
 
-func inside(cx, cy, r, x, y float64) bool {
-	return (x-cx)*(x-cx)+(y-cy)*(y-cy) <= r*r
+// circleColor returns the debug color of the i-th of n circles: the hue tells the strategy,
+// and the brightness fades from the first milled circle to the last one.
+func circleColor(strategy string, i, n int) color.RGBA {
+	c, ok := strategyColors[strategy]
+	if !ok {
+		c = color.RGBA{G: 255, A: 255}
+	}
+	k := 1.0
+	if n > 1 {
+		k = 1 - 0.65*float64(i)/float64(n-1)
+	}
+	return color.RGBA{R: uint8(float64(c.R) * k), G: uint8(float64(c.G) * k), B: uint8(float64(c.B) * k), A: 255}
 }
 
-// floodFill fills 4-connected non-background pixels starting from (x,y) with level.
-func floodFill(base *image.Gray, level byte, x, y int) image.Rectangle {
-	bbox := image.Rect(x, y, x, y)
-	cur := []int{y*base.Stride + x}
-	for len(cur) > 0 {
-		var pix []int
-		try := func(j int) {
-			if base.Pix[j] != 0 && base.Pix[j] != 254 && base.Pix[j] != level {
-				base.Pix[j] = level
-				pix = append(pix, j)
-				x := j % base.Stride
-				y := j / base.Stride
-				if x < bbox.Min.X {
-					bbox.Min.X = x
-				}
-				if x > bbox.Max.X {
-					bbox.Max.X = x
-				}
-				if y < bbox.Min.Y {
-					bbox.Min.Y = y
-				}
-				if y > bbox.Max.Y {
-					bbox.Max.Y = y
+// uncoveredImage highlights the foreground pixels of the base image not covered by any circle
+// in red, while the covered ones are gray.
+func uncoveredImage(base stencilimg.PixelMask, centers []geom.Point) *image.RGBA {
+	basePxSize := *pxSize / float64(*n)
+	w, h := base.Bounds().Dx(), base.Bounds().Dy()
+	cut := packer.CutMask(w, h, basePxSize, centers, cutDiameter()/2)
+	img := image.NewRGBA(base.Bounds())
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			c := color.RGBA{A: 255}
+			if base.Get(x, y) {
+				if cut[y*w+x] {
+					c = color.RGBA{R: 96, G: 96, B: 96, A: 255}
+				} else {
+					c = color.RGBA{R: 255, A: 255}
 				}
 			}
+			img.SetRGBA(x, y, c)
 		}
-		for _, i := range cur {
-			if i%base.Stride != 0 {
-				try(i - 1)
-			}
+	}
+	return img
+}
 
-			if i%base.Stride != base.Stride-1 {
-				try(i + 1)
-			}
-			if i/base.Stride > 0 {
-				try(i - base.Stride)
-			}
-			if i/base.Stride < base.Bounds().Dy()-1 {
-				try(i + base.Stride)
-			}
-		}
-		cur = pix
+// ratio returns a/b, or 0 if b is 0.
+func ratio(a, b int) float64 {
+	if b == 0 {
+		return 0
+	}
+	return float64(a) / float64(b)
+}
+
+func imin(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func imax(a, b int) int {
+	if a > b {
+		return a
 	}
-	return bbox
+	return b
 }
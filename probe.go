@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strings"
+
+	"github.com/krasin/png2stencil/gcode"
+	"github.com/krasin/png2stencil/geom"
+)
+
+// probePoints returns the probing grid over the --input image bounds (in the machine space),
+// fitted to the --fiducials.
+func probePoints() ([]geom.Point, error) {
+	if err := requireFlags("input", "output", "px_size", "safe_height", "travel_rate"); err != nil {
+		return nil, err
+	}
+	if err := expandOutput(); err != nil {
+		return nil, err
+	}
+	if err := limitFeeds(true, "travel_rate", "probe_rate"); err != nil {
+		return nil, err
+	}
+	if !(*probeSpacing > 0) || !(*probeDepth > 0) || !(*probeRate > 0) {
+		return nil, errorf(exitBadFlags, "--probe_spacing, --probe_depth and --probe_rate must be positive")
+	}
+	in, err := loadPNG(*input)
+	if err != nil {
+		return nil, err
+	}
+	imgW, imgH = in.Bounds().Dx()*(*n), in.Bounds().Dy()*(*n)
+	w, h := imageSize()
+	pts := gcode.ProbeGrid(toMachine(geom.Pt(0, 0)), toMachine(geom.Pt(w, h)), *probeSpacing)
+	// The height map is in the machine space, so it's probed where the --fiducials put the stencil.
+	fid, err := loadFiducials()
+	if err != nil {
+		return nil, err
+	}
+	if fid != nil {
+		for i, p := range pts {
+			pts[i] = fid.Apply(p)
+		}
+	}
+	return pts, nil
+}
+
+// runProbe implements the probe subcommand: it writes the program probing the surface on a grid
+// over the --input bounds to --output. Zero Z just above the surface before running it, and pass
+// its log to the heightmap subcommand with the same flags.
+func runProbe(args []string) error {
+	if err := parseFlags(args); err != nil {
+		return err
+	}
+	if err := setupLogger(*logLevel, *logFormat); err != nil {
+		return err
+	}
+	pts, err := probePoints()
+	if err != nil {
+		return err
+	}
+	if err := checkOverwrite(*output); err != nil {
+		return err
+	}
+	c := &gcode.Config{
+		TravelRate: *travelRate,
+		SafeHeight: *safeHeight,
+		ZOffset:    zOffset(),
+		ProbeRate:  *probeRate,
+		BacklashX:  *backlashX,
+		BacklashY:  *backlashY,
+		Dialect:    *dialect,
+	}
+	err = writeFile(*output, func(w io.Writer) error {
+		var lines []string
+		e, err := gcode.NewEmitter(func(code string) { lines = append(lines, code) }, c)
+		if err != nil {
+			return err
+		}
+		e.Comment("Generated by " + readBuildInfo().String())
+		e.Comment(fmt.Sprintf("Probing %d points every %g mm at most", len(pts), *probeSpacing))
+		e.Header()
+		gcode.Probe(e, c, pts, *probeDepth)
+		e.Footer()
+		_, err = io.WriteString(w, strings.Join(lines, "\n"))
+		return err
+	})
+	if err != nil {
+		return errorf(exitWriteFailed, "failed to write probing program %q: %w", *output, err)
+	}
+	fmt.Printf("%s: %d points\n", *output, len(pts))
+	return nil
+}
+
+// runHeightmap implements the heightmap subcommand: it reads the log of the probing program
+// made by the probe subcommand with the same flags, and writes the height map for --height_map
+// to --output. The probes are matched to the grid points by their order, so it doesn't matter
+// if the log has the work or the machine coordinates; the heights are relative to the first probe.
+func runHeightmap(args []string) error {
+	if err := parseFlags(args); err != nil {
+		return err
+	}
+	if err := setupLogger(*logLevel, *logFormat); err != nil {
+		return err
+	}
+	if flag.NArg() != 1 {
+		return errorf(exitBadFlags, "heightmap needs the probing log")
+	}
+	pts, err := probePoints()
+	if err != nil {
+		return err
+	}
+	if err := checkOverwrite(*output); err != nil {
+		return err
+	}
+	f, err := os.Open(flag.Arg(0))
+	if err != nil {
+		return errorf(exitBadInput, "failed to open probing log: %w", err)
+	}
+	defer f.Close()
+	probes, err := gcode.ParseProbeLog(f)
+	if err != nil {
+		return errorf(exitBadInput, "failed to parse probing log %q: %w", flag.Arg(0), err)
+	}
+	if len(probes) != len(pts) {
+		return errorf(exitBadInput, "probing log %q has %d probes, but the grid has %d points; are the flags the same as for probe?",
+			flag.Arg(0), len(probes), len(pts))
+	}
+	lo, hi := math.Inf(1), math.Inf(-1)
+	err = writeFile(*output, func(out io.Writer) error {
+		w := bufio.NewWriter(out)
+		fmt.Fprintln(w, "x,y,z")
+		for i, p := range pts {
+			z := probes[i].Z - probes[0].Z
+			lo, hi = math.Min(lo, z), math.Max(hi, z)
+			fmt.Fprintf(w, "%f,%f,%f\n", p.X, p.Y, z)
+		}
+		return w.Flush()
+	})
+	if err != nil {
+		return errorf(exitWriteFailed, "failed to write height map %q: %w", *output, err)
+	}
+	fmt.Printf("%s: %d points, heights from %.3f to %.3f mm\n", *output, len(pts), lo, hi)
+	return nil
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	_ "net/http/pprof"
+	"os"
+	"runtime"
+	"runtime/pprof"
+)
+
+// startProfiling starts the profiles requested by the flags. The CPU profile is stopped
+// and the heap profile is written at exit.
+func startProfiling() error {
+	if *cpuProfile != "" {
+		f, err := os.Create(*cpuProfile)
+		if err != nil {
+			return errorf(exitWriteFailed, "failed to create CPU profile: %w", err)
+		}
+		if err := pprof.StartCPUProfile(f); err != nil {
+			f.Close()
+			return fmt.Errorf("failed to start CPU profile: %w", err)
+		}
+		atExit = append(atExit, func() {
+			pprof.StopCPUProfile()
+			f.Close()
+		})
+	}
+	if *memProfile != "" {
+		atExit = append(atExit, func() {
+			f, err := os.Create(*memProfile)
+			if err != nil {
+				slog.Error("Failed to create memory profile", "file", *memProfile, "err", err)
+				return
+			}
+			defer f.Close()
+			runtime.GC()
+			if err := pprof.WriteHeapProfile(f); err != nil {
+				slog.Error("Failed to write memory profile", "file", *memProfile, "err", err)
+			}
+		})
+	}
+	if *pprofAddr != "" {
+		go func() {
+			// The handlers are registered on the default mux by net/http/pprof.
+			if err := http.ListenAndServe(*pprofAddr, nil); err != nil {
+				slog.Error("pprof server failed", "addr", *pprofAddr, "err", err)
+			}
+		}()
+		slog.Info("Serving pprof", "url", "http://"+*pprofAddr+"/debug/pprof/")
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+)
+
+// progress reports the share of processed components and the estimated time left to stderr.
+// It's shown only at the info log level and below, and never with JSON logs.
+type progress struct {
+	total int
+	done  int
+	start time.Time
+	last  time.Time
+}
+
+// progressInterval limits how often the progress line is redrawn.
+const progressInterval = 200 * time.Millisecond
+
+func newProgress() *progress {
+	return &progress{start: time.Now()}
+}
+
+// update reports that done of total components are processed.
+func (p *progress) update(done, total int) {
+	p.done, p.total = done, total
+	if jsonLogs || !logEnabled(slog.LevelInfo) {
+		return
+	}
+	now := time.Now()
+	if p.done < p.total && now.Sub(p.last) < progressInterval {
+		return
+	}
+	p.last = now
+	elapsed := now.Sub(p.start)
+	eta := time.Duration(float64(elapsed) / float64(p.done) * float64(p.total-p.done))
+	fmt.Fprintf(os.Stderr, "\rProcessed %d/%d components (%.0f%%), elapsed %v, ETA %v   ",
+		p.done, p.total, 100*float64(p.done)/float64(p.total), elapsed.Round(time.Second), eta.Round(time.Second))
+	if p.done == p.total {
+		fmt.Fprintln(os.Stderr)
+	}
+}
+
+// stop ends the progress line when the processing is stopped early.
+func (p *progress) stop() {
+	if p.done < p.total && !p.last.IsZero() {
+		fmt.Fprintln(os.Stderr)
+	}
+}
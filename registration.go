@@ -0,0 +1,129 @@
+package main
+
+import (
+	"math"
+	"strings"
+
+	"github.com/krasin/png2stencil/gcode"
+	"github.com/krasin/png2stencil/geom"
+	"github.com/krasin/png2stencil/stencil"
+)
+
+// registrationHoles returns the --registration_holes centers.
+func registrationHoles() ([]geom.Point, error) {
+	if *regHoles == "" {
+		return nil, nil
+	}
+	var pts []geom.Point
+	for _, s := range strings.Split(*regHoles, ";") {
+		p, err := parsePoint(s)
+		if err != nil {
+			return nil, errorf(exitBadFlags, "bad --registration_holes: %w", err)
+		}
+		pts = append(pts, p)
+	}
+	return pts, nil
+}
+
+// registrationTool returns the --registration_tool from the --tools library, as the tool 2.
+func registrationTool() (*gcode.Tool, error) {
+	if *toolsFile == "" {
+		return nil, errorf(exitBadFlags, "--registration_tool needs the --tools library")
+	}
+	tools, err := loadTools(*toolsFile)
+	if err != nil {
+		return nil, err
+	}
+	t, ok := tools[*regTool]
+	if !ok {
+		return nil, errorf(exitBadFlags, "unknown tool %s in %s", *regTool, *toolsFile)
+	}
+	return &gcode.Tool{Number: 2, Name: t.Name, Diameter: t.Diameter}, nil
+}
+
+// labelCorner returns the --label_at corner, which a --label needs.
+func labelCorner() (geom.Point, error) {
+	if *label == "" {
+		return geom.Point{}, nil
+	}
+	if *labelAt == "" {
+		return geom.Point{}, errorf(exitBadFlags, "--label needs --label_at")
+	}
+	p, err := parsePoint(*labelAt)
+	if err != nil {
+		return geom.Point{}, errorf(exitBadFlags, "bad --label_at: %w", err)
+	}
+	return p, nil
+}
+
+// labelBounds returns the rectangle with the corners lo and hi extended to include the --label.
+func labelBounds(lo, hi geom.Point) (geom.Point, geom.Point) {
+	// The flags are checked by convertOptions already.
+	at, err := labelCorner()
+	if *label == "" || err != nil {
+		return lo, hi
+	}
+	for _, path := range gcode.TextPaths(*label, at, *labelHeight) {
+		for _, p := range path {
+			lo = geom.Pt(math.Min(lo.X, p.X), math.Min(lo.Y, p.Y))
+			hi = geom.Pt(math.Max(hi.X, p.X), math.Max(hi.Y, p.Y))
+		}
+	}
+	return lo, hi
+}
+
+// serialCorner returns the --serial_at corner, which a --serial needs.
+func serialCorner() (geom.Point, error) {
+	if *serial == "" {
+		return geom.Point{}, nil
+	}
+	if *serialAt == "" {
+		return geom.Point{}, errorf(exitBadFlags, "--serial needs --serial_at")
+	}
+	p, err := parsePoint(*serialAt)
+	if err != nil {
+		return geom.Point{}, errorf(exitBadFlags, "bad --serial_at: %w", err)
+	}
+	return p, nil
+}
+
+// serialDotSize returns the --serial_dot, or its default for the --serial_module.
+func serialDotSize() float64 {
+	if math.IsNaN(*serialDot) {
+		return 0.6 * *serialModule
+	}
+	return *serialDot
+}
+
+// serialBounds returns the rectangle with the corners lo and hi extended to include the --serial code.
+func serialBounds(lo, hi geom.Point) (geom.Point, geom.Point) {
+	// The flags are checked by convertOptions already.
+	at, err := serialCorner()
+	if *serial == "" || err != nil {
+		return lo, hi
+	}
+	dots, err := stencil.SerialDots(*serial, at, *serialModule)
+	if err != nil {
+		return lo, hi
+	}
+	return holesBounds(lo, hi, dots, serialDotSize())
+}
+
+// holesBounds returns the rectangle with the corners a and b extended to include the registration holes.
+func holesBounds(a, b geom.Point, holes []geom.Point, diameter float64) (geom.Point, geom.Point) {
+	lo := geom.Pt(math.Min(a.X, b.X), math.Min(a.Y, b.Y))
+	hi := geom.Pt(math.Max(a.X, b.X), math.Max(a.Y, b.Y))
+	r := diameter / 2
+	for _, p := range holes {
+		lo = geom.Pt(math.Min(lo.X, p.X-r), math.Min(lo.Y, p.Y-r))
+		hi = geom.Pt(math.Max(hi.X, p.X+r), math.Max(hi.Y, p.Y+r))
+	}
+	return lo, hi
+}
+
+// stencilBounds returns the bounds of the base image in the machine space extended to include
+// the registration holes, the --label and the --serial code.
+func stencilBounds(holes []geom.Point) (geom.Point, geom.Point) {
+	w, h := imageSize()
+	return serialBounds(labelBounds(holesBounds(toMachine(geom.Pt(0, 0)), toMachine(geom.Pt(w, h)), holes, *regDiameter)))
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"html"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"github.com/krasin/png2stencil/gcode"
+	"github.com/krasin/png2stencil/geom"
+	"github.com/krasin/png2stencil/stencil"
+)
+
+// jobReport is what the --report tells about a run.
+type jobReport struct {
+	Title     string
+	Generator string
+	// Input is the --input with its SHA-256, and Output the G-code file, if written.
+	Input, InputSHA256 string
+	Output             string
+	// Result is the exit code of the run with its meaning.
+	Result string
+	Stats  []statRow
+	// Preview is the SVG preview, as written by --output_svg.
+	Preview []byte
+	// Skipped and Enlarged are the apertures with no circles and the ones plunged once in the
+	// dispense mode, each as its ID, position (in mm) and area (in mm²).
+	Skipped, Enlarged [][]string
+	Warnings          []string
+	// Flags are the flags set, with their values.
+	Flags [][]string
+}
+
+// isHTML tells if the report is written as HTML, rather than Markdown, by its extension.
+func isHTML(name string) bool {
+	ext := strings.ToLower(filepath.Ext(name))
+	return ext == ".html" || ext == ".htm"
+}
+
+// newJobReport collects the report of a run which wrote the outName G-code (empty if none)
+// and exits with the code.
+func newJobReport(plan *stencil.Plan, est gcode.Stats, lines, holes int, paths [][]geom.Point, outName string, code int) (*jobReport, error) {
+	sum, err := fileSHA256(*input)
+	if err != nil {
+		return nil, err
+	}
+	r := &jobReport{
+		Title:       "Stencil job: " + filepath.Base(*input),
+		Generator:   readBuildInfo().String(),
+		Input:       *input,
+		InputSHA256: sum,
+		Output:      outName,
+		Result:      fmt.Sprint(code),
+		Stats:       jobStats(plan, est, lines, holes),
+		Warnings:    loggedWarnings(),
+	}
+	for _, e := range exitCodeDocs {
+		if e.code == code {
+			r.Result += ", " + e.doc
+		}
+	}
+	var buf bytes.Buffer
+	if err := writePreviewSVG(&buf, plan.Base, plan.PxSize, plan.Centers, cutDiameter()/2, paths); err != nil {
+		return nil, err
+	}
+	r.Preview = buf.Bytes()
+	// The positions are in the base image space, as in printReport.
+	apertures := func(list []stencil.Aperture) [][]string {
+		var res [][]string
+		for _, a := range list {
+			res = append(res, []string{fmt.Sprint(a.ID), fmt.Sprintf("%.3f", float64(a.X)*plan.PxSize), fmt.Sprintf("%.3f", float64(a.Y)*plan.PxSize),
+				fmt.Sprintf("%.3f", float64(a.Area)*plan.PxSize*plan.PxSize)})
+		}
+		return res
+	}
+	if *mode == stencil.ModeDispense {
+		r.Skipped = apertures(plan.Stats.Skipped())
+		r.Enlarged = apertures(plan.Stats.Enlarged())
+	}
+	flag.Visit(func(f *flag.Flag) {
+		r.Flags = append(r.Flags, []string{"--" + f.Name, f.Value.String()})
+	})
+	return r, nil
+}
+
+// summary returns the rows of the summary table: the input, the output, the result and the statistics.
+func (r *jobReport) summary() [][]string {
+	rows := [][]string{{"Input", r.Input}, {"Input SHA-256", r.InputSHA256}}
+	if r.Output != "" {
+		rows = append(rows, []string{"G-code", r.Output})
+	}
+	rows = append(rows, []string{"Result", r.Result})
+	for _, s := range r.Stats {
+		rows = append(rows, []string{s.Name, s.Value})
+	}
+	return rows
+}
+
+// apertureHeader is the header of the aperture tables of the report.
+var apertureHeader = []string{"Aperture", "X, mm", "Y, mm", "Area, mm²"}
+
+// writeMarkdown writes the report as Markdown, with the preview as an inline image.
+func (r *jobReport) writeMarkdown(out io.Writer) error {
+	w := bufio.NewWriter(out)
+	// The table cells can't have the pipes or the newlines.
+	cell := strings.NewReplacer("|", "\\|", "\n", " ").Replace
+	table := func(header []string, rows [][]string) {
+		fmt.Fprintf(w, "| %s |\n|", strings.Join(header, " | "))
+		for range header {
+			w.WriteString("---|")
+		}
+		w.WriteString("\n")
+		for _, row := range rows {
+			cells := make([]string, len(row))
+			for i, c := range row {
+				cells[i] = cell(c)
+			}
+			fmt.Fprintf(w, "| %s |\n", strings.Join(cells, " | "))
+		}
+		w.WriteString("\n")
+	}
+
+	fmt.Fprintf(w, "# %s\n\n", r.Title)
+	fmt.Fprintf(w, "Generated by %s.\n\n", r.Generator)
+	table([]string{"", ""}, r.summary())
+
+	fmt.Fprintf(w, "## Preview\n\nThe apertures are gray, the circles red and the toolpath blue.\n\n")
+	fmt.Fprintf(w, "![Preview](data:image/svg+xml;base64,%s)\n\n", base64.StdEncoding.EncodeToString(r.Preview))
+
+	fmt.Fprintf(w, "## Warnings\n\n")
+	if len(r.Warnings) == 0 {
+		w.WriteString("None.\n\n")
+	}
+	for _, s := range r.Warnings {
+		fmt.Fprintf(w, "- %s\n", s)
+	}
+	if len(r.Warnings) > 0 {
+		w.WriteString("\n")
+	}
+	if len(r.Skipped) > 0 {
+		fmt.Fprintf(w, "### Apertures with no circles\n\n")
+		table(apertureHeader, r.Skipped)
+	}
+	if len(r.Enlarged) > 0 {
+		fmt.Fprintf(w, "### Apertures plunged once, oversized to the tool\n\n")
+		table(apertureHeader, r.Enlarged)
+	}
+
+	fmt.Fprintf(w, "## Parameters\n\n")
+	table([]string{"Flag", "Value"}, r.Flags)
+	return w.Flush()
+}
+
+// writeHTML writes the report as a standalone HTML page, with the preview inline.
+func (r *jobReport) writeHTML(out io.Writer) error {
+	w := bufio.NewWriter(out)
+	esc := html.EscapeString
+	table := func(header []string, rows [][]string) {
+		w.WriteString("<table>\n<tr>")
+		for _, h := range header {
+			fmt.Fprintf(w, "<th>%s</th>", esc(h))
+		}
+		w.WriteString("</tr>\n")
+		for _, row := range rows {
+			w.WriteString("<tr>")
+			for _, c := range row {
+				fmt.Fprintf(w, "<td>%s</td>", esc(c))
+			}
+			w.WriteString("</tr>\n")
+		}
+		w.WriteString("</table>\n")
+	}
+
+	fmt.Fprintf(w, "<!DOCTYPE html>\n<html lang=\"en\">\n<head>\n<meta charset=\"utf-8\">\n<title>%s</title>\n", esc(r.Title))
+	w.WriteString("<style>\nbody { font-family: sans-serif; margin: 1em 2em; }\n" +
+		"table { border-collapse: collapse; margin: 1em 0; }\nth, td { border: 1px solid #ccc; padding: 0.2em 0.6em; text-align: left; }\n" +
+		"#preview svg { max-width: 100%; height: auto; border: 1px solid #ccc; }\n</style>\n</head>\n<body>\n")
+	fmt.Fprintf(w, "<h1>%s</h1>\n<p>Generated by %s.</p>\n", esc(r.Title), esc(r.Generator))
+	table([]string{"", ""}, r.summary())
+
+	w.WriteString("<h2>Preview</h2>\n<p>The apertures are gray, the circles red and the toolpath blue.</p>\n<div id=\"preview\">\n")
+	w.Write(r.Preview)
+	w.WriteString("</div>\n")
+
+	w.WriteString("<h2>Warnings</h2>\n")
+	if len(r.Warnings) == 0 {
+		w.WriteString("<p>None.</p>\n")
+	} else {
+		w.WriteString("<ul>\n")
+		for _, s := range r.Warnings {
+			fmt.Fprintf(w, "<li>%s</li>\n", esc(s))
+		}
+		w.WriteString("</ul>\n")
+	}
+	if len(r.Skipped) > 0 {
+		w.WriteString("<h3>Apertures with no circles</h3>\n")
+		table(apertureHeader, r.Skipped)
+	}
+	if len(r.Enlarged) > 0 {
+		w.WriteString("<h3>Apertures plunged once, oversized to the tool</h3>\n")
+		table(apertureHeader, r.Enlarged)
+	}
+
+	w.WriteString("<h2>Parameters</h2>\n")
+	table([]string{"Flag", "Value"}, r.Flags)
+	w.WriteString("</body>\n</html>\n")
+	return w.Flush()
+}
+
+// saveJobReport writes the --report of a run, see newJobReport.
+func saveJobReport(name string, plan *stencil.Plan, est gcode.Stats, lines, holes int, paths [][]geom.Point, outName string, code int) error {
+	r, err := newJobReport(plan, est, lines, holes, paths, outName, code)
+	if err != nil {
+		return err
+	}
+	err = writeFile(name, func(w io.Writer) error {
+		if isHTML(name) {
+			return r.writeHTML(w)
+		}
+		return r.writeMarkdown(w)
+	})
+	if err != nil {
+		return errorf(exitWriteFailed, "failed to save job report %q: %w", name, err)
+	}
+	return nil
+}
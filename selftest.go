@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	_ "embed"
+	"fmt"
+	"image/color"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/krasin/png2stencil/gcode"
+	"github.com/krasin/png2stencil/geom"
+	"github.com/krasin/png2stencil/stencil"
+)
+
+// selftestPNG is the sample board of the selftest subcommand: a QFP-64 with the thermal pad,
+// 0805 and 1206 passives and a SOT-23, white on black at 0.05 mm per pixel.
+//
+//go:embed selftest.png
+var selftestPNG []byte
+
+// selftestMinCoverage is the least coverage a working build gets on the sample board, with
+// the 0.25 mm tool at 2 subpixels; the packing is deterministic, so any drop is a bug.
+const selftestMinCoverage = 0.7
+
+// runSelftest implements the selftest subcommand: it converts the embedded sample board in every
+// mode and dialect, checks the coverage and the G-code invariants, and prints the timings.
+// It fails with exitVerifyFailed if any check does.
+func runSelftest(args []string) error {
+	if err := parseFlags(args); err != nil {
+		return err
+	}
+	if err := setupLogger(*logLevel, *logFormat); err != nil {
+		return err
+	}
+	img, err := stencil.Decode(bytes.NewReader(selftestPNG))
+	if err != nil {
+		return fmt.Errorf("failed to decode the sample board: %w", err)
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "mode\tdialect\tapertures\tcircles\tskipped\tcoverage\tlines\tconvert\tprogram\tresult")
+	failed := 0
+	for _, mode := range []string{stencil.ModeDispense, stencil.ModeLaser, stencil.ModeKnife} {
+		for _, dialect := range gcode.DialectNames() {
+			opts := stencil.NewOptions(
+				stencil.WithBackground(color.Black),
+				stencil.WithPixelSize(0.05),
+				stencil.WithSubpixels(2),
+				stencil.WithTool(0.25),
+				stencil.WithMode(mode),
+				stencil.WithDialect(dialect),
+				stencil.WithHeights(0.1, 1),
+				stencil.WithRates(300, 1000),
+				stencil.WithLaser(255, 1, 0.1),
+				stencil.WithKnife(0.25, 10),
+				stencil.WithJobs(*jobs))
+			start := time.Now()
+			plan, err := stencil.Convert(context.Background(), img, opts)
+			if err != nil {
+				return fmt.Errorf("failed to convert the sample board: %w", err)
+			}
+			converted := time.Since(start)
+
+			w, h := float64(plan.Base.Bounds().Dx())*plan.PxSize, float64(plan.Base.Bounds().Dy())*plan.PxSize
+			a, b := plan.Frame.ToMachine(geom.Pt(0, 0)), plan.Frame.ToMachine(geom.Pt(w, h))
+			cfg := opts.Machine
+			v := gcode.NewVerifier(&cfg, a, b, opts.KnifeOffset, mode != stencil.ModeDispense, mode != stencil.ModeLaser)
+			v.DwellSeconds = dialect == gcode.DialectGRBL
+			lines := 0
+			start = time.Now()
+			if err := plan.Program(func(code string) { v.Feed(code); lines++ }); err != nil {
+				return err
+			}
+			programmed := time.Since(start)
+
+			st := &plan.Stats
+			var problems []string
+			if st.Coverage() < selftestMinCoverage {
+				problems = append(problems, fmt.Sprintf("coverage below %.0f%%", 100*selftestMinCoverage))
+			}
+			if len(st.Skipped()) > 0 {
+				problems = append(problems, "skipped apertures")
+			}
+			for _, vi := range v.Violations {
+				problems = append(problems, vi.String())
+			}
+			result := "ok"
+			if len(problems) > 0 {
+				failed++
+				result = fmt.Sprintf("FAILED: %s", problems[0])
+				if len(problems) > 1 {
+					result += fmt.Sprintf(" and %d more", len(problems)-1)
+				}
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%d\t%d\t%d\t%.1f%%\t%d\t%v\t%v\t%s\n", mode, dialect, len(st.Apertures), st.Circles(),
+				len(st.Skipped()), 100*st.Coverage(), lines, converted.Round(time.Millisecond), programmed.Round(time.Millisecond), result)
+		}
+	}
+	tw.Flush()
+	fmt.Println(readBuildInfo())
+	if failed > 0 {
+		return errorf(exitVerifyFailed, "%d self-test runs failed", failed)
+	}
+	return nil
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// GRBL serial protocol limits and real-time commands.
+const (
+	// grblRxBuffer is the size of the GRBL serial receive buffer. The sender counts the characters
+	// of the lines not acknowledged yet, so the buffer never overflows.
+	grblRxBuffer = 128
+	// grblMaxLine is the longest line GRBL accepts, with the newline.
+	grblMaxLine = 80
+
+	grblStatus = '?'
+	grblHold   = '!'
+	grblResume = '~'
+	grblReset  = 0x18
+)
+
+// grblTimeout is how long the sender waits for the controller to respond.
+const grblTimeout = 10 * time.Second
+
+// grblStatusInterval is how often the sender polls the controller status.
+const grblStatusInterval = time.Second
+
+// loadProgram reads the G-code program, without the comments and the empty lines, which
+// would only take the GRBL buffer.
+func loadProgram(name string) ([]string, error) {
+	var r io.Reader = os.Stdin
+	if name != "-" {
+		f, err := os.Open(name)
+		if err != nil {
+			return nil, errorf(exitBadInput, "failed to open the program: %w", err)
+		}
+		defer f.Close()
+		r = f
+	}
+	var prog []string
+	sc := bufio.NewScanner(r)
+	for n := 1; sc.Scan(); n++ {
+		s := sc.Text()
+		if i := strings.IndexByte(s, ';'); i >= 0 {
+			s = s[:i]
+		}
+		for {
+			i := strings.IndexByte(s, '(')
+			j := strings.IndexByte(s, ')')
+			if i < 0 || j < i {
+				break
+			}
+			s = s[:i] + s[j+1:]
+		}
+		if s = strings.TrimSpace(s); s == "" {
+			continue
+		}
+		if len(s)+1 > grblMaxLine {
+			return nil, errorf(exitBadInput, "line %d of %s is longer than GRBL accepts: %s", n, name, s)
+		}
+		prog = append(prog, s)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, errorf(exitBadInput, "failed to read the program: %w", err)
+	}
+	return prog, nil
+}
+
+// grblSender streams a program to a GRBL controller.
+type grblSender struct {
+	port io.Writer
+	// resp are the lines the controller sends; closed when the port fails.
+	resp <-chan string
+	// pending are the lengths of the lines sent, but not acknowledged yet, and buffered is their sum.
+	pending  []int
+	buffered int
+	held     bool
+}
+
+func newGRBLSender(port io.ReadWriter) *grblSender {
+	resp := make(chan string, 16)
+	go func() {
+		sc := bufio.NewScanner(port)
+		for sc.Scan() {
+			if s := strings.TrimSpace(sc.Text()); s != "" {
+				resp <- s
+			}
+		}
+		close(resp)
+	}()
+	return &grblSender{port: port, resp: resp}
+}
+
+// realtime sends the real-time command, which GRBL handles at once, bypassing the buffer.
+func (s *grblSender) realtime(c byte) error {
+	_, err := s.port.Write([]byte{c})
+	return err
+}
+
+// wake waits for the welcome banner of the controller, resetting it if there's none.
+func (s *grblSender) wake() error {
+	// Opening the port resets most boards, which then print the banner.
+	for attempt := 0; attempt < 2; attempt++ {
+		timeout := time.After(grblTimeout)
+		for {
+			select {
+			case r, ok := <-s.resp:
+				if !ok {
+					return errorf(exitSendFailed, "the port closed")
+				}
+				if strings.HasPrefix(r, "Grbl ") {
+					slog.Info("Connected", "controller", r)
+					return nil
+				}
+				continue
+			case <-timeout:
+			}
+			break
+		}
+		if err := s.realtime(grblReset); err != nil {
+			return errorf(exitSendFailed, "failed to reset the controller: %w", err)
+		}
+	}
+	return errorf(exitSendFailed, "no GRBL banner in %v after a reset", grblTimeout)
+}
+
+// stream sends the program and waits until the controller is done with it. SIGUSR1 toggles
+// the feed hold; the interrupt holds the feed and resets the controller.
+func (s *grblSender) stream(prog []string) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	toggle := make(chan os.Signal, 1)
+	if len(holdSignals) > 0 {
+		signal.Notify(toggle, holdSignals...)
+	}
+	defer signal.Stop(toggle)
+	ticker := time.NewTicker(grblStatusInterval)
+	defer ticker.Stop()
+
+	next, acked := 0, 0
+	idle := false
+	last := time.Now()
+	for acked < len(prog) || !idle {
+		for next < len(prog) && s.buffered+len(prog[next])+1 <= grblRxBuffer {
+			if _, err := io.WriteString(s.port, prog[next]+"\n"); err != nil {
+				return errorf(exitSendFailed, "failed to send line %d: %w", next+1, err)
+			}
+			s.pending = append(s.pending, len(prog[next])+1)
+			s.buffered += len(prog[next]) + 1
+			next++
+		}
+		select {
+		case r, ok := <-s.resp:
+			if !ok {
+				return errorf(exitSendFailed, "the port closed at line %d of %d", acked+1, len(prog))
+			}
+			last = time.Now()
+			switch {
+			case r == "ok" || strings.HasPrefix(r, "error:"):
+				if len(s.pending) == 0 {
+					return errorf(exitSendFailed, "unexpected response: %s", r)
+				}
+				s.buffered -= s.pending[0]
+				s.pending = s.pending[1:]
+				acked++
+				if r != "ok" {
+					s.realtime(grblHold)
+					return errorf(exitSendFailed, "the controller rejected line %d: %s: %s", acked, prog[acked-1], r)
+				}
+			case strings.HasPrefix(r, "ALARM:"):
+				return errorf(exitSendFailed, "the controller raised %s at line %d", r, acked)
+			case strings.HasPrefix(r, "<"):
+				state, pos := grblStatusFields(r)
+				idle = state == "Idle" && acked == len(prog)
+				fmt.Fprintf(os.Stderr, "\r%-6s %d/%d lines  %-32s", state, acked, len(prog), pos)
+			default:
+				slog.Debug("Controller message", "msg", r)
+			}
+		case <-ticker.C:
+			if time.Since(last) > grblTimeout {
+				return errorf(exitSendFailed, "the controller stopped responding at line %d of %d", acked+1, len(prog))
+			}
+			if err := s.realtime(grblStatus); err != nil {
+				return errorf(exitSendFailed, "failed to poll the status: %w", err)
+			}
+		case <-toggle:
+			c := byte(grblHold)
+			if s.held {
+				c = grblResume
+			}
+			if err := s.realtime(c); err != nil {
+				return errorf(exitSendFailed, "failed to hold or resume: %w", err)
+			}
+			s.held = !s.held
+		case <-ctx.Done():
+			s.realtime(grblHold)
+			// Let the machine decelerate, so the reset doesn't lose the position.
+			time.Sleep(500 * time.Millisecond)
+			s.realtime(grblReset)
+			fmt.Fprintln(os.Stderr)
+			return errorf(exitInterrupted, "interrupted at line %d of %d", acked+1, len(prog))
+		}
+	}
+	fmt.Fprintln(os.Stderr)
+	return nil
+}
+
+// grblStatusFields returns the machine state and the position of a status report,
+// like <Run|MPos:1.000,2.000,0.000|FS:500,0>.
+func grblStatusFields(r string) (state, pos string) {
+	fields := strings.Split(strings.Trim(r, "<>"), "|")
+	state = fields[0]
+	for _, f := range fields[1:] {
+		if strings.HasPrefix(f, "MPos:") || strings.HasPrefix(f, "WPos:") {
+			pos = f
+		}
+	}
+	return state, pos
+}
+
+// runSend implements the send subcommand: it streams the G-code program to the GRBL controller
+// on --port, and waits until the machine is done. The comments are dropped, as they would only
+// take the GRBL buffer. Send SIGUSR1 to hold the feed, and again to resume; the interrupt holds the feed
+// and resets the controller.
+func runSend(args []string) error {
+	if err := parseFlags(args); err != nil {
+		return err
+	}
+	if err := setupLogger(*logLevel, *logFormat); err != nil {
+		return err
+	}
+	if flag.NArg() != 1 {
+		return errorf(exitBadFlags, "send needs the program: a G-code file, or - for stdin")
+	}
+	if *port == "" {
+		return errorf(exitBadFlags, "--port is not set")
+	}
+	prog, err := loadProgram(flag.Arg(0))
+	if err != nil {
+		return err
+	}
+	f, err := openSerial(*port, *baud)
+	if err != nil {
+		return errorf(exitSendFailed, "failed to open %s: %w", *port, err)
+	}
+	defer f.Close()
+	s := newGRBLSender(f)
+	if err := s.wake(); err != nil {
+		return err
+	}
+	start := time.Now()
+	if err := s.stream(prog); err != nil {
+		return err
+	}
+	slog.Info("Sent the program", "lines", len(prog), "elapsed", time.Since(start).Round(time.Second))
+	return nil
+}
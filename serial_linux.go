@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// The termios constants package syscall doesn't define: the mask of the speed bits
+// of Termios.Cflag, and the request flushing the port.
+const (
+	termiosCBAUD = 0o10017
+	termiosFlush = 0x540B
+)
+
+// holdSignals toggle the feed hold of the send subcommand.
+var holdSignals = []os.Signal{syscall.SIGUSR1}
+
+// serialSpeeds are the supported baud rates.
+var serialSpeeds = map[int]uint32{
+	9600:   syscall.B9600,
+	19200:  syscall.B19200,
+	38400:  syscall.B38400,
+	57600:  syscall.B57600,
+	115200: syscall.B115200,
+	230400: syscall.B230400,
+}
+
+// openSerial opens the serial port in the raw 8N1 mode at the baud rate.
+func openSerial(name string, baud int) (*os.File, error) {
+	speed, ok := serialSpeeds[baud]
+	if !ok {
+		return nil, fmt.Errorf("unsupported baud rate: %d", baud)
+	}
+	f, err := os.OpenFile(name, os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		return nil, err
+	}
+	var t syscall.Termios
+	if err := ioctl(f, syscall.TCGETS, unsafe.Pointer(&t)); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s is not a serial port: %w", name, err)
+	}
+	t.Iflag &^= syscall.IGNBRK | syscall.BRKINT | syscall.PARMRK | syscall.ISTRIP | syscall.INLCR |
+		syscall.IGNCR | syscall.ICRNL | syscall.IXON | syscall.IXOFF
+	t.Oflag &^= syscall.OPOST
+	t.Lflag &^= syscall.ECHO | syscall.ECHONL | syscall.ICANON | syscall.ISIG | syscall.IEXTEN
+	t.Cflag &^= syscall.CSIZE | syscall.PARENB | syscall.CSTOPB | termiosCBAUD
+	t.Cflag |= syscall.CS8 | syscall.CREAD | syscall.CLOCAL | speed
+	t.Ispeed, t.Ospeed = speed, speed
+	t.Cc[syscall.VMIN], t.Cc[syscall.VTIME] = 1, 0
+	if err := ioctl(f, syscall.TCSETS, unsafe.Pointer(&t)); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to configure %s: %w", name, err)
+	}
+	// Drop whatever the controller sent before the port was opened.
+	syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), termiosFlush, syscall.TCIOFLUSH)
+	return f, nil
+}
+
+func ioctl(f *os.File, req uintptr, arg unsafe.Pointer) error {
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), req, uintptr(arg)); errno != 0 {
+		return errno
+	}
+	return nil
+}
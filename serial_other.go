@@ -0,0 +1,17 @@
+//go:build !linux
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+)
+
+// holdSignals toggle the feed hold of the send subcommand.
+var holdSignals []os.Signal
+
+// openSerial opens the serial port in the raw 8N1 mode at the baud rate.
+func openSerial(name string, baud int) (*os.File, error) {
+	return nil, fmt.Errorf("the serial ports are not supported on %s", runtime.GOOS)
+}
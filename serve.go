@@ -0,0 +1,226 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/krasin/png2stencil/gcode"
+	"github.com/krasin/png2stencil/stencil"
+)
+
+// previewHTML is the live preview page served on GET /: it posts the picked PNG to /convert every
+// time a parameter slider moves, and shows the input next to the returned preview.
+//
+//go:embed web/preview.html
+var previewHTML []byte
+
+// serveMaxUpload is the largest request the server reads (in bytes).
+const serveMaxUpload = 64 << 20
+
+// serveResponse is the JSON response of the /convert endpoint.
+type serveResponse struct {
+	GCode            string          `json:"gcode"`
+	Summary          stencil.Summary `json:"summary"`
+	Lines            int             `json:"lines"`
+	EstimatedSeconds float64         `json:"estimated_seconds"`
+	// Preview is the SVG preview, as written by --output_svg.
+	Preview string `json:"preview"`
+}
+
+// server converts the images posted to it, --batch_size at a time.
+type server struct {
+	// defaults are the parameters set by the flags, which the requests override.
+	defaults stencil.Params
+	jobs     int
+	sem      chan struct{}
+}
+
+// flagParams returns the conversion parameters set by the flags, with the defaults
+// of stencil.DefaultParams for the rest.
+func flagParams() stencil.Params {
+	p := stencil.DefaultParams()
+	set := func(dst *float64, v float64) {
+		if !math.IsNaN(v) {
+			*dst = v
+		}
+	}
+	set(&p.PxSize, *pxSize)
+	set(&p.ToolDiameter, cutDiameter())
+	set(&p.MillHeight, *millHeight)
+	set(&p.SafeHeight, *safeHeight)
+	set(&p.MillRate, *millRate)
+	set(&p.TravelRate, *travelRate)
+	set(&p.HatchSpacing, *hatchSpacing)
+	set(&p.KnifeOffset, *knifeOffset)
+	if *background != "" {
+		p.Background = *background
+	}
+	if *strategy != "" {
+		p.Strategies = strings.Split(*strategy, ",")
+	}
+	p.N = *n
+	p.Search = *search
+	p.Order = *order
+	p.Mode = *mode
+	p.Dialect = *dialect
+	p.DispenseTime = dispenseTime.String()
+	p.LaserCmd = *laserCmd
+	p.LaserPower = *laserPower
+	p.Passes = *passes
+	p.KnifeAngle = *knifeAngle
+	return p
+}
+
+// runServe implements the serve subcommand: it serves the conversion API on --listen until
+// interrupted. POST /convert takes a multipart form with the PNG in the "image" part and
+// the optional JSON of stencil.Params in the "params" one, overriding the flags, and returns
+// serveResponse as JSON. GET / is a page previewing the conversion live, as its parameter sliders
+// move; run it on localhost for a desktop preview. GET /healthz returns 200 while the server is up.
+func runServe(args []string) error {
+	if err := parseFlags(args); err != nil {
+		return err
+	}
+	if err := setupLogger(*logLevel, *logFormat); err != nil {
+		return err
+	}
+	if *batchSize < 1 {
+		return errorf(exitBadFlags, "--batch_size must be positive")
+	}
+	s := &server{
+		defaults: flagParams(),
+		jobs:     imax(*jobs / *batchSize, 1),
+		sem:      make(chan struct{}, *batchSize),
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/convert", s.handleConvert)
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write(previewHTML)
+	})
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "ok")
+	})
+	srv := &http.Server{Addr: *listen, Handler: mux}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	errc := make(chan error, 1)
+	go func() { errc <- srv.ListenAndServe() }()
+	slog.Info("Serving", "addr", *listen)
+	select {
+	case err := <-errc:
+		return errorf(exitFailure, "failed to serve on %s: %w", *listen, err)
+	case <-ctx.Done():
+	}
+	slog.Info("Shutting down")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	return srv.Shutdown(shutdownCtx)
+}
+
+// handleConvert serves POST /convert.
+func (s *server) handleConvert(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		serveError(w, http.StatusMethodNotAllowed, errors.New("only POST is allowed"))
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, serveMaxUpload)
+	if err := r.ParseMultipartForm(serveMaxUpload); err != nil {
+		serveError(w, http.StatusBadRequest, fmt.Errorf("bad form: %w", err))
+		return
+	}
+	p := s.defaults
+	if params := r.FormValue("params"); params != "" {
+		if err := json.Unmarshal([]byte(params), &p); err != nil {
+			serveError(w, http.StatusBadRequest, fmt.Errorf("bad params: %w", err))
+			return
+		}
+	}
+	opts, err := p.Options(s.jobs)
+	if err != nil {
+		serveError(w, http.StatusBadRequest, err)
+		return
+	}
+	opts.MaxPixels = *maxPixels
+	f, _, err := r.FormFile("image")
+	if err != nil {
+		serveError(w, http.StatusBadRequest, fmt.Errorf("no image: %w", err))
+		return
+	}
+	defer f.Close()
+	img, err := opts.Decode(f)
+	if err != nil {
+		serveError(w, http.StatusBadRequest, fmt.Errorf("failed to decode the image: %w", err))
+		return
+	}
+
+	select {
+	case s.sem <- struct{}{}:
+		defer func() { <-s.sem }()
+	case <-r.Context().Done():
+		return
+	}
+	start := time.Now()
+	opts.Comments = []string{"Generated by " + readBuildInfo().String()}
+	plan, err := stencil.Convert(r.Context(), img, opts)
+	if err != nil {
+		serveError(w, http.StatusBadRequest, err)
+		return
+	}
+	if plan.Interrupted {
+		// The client is gone, so there's no one to respond to.
+		return
+	}
+	var buf bytes.Buffer
+	est := gcode.NewEstimator(opts.Machine.TravelRate)
+	est.DwellSeconds = opts.Machine.Dialect == gcode.DialectGRBL
+	res := serveResponse{Summary: plan.Summary()}
+	err = plan.Program(func(code string) {
+		buf.WriteString(code)
+		buf.WriteByte('\n')
+		est.Feed(code)
+		res.Lines++
+	})
+	if err != nil {
+		serveError(w, http.StatusInternalServerError, err)
+		return
+	}
+	res.GCode = buf.String()
+	res.EstimatedSeconds = est.Stats().Seconds
+	buf.Reset()
+	if err := writePreviewSVG(&buf, plan.Base, plan.PxSize, plan.Centers, opts.Packing.ToolDiameter/2, plan.Paths); err != nil {
+		serveError(w, http.StatusInternalServerError, err)
+		return
+	}
+	res.Preview = buf.String()
+	slog.Info("Converted", "remote", r.RemoteAddr, "apertures", res.Summary.Apertures,
+		"circles", res.Summary.Circles, "lines", res.Lines, "elapsed", time.Since(start))
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
+
+// serveError responds with the error as JSON, like {"error": "..."}.
+func serveError(w http.ResponseWriter, code int, err error) {
+	slog.Error("Request failed", "code", code, "err", err)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+}
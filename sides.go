@@ -0,0 +1,98 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// sideFiles are the flags naming the files a run writes, which get the side in their names
+// in a dual-sided job, so the runs of the sides don't overwrite each other's.
+var sideFiles = []string{
+	"output", "output_stl", "output_dxf", "output_hpgl", "output_camotics", "output_gerber", "output_pdf",
+	"output_svg", "volume_report", "release_report", "report", "checkpoint",
+}
+
+// sideName returns the file name with the side before the extension, like a_top.nc for a.nc.
+// The templates are left as they are, since the inputs of the sides differ.
+func sideName(name, side string) string {
+	if isTemplate(name) {
+		return name
+	}
+	ext := filepath.Ext(name)
+	return strings.TrimSuffix(name, ext) + "_" + side + ext
+}
+
+// pngSize returns the size of the PNG image in pixels.
+func pngSize(name string) (w, h int, err error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return 0, 0, errorf(exitBadInput, "failed to open input file: %w", err)
+	}
+	defer f.Close()
+	c, err := png.DecodeConfig(f)
+	if err != nil {
+		return 0, 0, errorf(exitBadInput, "failed to decode a PNG file %q: %w", name, err)
+	}
+	return c.Width, c.Height, nil
+}
+
+// runSides implements the dual-sided job of --input_top and --input_bottom: each side is converted
+// by a separate run of this program with the same flags, the bottom one mirrored, and a combined
+// report is printed. The sides must be exported with the same bounds, so the mirrored bottom has
+// the same origin as the top one. The outputs get the side in their names, and the debug images,
+// which the runs would overwrite, are off. It returns the most severe exit code of the runs.
+func runSides(cmd string, args []string) (int, error) {
+	if *inputTop == "" || *inputBottom == "" {
+		return 0, errorf(exitBadFlags, "--input_top and --input_bottom go together")
+	}
+	if *input != "" {
+		return 0, errorf(exitBadFlags, "--input can't be used with --input_top and --input_bottom")
+	}
+	if *mirror {
+		return 0, errorf(exitBadFlags, "--mirror can't be used with --input_top and --input_bottom, which mirror the bottom")
+	}
+	tw, th, err := pngSize(*inputTop)
+	if err != nil {
+		return 0, err
+	}
+	bw, bh, err := pngSize(*inputBottom)
+	if err != nil {
+		return 0, err
+	}
+	if tw != bw || th != bh {
+		return 0, errorf(exitBadInput, "the top is %dx%d px, and the bottom %dx%d px; export both sides with the same bounds, so they have the same origin",
+			tw, th, bw, bh)
+	}
+	self, err := os.Executable()
+	if err != nil {
+		return 0, fmt.Errorf("failed to find the executable: %w", err)
+	}
+	var results []batchResult
+	for _, side := range []struct {
+		name, input string
+		mirror      bool
+	}{
+		{"top", *inputTop, false},
+		{"bottom", *inputBottom, true},
+	} {
+		// The last value of a flag wins, so these override the ones in args.
+		childArgs := append(append([]string{cmd}, args...), "--input_top=", "--input_bottom=", "--input="+side.input,
+			fmt.Sprintf("--mirror=%v", side.mirror), "--debug_images=false")
+		for _, name := range sideFiles {
+			if v := flag.Lookup(name).Value.String(); v != "" {
+				childArgs = append(childArgs, fmt.Sprintf("--%s=%s", name, sideName(v, side.name)))
+			}
+		}
+		fmt.Fprintf(os.Stderr, "%s: %s side, %s\n", os.Args[0], side.name, side.input)
+		r, err := runChild(self, side.input, childArgs)
+		if err != nil {
+			return 0, err
+		}
+		results = append(results, r)
+	}
+	return printResults(results), nil
+}
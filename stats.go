@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/krasin/png2stencil/gcode"
+	"github.com/krasin/png2stencil/geom"
+	"github.com/krasin/png2stencil/stencil"
+)
+
+// statRow is a line of the job statistics.
+type statRow struct {
+	Name, Value string
+}
+
+// jobStats returns the statistics of the analysis and of the job, as in printReport. The circles
+// are counted in the dispense mode, and the CutPaths of the plan in the others.
+func jobStats(plan *stencil.Plan, est gcode.Stats, lines, holes int) []statRow {
+	st := &plan.Stats
+	basePxSize := *pxSize / float64(*n)
+	pxArea := basePxSize * basePxSize
+	area, covered := st.Area()
+	rows := []statRow{{"Apertures", fmt.Sprint(len(st.Apertures))}}
+	if *mode == stencil.ModeDispense {
+		rows = append(rows,
+			statRow{"Circles", fmt.Sprint(st.Circles())},
+			statRow{"Plunges", fmt.Sprint(holes)},
+			statRow{"Pad area", fmt.Sprintf("%.3f mm²", float64(area)*pxArea)},
+			statRow{"Open area", fmt.Sprintf("%.3f mm²", float64(covered)*pxArea)},
+			statRow{"Coverage", fmt.Sprintf("%.1f%%", 100*st.Coverage())},
+			statRow{"Uncovered", fmt.Sprintf("%.3f mm², %.1f%%", float64(area-covered)*pxArea, 100*st.Uncovered())})
+	} else {
+		paths, length := cutPaths(plan)
+		rows = append(rows,
+			statRow{"Cut paths", fmt.Sprint(paths)},
+			statRow{"Cut length", fmt.Sprintf("%.1f mm", length)},
+			statRow{"Pad area", fmt.Sprintf("%.3f mm²", float64(area)*pxArea)})
+	}
+	if est.Moves > 0 {
+		rows = append(rows, statRow{"Bounding box", fmt.Sprintf("(%.3f, %.3f) - (%.3f, %.3f) mm, %.3f x %.3f mm",
+			est.Min.X, est.Min.Y, est.Max.X, est.Max.Y, est.Max.X-est.Min.X, est.Max.Y-est.Min.Y)})
+	}
+	return append(rows,
+		statRow{"G-code lines", fmt.Sprint(lines)},
+		statRow{"Estimated time", fmt.Sprint(time.Duration(est.Seconds * float64(time.Second)).Round(time.Second))})
+}
+
+// cutPaths returns the number and the total length (in mm) of the CutPaths of the plan.
+func cutPaths(plan *stencil.Plan) (n int, length float64) {
+	paths := plan.CutPaths()
+	for _, p := range paths {
+		length += geom.PathLength(p)
+	}
+	return len(paths), length
+}
+
+// printReport writes a human readable report of the analysis and of the job: holes is the number
+// of plunges in the dispense mode. The apertures with no circles are warned about in that mode only.
+func printReport(w io.Writer, plan *stencil.Plan, est gcode.Stats, lines, holes int) {
+	basePxSize := *pxSize / float64(*n)
+	for _, r := range jobStats(plan, est, lines, holes) {
+		fmt.Fprintf(w, "%-17s%s\n", r.Name+":", r.Value)
+	}
+	if *mode != stencil.ModeDispense {
+		return
+	}
+	st := &plan.Stats
+	skipped := st.Skipped()
+	fmt.Fprintf(w, "Skipped:         %d\n", len(skipped))
+	for _, c := range skipped {
+		fmt.Fprintf(w, "  warning: aperture %d at (%.3f, %.3f) mm, %.3f mm², has no circles\n",
+			c.ID, float64(c.X)*basePxSize, float64(c.Y)*basePxSize, float64(c.Area)*basePxSize*basePxSize)
+	}
+	if enlarged := st.Enlarged(); len(enlarged) > 0 {
+		fmt.Fprintf(w, "Enlarged:        %d\n", len(enlarged))
+		for _, c := range enlarged {
+			fmt.Fprintf(w, "  warning: aperture %d at (%.3f, %.3f) mm, %.3f mm², is plunged once, oversized to the tool\n",
+				c.ID, float64(c.X)*basePxSize, float64(c.Y)*basePxSize, float64(c.Area)*basePxSize*basePxSize)
+		}
+	}
+}
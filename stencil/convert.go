@@ -0,0 +1,741 @@
+// Package stencil converts a solder paste map image into a stencil plan: the circles to dispense
+// or the contours to cut, and the machine program doing it.
+package stencil
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"log/slog"
+	"math"
+	"time"
+
+	"github.com/krasin/png2stencil/datamatrix"
+	"github.com/krasin/png2stencil/gcode"
+	"github.com/krasin/png2stencil/geom"
+	"github.com/krasin/png2stencil/packer"
+	"github.com/krasin/png2stencil/stencilimg"
+)
+
+// Machine modes.
+const (
+	// ModeDispense plunges to every circle with a dispenser valve.
+	ModeDispense = "dispense"
+	// ModeLaser hatches the apertures with the laser on and off.
+	ModeLaser = "laser"
+	// ModeKnife drags a knife along the aperture contours.
+	ModeKnife = "knife"
+)
+
+// Circle orders.
+const (
+	// OrderComponents mills one component after another, in the column-major order of their first pixels.
+	OrderComponents = "components"
+	// OrderNearest mills the nearest circle next, starting from the machine origin.
+	OrderNearest = "nearest"
+)
+
+// Options are the parameters of a conversion.
+type Options struct {
+	// Background is the color of the input image outside of the apertures.
+	Background color.Color
+	// Packing are the circle packing parameters.
+	Packing packer.Params
+	// Mode is the machine mode: ModeDispense, ModeLaser or ModeKnife.
+	Mode string
+	// Machine are the machine parameters of the program. Its Frame is set by Convert.
+	Machine gcode.Config
+	// KnifeOffset is the distance from the drag knife axis to the blade tip (in mm).
+	KnifeOffset float64
+	// KnifeAngle is the minimal direction change (in degrees) to swivel the drag knife around a corner.
+	KnifeAngle float64
+	// Order is the order of the circles: OrderComponents or OrderNearest.
+	Order string
+	// Jobs is the number of parallel packing workers; the plan is the same for any number.
+	Jobs int
+	// Store, if not nil, keeps the solved components, so an interrupted conversion can resume.
+	Store packer.Store
+	// Progress, if not nil, is called every time a component is packed.
+	Progress func(done, total int)
+	// Mirror flips the input left to right, for the bottom side of a board exported as seen from the top.
+	Mirror bool
+	// Comments are written at the start of the program, one per line.
+	Comments []string
+	// RegistrationHoles are the centers of the holes for the frame pins (in the machine space), cut
+	// with the RegistrationDiameter before the apertures in the laser and the knife modes.
+	RegistrationHoles    []geom.Point
+	RegistrationDiameter float64
+	// CornerInset, if positive, adds the registration holes at the four corners of the image,
+	// that far (in mm) from its edges, for mounting the stencil in a frame.
+	CornerInset float64
+	// RegistrationTool, if not nil, is the tool the registration holes are milled with, changed
+	// to before them, and back to the Tool after them.
+	RegistrationTool *gcode.Tool
+	// Runout is how much wider (in mm) than its Diameter the RegistrationTool cuts, measured, which
+	// the holes are compensated for. The Packing ToolDiameter is the cut width of the Tool already.
+	Runout float64
+	// Tool describes the tool cutting the apertures in the tool change prompts.
+	Tool gcode.Tool
+	// Label is the text engraved after the apertures in the laser and the knife modes, in the
+	// single-stroke font LabelHeight high (in mm), starting at LabelAt (in the machine space). The knife
+	// engraves it LabelDepth (in mm) below the material top, and the laser in a single pass at
+	// LabelPower, or at the Machine LaserPower if it's 0.
+	Label       string
+	LabelAt     geom.Point
+	LabelHeight float64
+	LabelDepth  float64
+	LabelPower  int
+	// Serial is the ID cut after the apertures in the laser and the knife modes as a Data Matrix
+	// code, its dark modules SerialModule apart (in mm) holes of the SerialDot diameter (in mm), with
+	// the lower left corner at SerialAt (in the machine space), see SerialDots.
+	Serial       string
+	SerialAt     geom.Point
+	SerialModule float64
+	SerialDot    float64
+	// Thickness is the material thickness (in mm), 0 if not known. In the knife mode, the Machine MillHeight
+	// must be at least Breakthrough (in mm) below its bottom, so the knife cuts through.
+	Thickness, Breakthrough float64
+	// MergeDistance (in mm) merges the centers closer than it into the first of them, so the machine
+	// doesn't plunge twice at the same spot. The centers closer than the Packing Tolerance are merged
+	// anyway.
+	MergeDistance float64
+	// EnlargeSmall plunges once at the center of every aperture no circle fits into, in the dispense
+	// mode, so it gets a slightly oversized dot rather than no paste.
+	EnlargeSmall bool
+	// MaxPartial is the share of the non-background pixels (0..1) which may be partially different from
+	// the background, like the anti-aliased edges, before Convert warns about them, or 0 not to check.
+	// They are all apertures, so the apertures come out ragged and bigger than designed.
+	MaxPartial float64
+	// MaxPixels is the largest base image (the input magnified Packing.N times) to convert, in pixels,
+	// or 0 for no limit, see CheckSize.
+	MaxPixels int64
+}
+
+// DefaultTolerance is the default packer.Params Tolerance (in mm), far below the precision of any machine.
+const DefaultTolerance = 1e-4
+
+// minToolSubpixels is the least tool diameter (in subpixels) the packing makes sense at: with fewer,
+// the circles are rasterized too coarsely to tell if they fit the apertures.
+const minToolSubpixels = 4
+
+// Validate checks that the options are consistent.
+func (o *Options) Validate() error {
+	switch o.Mode {
+	case ModeDispense, ModeLaser, ModeKnife:
+	default:
+		return fmt.Errorf("unknown mode: %s", o.Mode)
+	}
+	if o.Background == nil {
+		return fmt.Errorf("background color not set")
+	}
+	if !(o.Packing.PxSize > 0) {
+		return fmt.Errorf("pixel size must be positive")
+	}
+	if !(o.Packing.ToolDiameter > 0) {
+		return fmt.Errorf("tool diameter must be positive")
+	}
+	if o.Packing.N < 1 {
+		return fmt.Errorf("the number of subpixels must be positive")
+	}
+	if d := o.Packing.ToolDiameter * float64(o.Packing.N) / o.Packing.PxSize; d < minToolSubpixels {
+		n := int(math.Ceil(minToolSubpixels * o.Packing.PxSize / o.Packing.ToolDiameter))
+		return fmt.Errorf("the tool diameter spans only %.1f subpixels, too few to pack the circles; use at least %d subpixels per pixel", d, n)
+	}
+	if o.Packing.Search != packer.SearchCoarse && o.Packing.Search != packer.SearchFull {
+		return fmt.Errorf("unknown search: %s", o.Packing.Search)
+	}
+	for _, name := range o.Packing.Strategies {
+		if !packer.HasStrategy(name) {
+			return fmt.Errorf("unknown strategy: %s", name)
+		}
+	}
+	if !(o.Packing.EdgeTolerance >= 0 && o.Packing.EdgeTolerance < o.Packing.ToolDiameter/2) {
+		return fmt.Errorf("the edge tolerance must be at least 0 and less than the tool radius")
+	}
+	if !(o.Packing.Tolerance >= 0 && o.Packing.Tolerance < o.Packing.PxSize/float64(o.Packing.N)) {
+		return fmt.Errorf("the tolerance must be at least 0 and less than a subpixel")
+	}
+	if o.Packing.Samples < 0 {
+		return fmt.Errorf("the number of circle samples must not be negative")
+	}
+	switch o.Packing.Connectivity {
+	case 0, stencilimg.Connect4, stencilimg.Connect8:
+	default:
+		return fmt.Errorf("the connectivity must be 4 or 8, got %d", o.Packing.Connectivity)
+	}
+	if o.Packing.CoverageTarget <= 0 || o.Packing.CoverageTarget > 1 {
+		return fmt.Errorf("coverage target must be in (0, 1]")
+	}
+	if o.Order != OrderComponents && o.Order != OrderNearest {
+		return fmt.Errorf("unknown order: %s", o.Order)
+	}
+	if o.Jobs < 1 {
+		return fmt.Errorf("the number of jobs must be positive")
+	}
+	if o.Machine.Dialect != "" && !gcode.HasDialect(o.Machine.Dialect) {
+		return fmt.Errorf("unknown dialect: %s", o.Machine.Dialect)
+	}
+	if !(o.Machine.ArcTolerance >= 0) {
+		return fmt.Errorf("the arc tolerance must not be negative")
+	}
+	if !(o.Machine.BacklashX >= 0) || !(o.Machine.BacklashY >= 0) {
+		return fmt.Errorf("the backlash must not be negative")
+	}
+	if o.Machine.Hooks != nil {
+		if err := o.Machine.Hooks.Validate(); err != nil {
+			return err
+		}
+	}
+	if err := o.validateHeights(); err != nil {
+		return err
+	}
+	if !(o.MaxPartial >= 0 && o.MaxPartial <= 1) {
+		return fmt.Errorf("the share of the partial pixels must be in [0, 1]")
+	}
+	if o.MaxPixels < 0 {
+		return fmt.Errorf("the pixel limit must not be negative")
+	}
+	if !(o.MergeDistance >= 0) {
+		return fmt.Errorf("the merge distance must not be negative")
+	}
+	if o.EnlargeSmall && o.Mode != ModeDispense {
+		return fmt.Errorf("enlarging the small apertures needs the %s mode", ModeDispense)
+	}
+	if !(o.CornerInset >= 0) {
+		return fmt.Errorf("the corner hole inset must not be negative")
+	}
+	holes := len(o.RegistrationHoles) > 0 || o.CornerInset > 0
+	if holes && o.Mode == ModeDispense {
+		return fmt.Errorf("registration holes need the %s or the %s mode", ModeLaser, ModeKnife)
+	}
+	if holes && !(o.RegistrationDiameter > 0) {
+		return fmt.Errorf("registration hole diameter must be positive")
+	}
+	if o.RegistrationTool != nil && o.Mode != ModeKnife {
+		return fmt.Errorf("a registration tool needs the %s mode", ModeKnife)
+	}
+	if t := o.RegistrationTool; t != nil && t.Number == o.Tool.Number {
+		return fmt.Errorf("the registration tool has the same number %d as the tool", t.Number)
+	}
+	if t := o.RegistrationTool; t != nil && !(t.Diameter+o.Runout > 0) {
+		return fmt.Errorf("the registration tool cut width %g must be positive", t.Diameter+o.Runout)
+	}
+	if err := o.validateLabel(); err != nil {
+		return err
+	}
+	if err := o.validateSerial(); err != nil {
+		return err
+	}
+	if o.Machine.DrillRPM < 0 || o.Machine.MillRPM < 0 {
+		return fmt.Errorf("the spindle speeds must not be negative")
+	}
+	if (o.Machine.DrillRPM > 0 || o.Machine.MillRPM > 0) && o.Mode != ModeKnife {
+		return fmt.Errorf("the spindle speeds need the %s mode", ModeKnife)
+	}
+	switch o.Machine.Coolant {
+	case "":
+	case gcode.CoolantFlood, gcode.CoolantMist, gcode.CoolantAir:
+		if o.Mode == ModeDispense {
+			return fmt.Errorf("the coolant needs the %s or the %s mode", ModeLaser, ModeKnife)
+		}
+	default:
+		return fmt.Errorf("unknown coolant: %s", o.Machine.Coolant)
+	}
+	switch o.Machine.ToolChangeCmd {
+	case "", gcode.ToolChangeM0, gcode.ToolChangeM6:
+	default:
+		return fmt.Errorf("unknown tool change command: %s", o.Machine.ToolChangeCmd)
+	}
+	if o.Mode == ModeLaser && o.Machine.Dialect == gcode.DialectGRBL && o.Machine.LaserCmd == gcode.LaserM106 {
+		return fmt.Errorf("the %s dialect has no M106, use the %s laser commands", gcode.DialectGRBL, gcode.LaserM3)
+	}
+	return nil
+}
+
+// validateLabel checks the label, if any: the knife must not cut it through.
+func (o *Options) validateLabel() error {
+	if o.Label == "" {
+		return nil
+	}
+	if o.Mode == ModeDispense {
+		return fmt.Errorf("a label needs the %s or the %s mode", ModeLaser, ModeKnife)
+	}
+	if err := gcode.CheckText(o.Label); err != nil {
+		return fmt.Errorf("bad label: %w", err)
+	}
+	if !(o.LabelHeight > 0) {
+		return fmt.Errorf("the label height must be positive")
+	}
+	if o.LabelPower < 0 {
+		return fmt.Errorf("the label laser power must not be negative")
+	}
+	if o.Mode != ModeKnife {
+		return nil
+	}
+	if !(o.LabelDepth > 0) {
+		return fmt.Errorf("the label depth must be positive")
+	}
+	if -o.LabelDepth <= o.Machine.MillHeight || o.Thickness > 0 && o.LabelDepth >= o.Thickness {
+		return fmt.Errorf("the label depth %g cuts through the material; it must be shallower than the mill height and the thickness", o.LabelDepth)
+	}
+	return nil
+}
+
+// validateSerial checks the serial code, if any: its dots must not merge.
+func (o *Options) validateSerial() error {
+	if o.Serial == "" {
+		return nil
+	}
+	if o.Mode == ModeDispense {
+		return fmt.Errorf("a serial code needs the %s or the %s mode", ModeLaser, ModeKnife)
+	}
+	if _, err := SerialDots(o.Serial, o.SerialAt, o.SerialModule); err != nil {
+		return fmt.Errorf("bad serial: %w", err)
+	}
+	if !(o.SerialModule > 0) || !(o.SerialDot > 0) {
+		return fmt.Errorf("the serial code module and dot sizes must be positive")
+	}
+	if o.SerialDot >= o.SerialModule {
+		return fmt.Errorf("the serial code dot %g must be smaller than the module %g, so the dots don't merge", o.SerialDot, o.SerialModule)
+	}
+	return nil
+}
+
+// SerialDots returns the centers of the dots (in the machine space) of the Data Matrix code
+// of the serial with the module size (in mm) and the lower left corner at at.
+func SerialDots(serial string, at geom.Point, module float64) ([]geom.Point, error) {
+	modules, err := datamatrix.Encode(serial)
+	if err != nil {
+		return nil, err
+	}
+	var res []geom.Point
+	// The rows go from the top, and Y points up.
+	for r, row := range modules {
+		y := at.Y + (float64(len(modules)-1-r)+0.5)*module
+		for c, dark := range row {
+			if dark {
+				res = append(res, geom.Pt(at.X+(float64(c)+0.5)*module, y))
+			}
+		}
+	}
+	return res, nil
+}
+
+// validateHeights checks the heights of the modes moving Z. The heights are relative to the material top.
+func (o *Options) validateHeights() error {
+	if o.Thickness < 0 || o.Breakthrough < 0 {
+		return fmt.Errorf("the thickness and the breakthrough must not be negative")
+	}
+	if o.Mode == ModeLaser {
+		return nil
+	}
+	mill, safe := o.Machine.MillHeight, o.Machine.SafeHeight
+	if !(safe > 0) {
+		return fmt.Errorf("the safe height %g must be above the material top", safe)
+	}
+	if !(safe > mill) {
+		return fmt.Errorf("the safe height %g must be above the mill height %g", safe, mill)
+	}
+	// The tolerance keeps the rounding errors of the sum from refusing the exact depth.
+	if o.Mode == ModeKnife && o.Thickness > 0 && mill > -(o.Thickness+o.Breakthrough)+o.Packing.Tolerance {
+		return fmt.Errorf("the mill height %g doesn't cut through the material %g thick with the breakthrough %g; use %.3f or lower",
+			mill, o.Thickness, o.Breakthrough, -(o.Thickness + o.Breakthrough))
+	}
+	return nil
+}
+
+// Plan is the result of a conversion.
+type Plan struct {
+	// Base is the input mask magnified Packing.N times, and PxSize is its pixel size (in mm).
+	Base   *stencilimg.ScaledMask
+	PxSize float64
+	// Frame converts the base image space to the machine space.
+	Frame geom.Frame
+	// Components is the number of the connected components of the input. If the conversion
+	// was interrupted, only some of them are solved.
+	Components int
+	// Interrupted tells if the context was done before all components were solved.
+	Interrupted bool
+	// Stats has the packing results of the solved components.
+	Stats Stats
+	// Centers are the circles to mill (in the base image space), in the milling order, and
+	// Strategies are the packing strategies which placed them.
+	Centers    []geom.Point
+	Strategies []string
+	// Paths are the knife toolpaths (in the base image space) in the knife mode.
+	Paths [][]geom.Point
+	// Comments start the program; they are the Options.Comments, which may be replaced
+	// with the ones describing the plan.
+	Comments []string
+
+	opts Options
+	// src is the input mask.
+	src *stencilimg.BitMask
+}
+
+// StrategyEnlarged is the strategy of the single circle of an aperture enlarged to the tool, see Options.EnlargeSmall.
+const StrategyEnlarged = "enlarged"
+
+// enlarged returns the packing of a component no circle fits into, plunged once at its center.
+func enlarged(c *packer.Component) packer.Packing {
+	return packer.Packing{Strategy: StrategyEnlarged, Centers: []geom.Point{c.Center()}}
+}
+
+// Convert packs the apertures of the image. Once ctx is done, the packing stops, and the plan
+// covers the components solved by then, with Interrupted set.
+func Convert(ctx context.Context, img image.Image, opts Options) (*Plan, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+	if err := opts.CheckSize(img.Bounds().Dx(), img.Bounds().Dy()); err != nil {
+		return nil, err
+	}
+	n := opts.Packing.N
+	basePxSize := opts.Packing.PxSize / float64(n)
+	// The base image is the input mask magnified n times. It's never stored as a whole:
+	// the components are labeled at the input resolution, and each component is
+	// magnified only while it's packed.
+	if opts.MaxPartial > 0 {
+		if partial, total := stencilimg.PartialPixels(img, opts.Background); ratio(partial, total) > opts.MaxPartial {
+			slog.Warn("Input looks anti-aliased, which makes the apertures ragged; export it without anti-aliasing",
+				"partial_pixels", partial, "pixels", total, "share", ratio(partial, total))
+		}
+	}
+	src := stencilimg.Threshold(img, opts.Background)
+	if opts.Mirror {
+		src = src.Mirrored()
+	}
+	base := stencilimg.NewScaledMask(src, n)
+	plan := &Plan{
+		Base:     base,
+		PxSize:   basePxSize,
+		Frame:    geom.Frame{Height: float64(base.Bounds().Dy()) * basePxSize},
+		Comments: opts.Comments,
+		opts:     opts,
+		src:      src,
+	}
+	plan.opts.Machine.Frame = plan.Frame
+	if o := opts.CornerInset; o > 0 {
+		w, h := float64(base.Bounds().Dx())*basePxSize, float64(base.Bounds().Dy())*basePxSize
+		if 2*o >= w || 2*o >= h {
+			return nil, fmt.Errorf("the corner hole inset %g doesn't fit the image %gx%g mm", o, w, h)
+		}
+		// Around the image from the lower left corner, in the machine space.
+		plan.opts.RegistrationHoles = append(append([]geom.Point(nil), opts.RegistrationHoles...),
+			plan.Frame.ToMachine(geom.Pt(o, h-o)), plan.Frame.ToMachine(geom.Pt(w-o, h-o)),
+			plan.Frame.ToMachine(geom.Pt(w-o, o)), plan.Frame.ToMachine(geom.Pt(o, o)))
+	}
+
+	comps := packer.Segment(src, &opts.Packing)
+	plan.Components = len(comps)
+	slog.Info("Segmented the input", "width", base.Bounds().Dx(), "height", base.Bounds().Dy(), "components", len(comps))
+	// The coverage is computed as soon as a component is packed, while its pixels are still there.
+	apertures := make([]Aperture, len(comps))
+	done := 0
+	packings, solved := packer.PackAll(comps, opts.Jobs, opts.Store, ctx.Done(), func(c *packer.Component, p packer.Packing, elapsed time.Duration) {
+		slog.Debug("Component processed", "component", c.ID, "x", c.Seed.X, "y", c.Seed.Y, "circles", len(p.Centers),
+			"strategy", p.Strategy, "elapsed", elapsed)
+		if opts.EnlargeSmall && len(p.Centers) == 0 {
+			p = enlarged(c)
+		}
+		apertures[c.ID] = NewAperture(c, p)
+		done++
+		if opts.Progress != nil {
+			opts.Progress(done, len(comps))
+		}
+	})
+	plan.Interrupted = done < len(comps) && ctx.Err() != nil
+
+	// owners are the indices of the apertures of the centers in Stats.
+	var owners []int
+	for k := range comps {
+		if !solved[k] {
+			continue
+		}
+		p := packings[k]
+		if opts.EnlargeSmall && len(p.Centers) == 0 {
+			p = enlarged(comps[k])
+		}
+		plan.Centers = append(plan.Centers, p.Centers...)
+		for range p.Centers {
+			plan.Strategies = append(plan.Strategies, p.Strategy)
+			owners = append(owners, len(plan.Stats.Apertures))
+		}
+		a := apertures[k]
+		plan.Stats.Apertures = append(plan.Stats.Apertures, a)
+		switch {
+		case a.Circles == 0:
+			slog.Warn("Aperture skipped: no circle fits into it", "component", a.ID, "x", a.X, "y", a.Y, "area_px", a.Area)
+		case a.Enlarged():
+			slog.Warn("Aperture enlarged: no circle fits into it, plunged once at its center", "component", a.ID, "x", a.X, "y", a.Y,
+				"area_px", a.Area, "covered_px", a.Covered)
+		}
+		slog.Debug("Component coverage", "component", k, "area_px", a.Area, "coverage", ratio(a.Covered, a.Area))
+	}
+
+	merge := math.Max(opts.MergeDistance, opts.Packing.Tolerance)
+	if keep := geom.Dedupe(plan.Centers, merge); len(keep) < len(plan.Centers) {
+		kept := make([]bool, len(plan.Centers))
+		for _, i := range keep {
+			kept[i] = true
+		}
+		for i, k := range kept {
+			if !k {
+				plan.Stats.Apertures[owners[i]].Circles--
+			}
+		}
+		slog.Info("Merged the coincident centers", "merged", len(plan.Centers)-len(keep), "distance", merge)
+		centers := make([]geom.Point, len(keep))
+		strategies := make([]string, len(keep))
+		for i, k := range keep {
+			centers[i] = plan.Centers[k]
+			strategies[i] = plan.Strategies[k]
+		}
+		plan.Centers, plan.Strategies = centers, strategies
+	}
+
+	if opts.Order == OrderNearest {
+		// The machine origin is at the bottom left corner of the image.
+		idx := geom.NearestOrder(plan.Centers, geom.Pt(0, float64(base.Bounds().Dy())*basePxSize))
+		centers := make([]geom.Point, len(idx))
+		strategies := make([]string, len(idx))
+		for i, k := range idx {
+			centers[i] = plan.Centers[k]
+			strategies[i] = plan.Strategies[k]
+		}
+		plan.Centers, plan.Strategies = centers, strategies
+	}
+	if opts.Mode == ModeKnife {
+		plan.Paths = gcode.KnifePaths(stencilimg.TraceContours(base, basePxSize), opts.KnifeOffset,
+			opts.KnifeAngle*math.Pi/180, opts.Machine.ArcTolerance)
+	}
+	return plan, nil
+}
+
+// RegistrationHoles returns the centers of the registration holes (in the machine space): the Options
+// RegistrationHoles, and the corner ones, if any.
+func (p *Plan) RegistrationHoles() []geom.Point {
+	return p.opts.RegistrationHoles
+}
+
+// CutPaths returns the paths (in the base image space) the laser or the knife cuts the apertures
+// along: the Paths in the knife mode, and the hatch lines in the laser mode. There are none in the
+// dispense mode.
+func (p *Plan) CutPaths() [][]geom.Point {
+	switch p.opts.Mode {
+	case ModeLaser:
+		var paths [][]geom.Point
+		for _, l := range gcode.HatchLines(p.Base, p.PxSize, p.opts.Machine.HatchSpacing) {
+			paths = append(paths, []geom.Point{l[0], l[1]})
+		}
+		return paths
+	case ModeKnife:
+		return p.Paths
+	}
+	return nil
+}
+
+// Program generates the machine program of the plan in the Machine dialect, passing the lines
+// to add one by one, see SheetProgram.
+func (p *Plan) Program(add func(code string)) error {
+	return SheetProgram(add, p)
+}
+
+// Shifted returns the plan moved by d (in mm) in the machine space, with its registration holes,
+// label and serial code, for laying it out on a sheet with others, see SheetProgram.
+func (p *Plan) Shifted(d geom.Point) *Plan {
+	res := *p
+	res.Frame = geom.Frame{X: p.Frame.X + d.X, Height: p.Frame.Height + d.Y}
+	res.opts.Machine.Frame = res.Frame
+	res.opts.RegistrationHoles = nil
+	for _, h := range p.opts.RegistrationHoles {
+		res.opts.RegistrationHoles = append(res.opts.RegistrationHoles, geom.Pt(h.X+d.X, h.Y+d.Y))
+	}
+	res.opts.LabelAt = geom.Pt(p.opts.LabelAt.X+d.X, p.opts.LabelAt.Y+d.Y)
+	res.opts.SerialAt = geom.Pt(p.opts.SerialAt.X+d.X, p.opts.SerialAt.Y+d.Y)
+	return &res
+}
+
+// SheetProgram generates the machine program cutting the plans, like the ones laid out on a sheet
+// with Shifted, in the Machine dialect of the first plan, passing the lines to add one by one.
+// The plans must have the same Options but the Frame. It starts with the Comments of the first
+// plan; the program of an interrupted plan in the dispense mode has one more telling it's partial.
+// The RegistrationHoles of all plans are cut first, so the RegistrationTool is changed to once,
+// then the apertures, the Label and the Serial code of every plan in turn, and the Machine Coolant
+// is on while cutting any. The spindle is switched to the DrillRPM for the holes the tool fills
+// and to the MillRPM for the milled ones and the apertures.
+func SheetProgram(add func(code string), plans ...*Plan) error {
+	// The emitter reads the config, which gets the Frame of every plan in turn.
+	cfg := plans[0].opts.Machine
+	e, err := gcode.NewEmitter(add, &cfg)
+	if err != nil {
+		return err
+	}
+	opts := plans[0].opts
+	for _, c := range plans[0].Comments {
+		e.Comment(c)
+	}
+	var holes []geom.Point
+	for _, p := range plans {
+		if p.opts.Mode == ModeDispense && p.Interrupted {
+			e.Comment(fmt.Sprintf("PARTIAL PROGRAM: interrupted after %d of %d apertures", len(p.Stats.Apertures), p.Components))
+		}
+		holes = append(holes, p.opts.RegistrationHoles...)
+	}
+	e.Header()
+	spindle := func(rpm float64) {
+		if cfg.DrillRPM > 0 || cfg.MillRPM > 0 {
+			e.Spindle(rpm)
+		}
+	}
+	laser := opts.Mode == ModeLaser
+	if len(holes) > 0 {
+		e.Comment(fmt.Sprintf("Registration holes: %d", len(holes)))
+		toolDiameter := 0.0
+		if t := opts.RegistrationTool; t != nil {
+			gcode.ChangeTool(e, &cfg, *t, laser)
+			toolDiameter = t.Diameter + opts.Runout
+		}
+		rpm := cfg.MillRPM
+		if opts.RegistrationDiameter <= toolDiameter {
+			rpm = cfg.DrillRPM
+		}
+		spindle(rpm)
+		e.Coolant(true)
+		gcode.Holes(e, &cfg, holes, opts.RegistrationDiameter, toolDiameter, laser)
+		e.Coolant(false)
+		if opts.RegistrationTool != nil {
+			gcode.ChangeTool(e, &cfg, opts.Tool, laser)
+		}
+		e.Comment("Apertures")
+	}
+	spindle(cfg.MillRPM)
+	e.Coolant(true)
+	for _, p := range plans {
+		cfg.Frame = p.opts.Machine.Frame
+		p.cut(e, &cfg)
+	}
+	e.Coolant(false)
+	spindle(0)
+	e.Footer()
+	return nil
+}
+
+// cut generates the program cutting the apertures, the Label and the Serial code of the plan with
+// the emitter reading the config.
+func (p *Plan) cut(e gcode.Emitter, cfg *gcode.Config) {
+	switch p.opts.Mode {
+	case ModeDispense:
+		gcode.Dispense(e, cfg, p.Centers)
+	case ModeLaser:
+		gcode.Laser(e, cfg, p.Base, p.PxSize)
+	case ModeKnife:
+		gcode.Knife(e, cfg, p.Paths)
+	}
+	if p.opts.Label != "" {
+		e.Comment("Label: " + p.opts.Label)
+		// The emitter turns the laser on at the config power, so it's set for the label and back.
+		power := cfg.LaserPower
+		if p.opts.LabelPower > 0 {
+			cfg.LaserPower = p.opts.LabelPower
+		}
+		gcode.Engrave(e, cfg, gcode.TextPaths(p.opts.Label, p.opts.LabelAt, p.opts.LabelHeight), -p.opts.LabelDepth, p.opts.Mode == ModeLaser)
+		cfg.LaserPower = power
+	}
+	if p.opts.Serial != "" {
+		e.Comment("Serial code: " + p.opts.Serial)
+		// Validate checked the serial.
+		dots, _ := SerialDots(p.opts.Serial, p.opts.SerialAt, p.opts.SerialModule)
+		gcode.Holes(e, cfg, dots, p.opts.SerialDot, p.opts.Packing.ToolDiameter, p.opts.Mode == ModeLaser)
+	}
+}
+
+// WriteProgram writes the machine program of the plan to w, one line after another,
+// with no newline after the last one.
+func (p *Plan) WriteProgram(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	lines := 0
+	err := p.Program(func(code string) {
+		if lines > 0 {
+			bw.WriteByte('\n')
+		}
+		bw.WriteString(code)
+		lines++
+	})
+	if err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// DefaultMaxPixels is the default Options.MaxPixels. The base image of that size takes a few GB
+// with the debug images.
+const DefaultMaxPixels = 1 << 30
+
+// maxLabels is the most input pixels LabelRegions can label.
+const maxLabels = math.MaxInt32
+
+// CheckSize checks that the input image of w×h pixels can be converted with the options: its size
+// and its magnified size must not overflow, and the base image must have at most MaxPixels pixels.
+// It's much cheaper to check before decoding the pixels, see Options.Decode.
+func (o *Options) CheckSize(w, h int) error {
+	n := o.Packing.N
+	if w <= 0 || h <= 0 {
+		return fmt.Errorf("the image is empty: %dx%d pixels", w, h)
+	}
+	if int64(w)*int64(h) > maxLabels {
+		return fmt.Errorf("the image is too large: %dx%d pixels, at most %d are supported", w, h, maxLabels)
+	}
+	if n > math.MaxInt32/w || n > math.MaxInt32/h {
+		return fmt.Errorf("the image is too large: %dx%d pixels magnified %d times overflow", w, h, n)
+	}
+	if px := int64(w*n) * int64(h*n); o.MaxPixels > 0 && px > o.MaxPixels {
+		return fmt.Errorf("the image is too large: %dx%d pixels magnified %d times is %d pixels, more than the limit of %d; "+
+			"use fewer subpixels or raise the limit", w, h, n, px, o.MaxPixels)
+	}
+	return nil
+}
+
+// Decode reads a solder paste map image in the PNG format.
+func Decode(r io.Reader) (image.Image, error) {
+	return png.Decode(r)
+}
+
+// Decode reads a solder paste map image in the PNG format, failing before the pixels are decoded
+// if its size doesn't pass CheckSize, so a huge image doesn't exhaust the memory.
+func (o *Options) Decode(r io.Reader) (image.Image, error) {
+	var head bytes.Buffer
+	cfg, err := png.DecodeConfig(io.TeeReader(r, &head))
+	if err != nil {
+		return nil, err
+	}
+	if err := o.CheckSize(cfg.Width, cfg.Height); err != nil {
+		return nil, err
+	}
+	return png.Decode(io.MultiReader(&head, r))
+}
+
+// ConvertReader decodes the PNG image from r and converts it, see Convert.
+func ConvertReader(ctx context.Context, r io.Reader, opts Options) (*Plan, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+	img, err := opts.Decode(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode the image: %w", err)
+	}
+	return Convert(ctx, img, opts)
+}
+
+// ratio returns a/b, or 0 if b is 0.
+func ratio(a, b int) float64 {
+	if b == 0 {
+		return 0
+	}
+	return float64(a) / float64(b)
+}
@@ -0,0 +1,86 @@
+package stencil
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/draw"
+	"testing"
+)
+
+// testPads are the apertures of the test image of 40x30 px: the one near the top and the one
+// near the bottom tell if Y is flipped right.
+var testPads = []image.Rectangle{image.Rect(3, 2, 15, 8), image.Rect(20, 20, 36, 27)}
+
+// padImage returns the image with the testPads moved by d, in the bounds.
+func padImage(bounds image.Rectangle, d image.Point) *image.Gray {
+	img := image.NewGray(bounds)
+	for _, r := range testPads {
+		draw.Draw(img, r.Add(d), image.NewUniform(color.White), image.Point{}, draw.Src)
+	}
+	return img
+}
+
+func TestConvertSubImage(t *testing.T) {
+	zero := padImage(image.Rect(0, 0, 40, 30), image.Point{})
+	// The same pixels at (7, 11) of a larger image.
+	d := image.Pt(7, 11)
+	sub := padImage(image.Rect(-5, -5, 60, 60), d).SubImage(image.Rect(0, 0, 40, 30).Add(d))
+	const pxSize = 0.05
+	for _, mode := range []string{ModeDispense, ModeKnife} {
+		opts := NewOptions(
+			WithBackground(color.Black),
+			WithPixelSize(pxSize),
+			WithSubpixels(2),
+			WithTool(0.25),
+			WithMode(mode),
+			WithHeights(-0.1, 1),
+			WithRates(300, 1000),
+			WithKnife(0.1, 10))
+		want, err := Convert(context.Background(), zero, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := Convert(context.Background(), sub, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Frame != want.Frame {
+			t.Errorf("%s: Frame: got %v, want %v", mode, got.Frame, want.Frame)
+		}
+		if len(got.Stats.Apertures) != len(testPads) {
+			t.Errorf("%s: got %d apertures, want %d", mode, len(got.Stats.Apertures), len(testPads))
+		}
+		var gotProg, wantProg bytes.Buffer
+		if err := got.WriteProgram(&gotProg); err != nil {
+			t.Fatal(err)
+		}
+		if err := want.WriteProgram(&wantProg); err != nil {
+			t.Fatal(err)
+		}
+		if gotProg.String() != wantProg.String() {
+			t.Errorf("%s: the program of the sub-image differs from the one of the zero-origin image", mode)
+		}
+	}
+
+	// The top pad is at the top of the machine space, with Y pointing up.
+	opts := NewOptions(WithBackground(color.Black), WithPixelSize(pxSize), WithTool(0.25), WithHeights(-0.1, 1), WithRates(300, 1000))
+	plan, err := Convert(context.Background(), sub, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plan.Centers) == 0 {
+		t.Fatal("got no circles")
+	}
+	for _, c := range plan.Centers {
+		p := plan.Frame.ToMachine(c)
+		top := testPads[0]
+		inTop := p.Y >= float64(30-top.Max.Y)*pxSize && p.Y <= float64(30-top.Min.Y)*pxSize
+		bottom := testPads[1]
+		inBottom := p.Y >= float64(30-bottom.Max.Y)*pxSize && p.Y <= float64(30-bottom.Min.Y)*pxSize
+		if !inTop && !inBottom {
+			t.Errorf("circle at machine (%.3f, %.3f) is outside of the pads", p.X, p.Y)
+		}
+	}
+}
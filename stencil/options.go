@@ -0,0 +1,277 @@
+package stencil
+
+import (
+	"image/color"
+	"runtime"
+	"time"
+
+	"github.com/krasin/png2stencil/gcode"
+	"github.com/krasin/png2stencil/geom"
+	"github.com/krasin/png2stencil/packer"
+	"github.com/krasin/png2stencil/stencilimg"
+)
+
+// Option sets a conversion parameter, see NewOptions.
+type Option func(o *Options)
+
+// NewOptions returns the options with the defaults of the command line tool, changed
+// by opts in order, like
+//
+//	stencil.NewOptions(
+//		stencil.WithBackground(color.Black),
+//		stencil.WithPixelSize(0.05),
+//		stencil.WithTool(1.0),
+//		stencil.WithDepth(0.15),
+//		stencil.WithDialect(gcode.DialectGRBL))
+//
+// The pixel size, the tool and the background have no defaults and must be set.
+func NewOptions(opts ...Option) Options {
+	o := Options{
+		Packing: packer.Params{
+			N:              1,
+			Search:         packer.SearchCoarse,
+			CoverageTarget: 1,
+			Adaptive:       true,
+			Connectivity:   stencilimg.Connect4,
+			Samples:        1,
+			Tolerance:      DefaultTolerance,
+		},
+		Mode: ModeDispense,
+		Machine: gcode.Config{
+			DispenseTime:  50 * time.Millisecond,
+			LaserCmd:      gcode.LaserM3,
+			LaserPower:    255,
+			Passes:        1,
+			Dialect:       gcode.DialectMarlin,
+			ToolChangeCmd: gcode.ToolChangeM0,
+		},
+		Tool:          gcode.Tool{Number: 1},
+		MergeDistance: 0.01,
+		MaxPartial:    0.1,
+		MaxPixels:     DefaultMaxPixels,
+		KnifeAngle:    10,
+		Order:         OrderComponents,
+		Jobs:          runtime.GOMAXPROCS(0),
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// WithBackground sets the color of the input image outside of the apertures.
+func WithBackground(c color.Color) Option {
+	return func(o *Options) { o.Background = c }
+}
+
+// WithPixelSize sets the size of an input pixel side (in mm).
+func WithPixelSize(mm float64) Option {
+	return func(o *Options) { o.Packing.PxSize = mm }
+}
+
+// WithSubpixels sets the number of linear subpixels for each input pixel.
+func WithSubpixels(n int) Option {
+	return func(o *Options) { o.Packing.N = n }
+}
+
+// WithTool sets the tool diameter (in mm).
+func WithTool(diameter float64) Option {
+	return func(o *Options) { o.Packing.ToolDiameter = diameter }
+}
+
+// WithSearch sets the lattice offset search: packer.SearchCoarse or packer.SearchFull.
+func WithSearch(search string) Option {
+	return func(o *Options) { o.Packing.Search = search }
+}
+
+// WithStrategies sets the packing strategies to try, in this order.
+func WithStrategies(names ...string) Option {
+	return func(o *Options) { o.Packing.Strategies = names }
+}
+
+// WithMode sets the machine mode: ModeDispense, ModeLaser or ModeKnife.
+func WithMode(mode string) Option {
+	return func(o *Options) { o.Mode = mode }
+}
+
+// WithDialect sets the controller dialect, like gcode.DialectGRBL.
+func WithDialect(name string) Option {
+	return func(o *Options) { o.Machine.Dialect = name }
+}
+
+// WithRates sets the mill and the travel feed rates (in mm/min).
+func WithRates(mill, travel float64) Option {
+	return func(o *Options) { o.Machine.MillRate, o.Machine.TravelRate = mill, travel }
+}
+
+// WithHeights sets the working and the travel Z (in mm).
+func WithHeights(mill, safe float64) Option {
+	return func(o *Options) { o.Machine.MillHeight, o.Machine.SafeHeight = mill, safe }
+}
+
+// WithDepth sets the working Z to depth (in mm) below the Z zero.
+func WithDepth(depth float64) Option {
+	return func(o *Options) { o.Machine.MillHeight = -depth }
+}
+
+// WithDispenseTime sets how long the dispenser valve is kept open for each shot.
+func WithDispenseTime(d time.Duration) Option {
+	return func(o *Options) { o.Machine.DispenseTime = d }
+}
+
+// WithLaser sets the laser power (the S value), the number of passes and the distance
+// between the hatching lines (in mm).
+func WithLaser(power, passes int, spacing float64) Option {
+	return func(o *Options) {
+		o.Machine.LaserPower, o.Machine.Passes, o.Machine.HatchSpacing = power, passes, spacing
+	}
+}
+
+// WithKnife sets the drag knife offset (in mm) and the minimal direction change (in degrees)
+// to swivel it around a corner.
+func WithKnife(offset, angle float64) Option {
+	return func(o *Options) { o.KnifeOffset, o.KnifeAngle = offset, angle }
+}
+
+// WithOrder sets the order of the circles: OrderComponents or OrderNearest.
+func WithOrder(order string) Option {
+	return func(o *Options) { o.Order = order }
+}
+
+// WithJobs sets the number of parallel packing workers.
+func WithJobs(jobs int) Option {
+	return func(o *Options) { o.Jobs = jobs }
+}
+
+// WithStore sets the store of the solved components, so an interrupted conversion can resume.
+func WithStore(s packer.Store) Option {
+	return func(o *Options) { o.Store = s }
+}
+
+// WithProgress sets the function called every time a component is packed.
+func WithProgress(f func(done, total int)) Option {
+	return func(o *Options) { o.Progress = f }
+}
+
+// WithMirror flips the input left to right, for the bottom side of a board.
+func WithMirror() Option {
+	return func(o *Options) { o.Mirror = true }
+}
+
+// WithRegistrationHoles adds the holes with the diameter (in mm) around the centers (in the machine
+// space), cut before the apertures.
+func WithRegistrationHoles(diameter float64, centers ...geom.Point) Option {
+	return func(o *Options) {
+		o.RegistrationDiameter = diameter
+		o.RegistrationHoles = append(o.RegistrationHoles, centers...)
+	}
+}
+
+// WithCornerHoles adds the registration holes at the four corners of the image, inset (in mm)
+// from its edges, with the diameter of the other registration holes.
+func WithCornerHoles(inset float64) Option {
+	return func(o *Options) { o.CornerInset = inset }
+}
+
+// WithRegistrationTool sets the tool the registration holes are milled with.
+func WithRegistrationTool(t gcode.Tool) Option {
+	return func(o *Options) { o.RegistrationTool = &t }
+}
+
+// WithArcs fits the arcs to the closed knife paths within tol (in mm) of a circle, and cuts the holes
+// with the arcs.
+func WithArcs(tol float64) Option {
+	return func(o *Options) { o.Machine.ArcTolerance = tol }
+}
+
+// WithBacklash sets the slack (in mm) of the X and Y drives, which the program takes up every
+// time the motion along the axis reverses.
+func WithBacklash(x, y float64) Option {
+	return func(o *Options) { o.Machine.BacklashX, o.Machine.BacklashY = x, y }
+}
+
+// WithRunout sets how much wider (in mm) than its diameter the registration tool cuts, measured;
+// the tool of WithTool is the cut width already.
+func WithRunout(mm float64) Option {
+	return func(o *Options) { o.Runout = mm }
+}
+
+// WithLabel engraves the text capHeight high (in mm) from the lower left corner at (in the machine
+// space) after the apertures: depth (in mm) below the material top with the knife, or in a single
+// laser pass at the power, or at the laser power if it's 0.
+func WithLabel(text string, at geom.Point, capHeight, depth float64, power int) Option {
+	return func(o *Options) {
+		o.Label, o.LabelAt, o.LabelHeight, o.LabelDepth, o.LabelPower = text, at, capHeight, depth, power
+	}
+}
+
+// WithSerial cuts the Data Matrix code of the serial after the apertures, its dark modules
+// module apart (in mm) holes of the dot diameter (in mm), from the lower left corner at (in the
+// machine space).
+func WithSerial(serial string, at geom.Point, module, dot float64) Option {
+	return func(o *Options) {
+		o.Serial, o.SerialAt, o.SerialModule, o.SerialDot = serial, at, module, dot
+	}
+}
+
+// WithToolChange sets the tool change command set: gcode.ToolChangeM0 or gcode.ToolChangeM6.
+func WithToolChange(cmd string) Option {
+	return func(o *Options) { o.Machine.ToolChangeCmd = cmd }
+}
+
+// WithCoolant sets the coolant: gcode.CoolantFlood, gcode.CoolantMist or gcode.CoolantAir.
+func WithCoolant(coolant string) Option {
+	return func(o *Options) { o.Machine.Coolant = coolant }
+}
+
+// WithSpindle sets the spindle speeds of the plunged holes and of the milled cuts (in rpm).
+func WithSpindle(drillRPM, millRPM float64) Option {
+	return func(o *Options) { o.Machine.DrillRPM, o.Machine.MillRPM = drillRPM, millRPM }
+}
+
+// WithEnlargeSmall sets if the apertures no circle fits into are plunged once at the center.
+func WithEnlargeSmall(enlarge bool) Option {
+	return func(o *Options) { o.EnlargeSmall = enlarge }
+}
+
+// WithThickness sets the material thickness and how deep below it the knife cuts through (in mm).
+func WithThickness(thickness, breakthrough float64) Option {
+	return func(o *Options) { o.Thickness, o.Breakthrough = thickness, breakthrough }
+}
+
+// WithConnectivity sets if the diagonally touching pixels are in the same aperture.
+func WithConnectivity(conn stencilimg.Connectivity) Option {
+	return func(o *Options) { o.Packing.Connectivity = conn }
+}
+
+// WithEdgeTolerance sets how far (in mm) the circles may protrude beyond the apertures.
+func WithEdgeTolerance(tol float64) Option {
+	return func(o *Options) { o.Packing.EdgeTolerance = tol }
+}
+
+// WithMergeDistance sets the distance (in mm) the closer centers are merged at.
+func WithMergeDistance(d float64) Option {
+	return func(o *Options) { o.MergeDistance = d }
+}
+
+// WithMaxPixels sets the largest base image to convert (in pixels), or 0 for no limit.
+func WithMaxPixels(px int64) Option {
+	return func(o *Options) { o.MaxPixels = px }
+}
+
+// WithMaxPartial sets the share of the partially colored input pixels (0..1) to warn about
+// the anti-aliasing above, or 0 not to check.
+func WithMaxPartial(share float64) Option {
+	return func(o *Options) { o.MaxPartial = share }
+}
+
+// WithCircleSamples sets the number of the sample points along each pixel side the circles are
+// tested against, or 0 for the exact test.
+func WithCircleSamples(samples int) Option {
+	return func(o *Options) { o.Packing.Samples = samples }
+}
+
+// WithTolerance sets how far apart (in mm) the coordinates may be and still compare equal.
+func WithTolerance(tol float64) Option {
+	return func(o *Options) { o.Packing.Tolerance = tol }
+}
@@ -0,0 +1,156 @@
+package stencil
+
+import (
+	"fmt"
+	"image/color"
+	"math"
+	"time"
+
+	"github.com/krasin/png2stencil/gcode"
+	"github.com/krasin/png2stencil/geom"
+)
+
+// Params are the conversion parameters named as the command line flags, for the front ends
+// taking them as JSON. Decode the JSON over DefaultParams, so the missing ones keep the defaults.
+type Params struct {
+	PxSize       float64  `json:"px_size"`
+	N            int      `json:"n"`
+	ToolDiameter float64  `json:"tool_diameter"`
+	Background   string   `json:"background"`
+	Search       string   `json:"search"`
+	Strategies   []string `json:"strategy"`
+	Order        string   `json:"order"`
+	Mode         string   `json:"mode"`
+	Dialect      string   `json:"dialect"`
+	MillHeight   float64  `json:"mill_height"`
+	SafeHeight   float64  `json:"safe_height"`
+	MillRate     float64  `json:"mill_rate"`
+	TravelRate   float64  `json:"travel_rate"`
+	// DispenseTime is a duration, like "50ms".
+	DispenseTime string  `json:"dispense_time"`
+	LaserCmd     string  `json:"laser_cmd"`
+	LaserPower   int     `json:"laser_power"`
+	Passes       int     `json:"passes"`
+	HatchSpacing float64 `json:"hatch_spacing"`
+	KnifeOffset  float64 `json:"knife_offset"`
+	KnifeAngle   float64 `json:"knife_angle"`
+}
+
+// DefaultParams returns the parameters with the defaults of the command line tool. As there,
+// the ones without a default are NaN, and Options fails unless they are set.
+func DefaultParams() Params {
+	o := NewOptions()
+	nan := math.NaN()
+	return Params{
+		PxSize:       nan,
+		ToolDiameter: nan,
+		MillHeight:   nan,
+		SafeHeight:   nan,
+		MillRate:     nan,
+		TravelRate:   nan,
+		HatchSpacing: nan,
+		KnifeOffset:  nan,
+		N:            o.Packing.N,
+		Background:   "black",
+		Search:       o.Packing.Search,
+		Order:        o.Order,
+		Mode:         o.Mode,
+		Dialect:      o.Machine.Dialect,
+		DispenseTime: o.Machine.DispenseTime.String(),
+		LaserCmd:     o.Machine.LaserCmd,
+		LaserPower:   o.Machine.LaserPower,
+		Passes:       o.Machine.Passes,
+		KnifeAngle:   o.KnifeAngle,
+	}
+}
+
+// Options returns the validated conversion options with the parameters, using jobs packing workers.
+func (p Params) Options(jobs int) (Options, error) {
+	var bk color.Color
+	switch p.Background {
+	case "black":
+		bk = color.Black
+	case "white":
+		bk = color.White
+	default:
+		return Options{}, fmt.Errorf("unknown color: %s", p.Background)
+	}
+	dt, err := time.ParseDuration(p.DispenseTime)
+	if err != nil {
+		return Options{}, fmt.Errorf("bad dispense_time: %w", err)
+	}
+	o := NewOptions(
+		WithBackground(bk),
+		WithPixelSize(p.PxSize),
+		WithSubpixels(p.N),
+		WithTool(p.ToolDiameter),
+		WithSearch(p.Search),
+		WithStrategies(p.Strategies...),
+		WithOrder(p.Order),
+		WithMode(p.Mode),
+		WithDialect(p.Dialect),
+		WithHeights(p.MillHeight, p.SafeHeight),
+		WithRates(p.MillRate, p.TravelRate),
+		WithDispenseTime(dt),
+		WithLaser(p.LaserPower, p.Passes, p.HatchSpacing),
+		WithKnife(p.KnifeOffset, p.KnifeAngle),
+		WithJobs(jobs))
+	o.Machine.LaserCmd = p.LaserCmd
+	if math.IsNaN(o.Machine.MillHeight) || math.IsNaN(o.Machine.SafeHeight) {
+		return Options{}, fmt.Errorf("mill_height and safe_height must be set")
+	}
+	if o.Machine.LaserCmd != gcode.LaserM3 && o.Machine.LaserCmd != gcode.LaserM106 {
+		return Options{}, fmt.Errorf("unknown laser_cmd: %s", o.Machine.LaserCmd)
+	}
+	if err := o.Validate(); err != nil {
+		return Options{}, err
+	}
+	if !(o.Machine.MillRate > 0) || !(o.Machine.TravelRate > 0) {
+		return Options{}, fmt.Errorf("mill_rate and travel_rate must be positive")
+	}
+	if o.Mode == ModeLaser && !(o.Machine.HatchSpacing > 0) {
+		return Options{}, fmt.Errorf("hatch_spacing must be positive in the laser mode")
+	}
+	if o.Mode == ModeKnife && !(o.KnifeOffset >= 0) {
+		return Options{}, fmt.Errorf("knife_offset must be set in the knife mode")
+	}
+	return o, nil
+}
+
+// Summary is the outcome of a conversion for the front ends reporting it as JSON.
+type Summary struct {
+	Mode      string `json:"mode"`
+	Apertures int    `json:"apertures"`
+	// Circles, Skipped, Enlarged, Coverage and Uncovered are of the circles of the dispense mode.
+	Circles   int     `json:"circles,omitempty"`
+	Skipped   int     `json:"skipped,omitempty"`
+	Enlarged  int     `json:"enlarged,omitempty"`
+	Coverage  float64 `json:"coverage,omitempty"`
+	Uncovered float64 `json:"uncovered,omitempty"`
+	// Paths and PathLength (in mm) are of the CutPaths of the laser and the knife modes.
+	Paths       int     `json:"paths,omitempty"`
+	PathLength  float64 `json:"path_length,omitempty"`
+	Interrupted bool    `json:"interrupted"`
+}
+
+// Summary returns the summary of the plan.
+func (p *Plan) Summary() Summary {
+	s := Summary{
+		Mode:        p.opts.Mode,
+		Apertures:   len(p.Stats.Apertures),
+		Interrupted: p.Interrupted,
+	}
+	if p.opts.Mode == ModeDispense {
+		s.Circles = p.Stats.Circles()
+		s.Skipped = len(p.Stats.Skipped())
+		s.Enlarged = len(p.Stats.Enlarged())
+		s.Coverage = p.Stats.Coverage()
+		s.Uncovered = p.Stats.Uncovered()
+		return s
+	}
+	for _, path := range p.CutPaths() {
+		s.Paths++
+		s.PathLength += geom.PathLength(path)
+	}
+	return s
+}
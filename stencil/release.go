@@ -0,0 +1,73 @@
+package stencil
+
+import (
+	"math"
+
+	"github.com/krasin/png2stencil/geom"
+	"github.com/krasin/png2stencil/stencilimg"
+)
+
+// The IPC-7525 thresholds of a good paste release.
+const (
+	// MinAreaRatio is the least ratio of the aperture opening area to its wall area.
+	MinAreaRatio = 0.66
+	// MinAspectRatio is the least ratio of the aperture width to the stencil thickness.
+	MinAspectRatio = 1.5
+)
+
+// Release describes how well the paste releases from an aperture, after IPC-7525.
+type Release struct {
+	// ID is the aperture ID, and At is its seed pixel (in the base image space).
+	ID int
+	At geom.Point
+	// Width and Length are the shorter and the longer sides of its bounding box, Area is
+	// the opening area and Perimeter is the wall length (in mm and mm²).
+	Width, Length, Area, Perimeter float64
+	// AreaRatio is Area / (Perimeter * thickness), and AspectRatio is Width / thickness.
+	AreaRatio, AspectRatio float64
+}
+
+// OK tells if the ratios are at least the recommended MinAreaRatio and MinAspectRatio.
+func (r *Release) OK() bool {
+	return r.AreaRatio >= MinAreaRatio && r.AspectRatio >= MinAspectRatio
+}
+
+// Release returns the paste release ratios of the apertures of the input, for a stencil of
+// the given thickness (in mm). The perimeter is the length of the pixel edges, which is longer
+// than the one of a smooth contour, so the area ratios of the round and the slanted apertures
+// are slightly underestimated.
+func (p *Plan) Release(thickness float64) []Release {
+	conn := p.opts.Packing.Connectivity
+	px := p.opts.Packing.PxSize
+	var res []Release
+	for i, r := range stencilimg.LabelRegions(p.src, conn) {
+		m := stencilimg.RegionMask(p.src, r, conn)
+		var area, edges int
+		for y := r.BBox.Min.Y; y <= r.BBox.Max.Y; y++ {
+			for x := r.BBox.Min.X; x <= r.BBox.Max.X; x++ {
+				if !m.Get(x, y) {
+					continue
+				}
+				area++
+				for _, d := range [4]geom.Point{{X: -1}, {X: 1}, {Y: -1}, {Y: 1}} {
+					if !m.Get(x+int(d.X), y+int(d.Y)) {
+						edges++
+					}
+				}
+			}
+		}
+		w, h := float64(r.BBox.Dx()+1)*px, float64(r.BBox.Dy()+1)*px
+		rel := Release{
+			ID:        i,
+			At:        geom.Pt(float64(r.Seed.X)*px, float64(r.Seed.Y)*px),
+			Width:     math.Min(w, h),
+			Length:    math.Max(w, h),
+			Area:      float64(area) * px * px,
+			Perimeter: float64(edges) * px,
+		}
+		rel.AreaRatio = rel.Area / (rel.Perimeter * thickness)
+		rel.AspectRatio = rel.Width / thickness
+		res = append(res, rel)
+	}
+	return res
+}
@@ -0,0 +1,86 @@
+package stencil
+
+import (
+	"github.com/krasin/png2stencil/packer"
+)
+
+// Aperture describes the packing result of a single connected component of the input.
+type Aperture struct {
+	ID      int
+	X, Y    int // seed pixel in the base image
+	Area    int // in base pixels
+	Covered int // base pixels covered by the circles
+	Circles int
+	// Strategy is the packing strategy of the circles, if any.
+	Strategy string
+}
+
+// NewAperture measures the packing of the component. It must be called before the component
+// pixels are released.
+func NewAperture(c *packer.Component, p packer.Packing) Aperture {
+	area, covered := c.Coverage(p.Centers)
+	return Aperture{ID: c.ID, X: c.Seed.X, Y: c.Seed.Y, Area: area, Covered: covered, Circles: len(p.Centers), Strategy: p.Strategy}
+}
+
+// Enlarged tells if the aperture is smaller than the tool, and plunged once anyway, see Options.EnlargeSmall.
+func (a *Aperture) Enlarged() bool {
+	return a.Strategy == StrategyEnlarged
+}
+
+// Stats collects the packing results of all apertures.
+type Stats struct {
+	Apertures []Aperture
+}
+
+// Skipped returns the apertures without any circles.
+func (st *Stats) Skipped() []Aperture {
+	var res []Aperture
+	for _, a := range st.Apertures {
+		if a.Circles == 0 {
+			res = append(res, a)
+		}
+	}
+	return res
+}
+
+// Enlarged returns the apertures enlarged to the tool.
+func (st *Stats) Enlarged() []Aperture {
+	var res []Aperture
+	for _, a := range st.Apertures {
+		if a.Enlarged() {
+			res = append(res, a)
+		}
+	}
+	return res
+}
+
+// Area returns the total area of the apertures, and how much of it the circles cover (in base pixels).
+func (st *Stats) Area() (area, covered int) {
+	for _, a := range st.Apertures {
+		area += a.Area
+		covered += a.Covered
+	}
+	return area, covered
+}
+
+// Coverage returns the share of all aperture pixels covered by the circles.
+func (st *Stats) Coverage() float64 {
+	area, covered := st.Area()
+	return ratio(covered, area)
+}
+
+// Uncovered returns the share of all aperture pixels left uncovered by the circles, 0 if there are
+// no apertures.
+func (st *Stats) Uncovered() float64 {
+	area, covered := st.Area()
+	return ratio(area-covered, area)
+}
+
+// Circles returns the total number of circles.
+func (st *Stats) Circles() int {
+	var res int
+	for _, a := range st.Apertures {
+		res += a.Circles
+	}
+	return res
+}
@@ -0,0 +1,80 @@
+package stencil
+
+import (
+	"math"
+	"sort"
+
+	"github.com/krasin/png2stencil/geom"
+	"github.com/krasin/png2stencil/stencilimg"
+)
+
+// Web is the material left between two apertures, where it's the thinnest.
+type Web struct {
+	// A and B are the apertures, by their IDs.
+	A, B int
+	// At is the middle of the web (in the base image space), and Width is its width (in mm).
+	At    geom.Point
+	Width float64
+}
+
+// edgePixels returns the pixels of the region which have a neighbor outside of it.
+func edgePixels(src *stencilimg.BitMask, r stencilimg.Region, conn stencilimg.Connectivity) []geom.Point {
+	m := stencilimg.RegionMask(src, r, conn)
+	var res []geom.Point
+	for y := r.BBox.Min.Y; y <= r.BBox.Max.Y; y++ {
+		for x := r.BBox.Min.X; x <= r.BBox.Max.X; x++ {
+			if m.Get(x, y) && !(m.Get(x-1, y) && m.Get(x+1, y) && m.Get(x, y-1) && m.Get(x, y+1)) {
+				res = append(res, geom.Pt(float64(x), float64(y)))
+			}
+		}
+	}
+	return res
+}
+
+// ThinWebs returns the webs between the apertures narrower than minWidth (in mm), where a stencil
+// tears, ordered by the width. The apertures are the ones of the input, at its resolution; the
+// width is measured between the pixel edges.
+func (p *Plan) ThinWebs(minWidth float64) []Web {
+	conn := p.opts.Packing.Connectivity
+	px := p.opts.Packing.PxSize
+	// The bounding boxes farther than minWidth apart can't have a thin web between them.
+	gap := int(math.Ceil(minWidth/px)) + 1
+	// The regions are in the order of the aperture IDs, which is the order of their left edges too,
+	// since the seeds are the first pixels in the column-major order.
+	regs := stencilimg.LabelRegions(p.src, conn)
+	edges := make([][]geom.Point, len(regs))
+	edge := func(i int) []geom.Point {
+		if edges[i] == nil {
+			edges[i] = edgePixels(p.src, regs[i], conn)
+		}
+		return edges[i]
+	}
+	var res []Web
+	for i, a := range regs {
+		for j := i + 1; j < len(regs); j++ {
+			b := regs[j]
+			if b.BBox.Min.X > a.BBox.Max.X+gap {
+				break
+			}
+			if b.BBox.Min.Y > a.BBox.Max.Y+gap || a.BBox.Min.Y > b.BBox.Max.Y+gap {
+				continue
+			}
+			best := Web{Width: math.Inf(1)}
+			for _, pa := range edge(i) {
+				for _, pb := range edge(j) {
+					dx := math.Max(math.Abs(pa.X-pb.X)-1, 0)
+					dy := math.Max(math.Abs(pa.Y-pb.Y)-1, 0)
+					if w := math.Hypot(dx, dy) * px; w < best.Width {
+						best = Web{At: geom.Pt((pa.X+pb.X+1)/2*px, (pa.Y+pb.Y+1)/2*px), Width: w}
+					}
+				}
+			}
+			if best.Width < minWidth {
+				best.A, best.B = i, j
+				res = append(res, best)
+			}
+		}
+	}
+	sort.SliceStable(res, func(i, j int) bool { return res[i].Width < res[j].Width })
+	return res
+}
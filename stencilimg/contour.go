@@ -0,0 +1,127 @@
+package stencilimg
+
+import (
+	"math"
+
+	"github.com/krasin/png2stencil/geom"
+)
+
+type gridPoint struct {
+	X, Y int
+}
+
+type gridEdge struct {
+	From, To gridPoint
+}
+
+// TraceContours returns the closed boundaries of all non-background regions of the base image
+// as polygons in the base image space (in mm). Each boundary is traced along the pixel edges,
+// with the region on the right hand side in the image space. The base image space starts at
+// the top left corner of the base, whatever its Bounds().Min is.
+func TraceContours(base PixelMask, pxSize float64) [][]geom.Point {
+	b := base.Bounds()
+	w, h := b.Dx(), b.Dy()
+	fg := func(x, y int) bool { return base.Get(b.Min.X+x, b.Min.Y+y) }
+
+	out := make(map[gridPoint][]gridEdge)
+	var order []gridPoint
+	addEdge := func(a, b gridPoint) {
+		if len(out[a]) == 0 {
+			order = append(order, a)
+		}
+		out[a] = append(out[a], gridEdge{a, b})
+	}
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			if !fg(x, y) {
+				continue
+			}
+			if !fg(x, y-1) {
+				addEdge(gridPoint{x, y}, gridPoint{x + 1, y})
+			}
+			if !fg(x+1, y) {
+				addEdge(gridPoint{x + 1, y}, gridPoint{x + 1, y + 1})
+			}
+			if !fg(x, y+1) {
+				addEdge(gridPoint{x + 1, y + 1}, gridPoint{x, y + 1})
+			}
+			if !fg(x-1, y) {
+				addEdge(gridPoint{x, y + 1}, gridPoint{x, y})
+			}
+		}
+	}
+
+	var res [][]geom.Point
+	for _, start := range order {
+		for len(out[start]) > 0 {
+			var poly []geom.Point
+			cur := start
+			var dir gridPoint
+			for {
+				edges := out[cur]
+				if len(edges) == 0 {
+					break
+				}
+				// At saddle vertices, prefer turning right, so diagonal pixels are not joined.
+				k := 0
+				for i, e := range edges {
+					d := gridPoint{e.To.X - e.From.X, e.To.Y - e.From.Y}
+					if d.X == -dir.Y && d.Y == dir.X {
+						k = i
+					}
+				}
+				e := edges[k]
+				out[cur] = append(edges[:k], edges[k+1:]...)
+				poly = append(poly, geom.Pt(float64(cur.X)*pxSize, float64(cur.Y)*pxSize))
+				dir = gridPoint{e.To.X - e.From.X, e.To.Y - e.From.Y}
+				cur = e.To
+			}
+			res = append(res, simplifyPolygon(poly, 0.75*pxSize))
+		}
+	}
+	return res
+}
+
+func segmentDist(p, a, b geom.Point) float64 {
+	dx, dy := b.X-a.X, b.Y-a.Y
+	l2 := dx*dx + dy*dy
+	if l2 == 0 {
+		return math.Hypot(p.X-a.X, p.Y-a.Y)
+	}
+	t := math.Max(0, math.Min(1, ((p.X-a.X)*dx+(p.Y-a.Y)*dy)/l2))
+	return math.Hypot(p.X-a.X-t*dx, p.Y-a.Y-t*dy)
+}
+
+// simplifyPath is the Douglas-Peucker polyline simplification with the given tolerance.
+func simplifyPath(pts []geom.Point, tol float64) []geom.Point {
+	if len(pts) < 3 {
+		return pts
+	}
+	k, best := 0, 0.0
+	for i := 1; i < len(pts)-1; i++ {
+		if d := segmentDist(pts[i], pts[0], pts[len(pts)-1]); d > best {
+			k, best = i, d
+		}
+	}
+	if best <= tol {
+		return []geom.Point{pts[0], pts[len(pts)-1]}
+	}
+	left := simplifyPath(pts[:k+1], tol)
+	return append(left[:len(left)-1], simplifyPath(pts[k:], tol)...)
+}
+
+// simplifyPolygon simplifies a closed polygon, which is split at its farthest vertex from the start.
+func simplifyPolygon(poly []geom.Point, tol float64) []geom.Point {
+	if len(poly) < 4 {
+		return poly
+	}
+	k, best := 0, 0.0
+	for i, p := range poly {
+		if d := math.Hypot(p.X-poly[0].X, p.Y-poly[0].Y); d > best {
+			k, best = i, d
+		}
+	}
+	a := simplifyPath(poly[:k+1], tol)
+	b := simplifyPath(append(append([]geom.Point{}, poly[k:]...), poly[0]), tol)
+	return append(a[:len(a)-1], b[:len(b)-1]...)
+}
@@ -0,0 +1,202 @@
+package stencilimg
+
+import (
+	"image"
+	"sort"
+	"sync"
+)
+
+// unionFind is a disjoint set forest over the provisional labels.
+type unionFind []int32
+
+func (u unionFind) find(x int32) int32 {
+	for u[x] != x {
+		u[x] = u[u[x]]
+		x = u[x]
+	}
+	return x
+}
+
+func (u unionFind) union(a, b int32) {
+	a, b = u.find(a), u.find(b)
+	if a < b {
+		u[b] = a
+	} else if b < a {
+		u[a] = b
+	}
+}
+
+// Connectivity tells which neighbors of a pixel are in the same connected component.
+type Connectivity int
+
+const (
+	// Connect4 joins the pixels sharing a side. It's the default, so the zero value means it too.
+	Connect4 Connectivity = 4
+	// Connect8 joins the pixels sharing a side or a corner, so the diagonally touching pads merge.
+	Connect8 Connectivity = 8
+)
+
+// Region is the extent of a connected component of a mask.
+type Region struct {
+	// BBox is the bounding box with the inclusive Max.
+	BBox image.Rectangle
+	// Seed is the first pixel of the component in the column-major order.
+	Seed image.Point
+}
+
+func (r *Region) add(p image.Point) {
+	r.BBox.Min.X = imin(r.BBox.Min.X, p.X)
+	r.BBox.Min.Y = imin(r.BBox.Min.Y, p.Y)
+	r.BBox.Max.X = imax(r.BBox.Max.X, p.X)
+	r.BBox.Max.Y = imax(r.BBox.Max.Y, p.Y)
+	if p.X < r.Seed.X || p.X == r.Seed.X && p.Y < r.Seed.Y {
+		r.Seed = p
+	}
+}
+
+// merge joins the other region of the same component. The corners of its bounding box are
+// not necessarily its pixels, so they only extend the box, not the seed.
+func (r *Region) merge(o Region) {
+	seed := r.Seed
+	r.add(o.BBox.Min)
+	r.add(o.BBox.Max)
+	r.Seed = seed
+	r.add(o.Seed)
+}
+
+// LabelRegions finds the connected components of the set pixels of the mask in a single
+// raster scan, merging the provisional labels with union-find. Only two rows of labels are
+// kept at a time, so the memory does not depend on the image height; a component is only
+// described by its extent, and its pixels can be recovered with RegionMask.
+// The regions are returned in the order of their seeds in the column-major scan, which is
+// the order the components have always been milled in. The regions are in the coordinates
+// of the mask, whatever its Bounds().Min is.
+func LabelRegions(m *BitMask, conn Connectivity) []Region {
+	b := m.Bounds()
+	w, h := b.Dx(), b.Dy()
+	prev := make([]int32, w)
+	cur := make([]int32, w)
+	uf := unionFind{0}
+	regs := []Region{{}}
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			if !m.Get(b.Min.X+x, b.Min.Y+y) {
+				cur[x] = 0
+				continue
+			}
+			var left int32
+			if x > 0 {
+				left = cur[x-1]
+			}
+			up := prev[x]
+			if conn == Connect8 {
+				// The diagonal neighbors above are joined as well; the one above is joined to both already.
+				if up == 0 && x > 0 {
+					up = prev[x-1]
+				}
+				if x+1 < w && prev[x+1] != 0 {
+					if up == 0 {
+						up = prev[x+1]
+					} else {
+						uf.union(up, prev[x+1])
+					}
+				}
+			}
+			var l int32
+			switch {
+			case left == 0 && up == 0:
+				l = int32(len(uf))
+				uf = append(uf, l)
+				p := b.Min.Add(image.Pt(x, y))
+				regs = append(regs, Region{BBox: image.Rectangle{Min: p, Max: p}, Seed: p})
+			case left == 0:
+				l = up
+			case up == 0:
+				l = left
+			default:
+				l = left
+				uf.union(left, up)
+			}
+			cur[x] = l
+			regs[l].add(b.Min.Add(image.Pt(x, y)))
+		}
+		prev, cur = cur, prev
+	}
+
+	var roots []int32
+	for l := int32(1); l < int32(len(uf)); l++ {
+		if r := uf.find(l); r != l {
+			regs[r].merge(regs[l])
+		} else {
+			roots = append(roots, l)
+		}
+	}
+	res := make([]Region, len(roots))
+	for i, r := range roots {
+		res[i] = regs[r]
+	}
+	sort.Slice(res, func(i, j int) bool {
+		a, b := res[i].Seed, res[j].Seed
+		return a.X < b.X || a.X == b.X && a.Y < b.Y
+	})
+	return res
+}
+
+// fillSpan is a run of the filled pixels in a row, from x0 to x1 inclusive; the rows next to it
+// are yet to be scanned.
+type fillSpan struct {
+	x0, x1, y int
+}
+
+// fillStacks keeps the flood fill stacks, so they are reused across the components and workers.
+var fillStacks = sync.Pool{New: func() interface{} { return new([]fillSpan) }}
+
+// RegionMask returns the pixels of the component of m containing the region seed, as a mask
+// with the region bounds, with the connectivity it was labeled with. The component is connected
+// inside of its bounding box, so the flood fill never needs to look outside of it. The fill works
+// on the runs of pixels, so it pushes a span per run instead of a point per pixel.
+func RegionMask(m *BitMask, r Region, conn Connectivity) *BitMask {
+	b := r.BBox
+	res := NewBitMask(image.Rect(b.Min.X, b.Min.Y, b.Max.X+1, b.Max.Y+1))
+	sp := fillStacks.Get().(*[]fillSpan)
+	stack := (*sp)[:0]
+	stack = append(stack, fillRun(m, res, r.Seed.X, r.Seed.Y))
+	for len(stack) > 0 {
+		s := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		for _, y := range [2]int{s.y - 1, s.y + 1} {
+			if y < b.Min.Y || y > b.Max.Y {
+				continue
+			}
+			x0, x1 := s.x0, s.x1
+			if conn == Connect8 {
+				x0, x1 = imax(x0-1, b.Min.X), imin(x1+1, b.Max.X)
+			}
+			for x := x0; x <= x1; x++ {
+				if m.Get(x, y) && !res.Get(x, y) {
+					run := fillRun(m, res, x, y)
+					stack = append(stack, run)
+					x = run.x1
+				}
+			}
+		}
+	}
+	*sp = stack
+	fillStacks.Put(sp)
+	return res
+}
+
+// fillRun fills the run of the set pixels of m through (x, y) in res, and returns it.
+func fillRun(m, res *BitMask, x, y int) fillSpan {
+	x0, x1 := x, x
+	for x0 > res.rect.Min.X && m.Get(x0-1, y) && !res.Get(x0-1, y) {
+		x0--
+	}
+	for x1 < res.rect.Max.X-1 && m.Get(x1+1, y) && !res.Get(x1+1, y) {
+		x1++
+	}
+	for x := x0; x <= x1; x++ {
+		res.Set(x, y)
+	}
+	return fillSpan{x0, x1, y}
+}
@@ -0,0 +1,75 @@
+package stencilimg
+
+import (
+	"image"
+	"image/color"
+)
+
+// Threshold returns the mask of the pixels of the input image which differ from the background
+// color, with the origin at (0, 0). The image package does not have a bit image, but at high
+// subpixel counts a gray-scale image takes too much memory, see BitMask.
+func Threshold(in image.Image, bk color.Color) *BitMask {
+	bkr, bkg, bkb, _ := bk.RGBA()
+
+	x0 := in.Bounds().Min.X
+	y0 := in.Bounds().Min.Y
+	src := NewBitMask(image.Rect(0, 0, in.Bounds().Dx(), in.Bounds().Dy()))
+	for y := 0; y < in.Bounds().Dy(); y++ {
+		for x := 0; x < in.Bounds().Dx(); x++ {
+			cr, cg, cb, _ := in.At(x0+x, y0+y).RGBA()
+			if bkr != cr || bkg != cg || bkb != cb {
+				src.Set(x, y)
+			}
+		}
+	}
+	return src
+}
+
+// PartialPixels counts the pixels of the input image which differ from the background color,
+// and how many of them differ less than half as much as the most different pixel, like the
+// anti-aliased edges of the apertures. The difference is the largest one of the color channels.
+func PartialPixels(in image.Image, bk color.Color) (partial, total int) {
+	bkr, bkg, bkb, _ := bk.RGBA()
+	diff := func(a, b uint32) int {
+		if a > b {
+			return int(a - b)
+		}
+		return int(b - a)
+	}
+	// hist counts the pixels by the high byte of their difference.
+	var hist [256]int
+	b := in.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			cr, cg, cb, _ := in.At(x, y).RGBA()
+			d := imax(diff(bkr, cr), imax(diff(bkg, cg), diff(bkb, cb)))
+			if d == 0 {
+				continue
+			}
+			hist[d>>8]++
+			total++
+		}
+	}
+	top := 255
+	for top > 0 && hist[top] == 0 {
+		top--
+	}
+	for d := 0; d < (top+1)/2; d++ {
+		partial += hist[d]
+	}
+	return partial, total
+}
+
+func imin(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func imax(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"math"
+	"sort"
+
+	"github.com/krasin/png2stencil/geom"
+	"github.com/krasin/png2stencil/stencilimg"
+)
+
+type triangle struct {
+	Normal geom.Vec3
+	V      [3]geom.Vec3
+}
+
+// mesh accumulates triangles of the stencil plate.
+type mesh struct {
+	tris []triangle
+}
+
+// add adds a triangle, fixing its winding so that the normal points along want.
+func (m *mesh) add(a, b, c, want geom.Vec3) {
+	if b.Sub(a).Cross(c.Sub(a)).Dot(want) < 0 {
+		b, c = c, b
+	}
+	m.tris = append(m.tris, triangle{Normal: want, V: [3]geom.Vec3{a, b, c}})
+}
+
+// quad adds a planar quad a-b-c-d as two triangles.
+func (m *mesh) quad(a, b, c, d, want geom.Vec3) {
+	m.add(a, b, c, want)
+	m.add(a, c, d, want)
+}
+
+type run struct {
+	a, b int
+}
+
+// solidRuns returns the horizontal runs of the material (not cut) cells in each row.
+func solidRuns(w, h int, cut []bool) [][]run {
+	runs := make([][]run, h)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; {
+			if cut[y*w+x] {
+				x++
+				continue
+			}
+			a := x
+			for x < w && !cut[y*w+x] {
+				x++
+			}
+			runs[y] = append(runs[y], run{a, x})
+		}
+	}
+	return runs
+}
+
+// breakpoints returns the sorted unique run ends of the two rows adjacent to a grid line.
+func breakpoints(above, below []run) []int {
+	var pts []int
+	for _, r := range above {
+		pts = append(pts, r.a, r.b)
+	}
+	for _, r := range below {
+		pts = append(pts, r.a, r.b)
+	}
+	sort.Ints(pts)
+	var res []int
+	for i, p := range pts {
+		if i == 0 || p != pts[i-1] {
+			res = append(res, p)
+		}
+	}
+	return res
+}
+
+func within(pts []int, a, b int) []int {
+	i := sort.SearchInts(pts, a)
+	j := sort.SearchInts(pts, b+1)
+	return pts[i:j]
+}
+
+func covers(runs []run, a, b int) bool {
+	for _, r := range runs {
+		if r.a <= a && b <= r.b {
+			return true
+		}
+	}
+	return false
+}
+
+// removeSaddles cuts one more cell at every grid vertex where two solid cells touch
+// only diagonally, since such vertices would make the mesh non-manifold.
+func removeSaddles(w, h int, cut []bool) {
+	for changed := true; changed; {
+		changed = false
+		for y := 0; y+1 < h; y++ {
+			for x := 0; x+1 < w; x++ {
+				a, b := cut[y*w+x], cut[y*w+x+1]
+				c, d := cut[(y+1)*w+x], cut[(y+1)*w+x+1]
+				if a == d && b == c && a != b {
+					if a {
+						cut[y*w+x+1] = true
+					} else {
+						cut[y*w+x] = true
+					}
+					changed = true
+				}
+			}
+		}
+	}
+}
+
+// stencilMesh builds a watertight mesh of a w*h cell plate with the cut cells removed.
+// Horizontal faces are merged per row run, and every edge shared between faces is split
+// at the same points, so the mesh has no T-junctions.
+func stencilMesh(w, h int, pxSize, thick float64, cut []bool) *mesh {
+	removeSaddles(w, h, cut)
+	runs := solidRuns(w, h, cut)
+	row := func(y int) []run {
+		if y < 0 || y >= h {
+			return nil
+		}
+		return runs[y]
+	}
+	lines := make([][]int, h+1)
+	for l := 0; l <= h; l++ {
+		lines[l] = breakpoints(row(l-1), row(l))
+	}
+
+	// Grid line l is at the image Y = l, which is flipped to the machine space.
+	at := func(x, l int, z float64) geom.Vec3 {
+		p := toMachine(geom.Pt(float64(x)*pxSize, float64(l)*pxSize))
+		return geom.Vec(p.X, p.Y, z)
+	}
+	up := geom.Vec(0, 0, 1)
+	down := geom.Vec(0, 0, -1)
+	m := &mesh{}
+
+	for y := 0; y < h; y++ {
+		for _, r := range runs[y] {
+			top := within(lines[y], r.a, r.b)
+			bottom := within(lines[y+1], r.a, r.b)
+			for _, z := range []float64{0, thick} {
+				want := up
+				if z == 0 {
+					want = down
+				}
+				// Triangulate the strip between the two chains of points.
+				i, j := 0, 0
+				for i < len(top)-1 || j < len(bottom)-1 {
+					if j == len(bottom)-1 || (i < len(top)-1 && top[i+1] <= bottom[j+1]) {
+						m.add(at(top[i], y, z), at(top[i+1], y, z), at(bottom[j], y+1, z), want)
+						i++
+					} else {
+						m.add(at(top[i], y, z), at(bottom[j+1], y+1, z), at(bottom[j], y+1, z), want)
+						j++
+					}
+				}
+			}
+			// Vertical walls at the run ends.
+			m.quad(at(r.a, y, 0), at(r.a, y+1, 0), at(r.a, y+1, thick), at(r.a, y, thick), geom.Vec(-1, 0, 0))
+			m.quad(at(r.b, y, 0), at(r.b, y+1, 0), at(r.b, y+1, thick), at(r.b, y, thick), geom.Vec(1, 0, 0))
+		}
+	}
+
+	// Horizontal walls along the grid lines, where exactly one of the adjacent rows is solid.
+	for l := 0; l <= h; l++ {
+		pts := lines[l]
+		for k := 0; k+1 < len(pts); k++ {
+			a, b := pts[k], pts[k+1]
+			above := covers(row(l-1), a, b)
+			below := covers(row(l), a, b)
+			if above == below {
+				continue
+			}
+			// The image Y grows down, but the machine Y grows up.
+			want := geom.Vec(0, 1, 0)
+			if above {
+				want = geom.Vec(0, -1, 0)
+			}
+			m.quad(at(a, l, 0), at(b, l, 0), at(b, l, thick), at(a, l, thick), want)
+		}
+	}
+	return m
+}
+
+func writeSTL(out io.Writer, m *mesh) error {
+	w := bufio.NewWriter(out)
+
+	var header [80]byte
+	copy(header[:], "png2stencil")
+	w.Write(header[:])
+	binary.Write(w, binary.LittleEndian, uint32(len(m.tris)))
+	buf := make([]byte, 50)
+	for _, t := range m.tris {
+		vals := []float64{t.Normal.X, t.Normal.Y, t.Normal.Z}
+		for _, v := range t.V {
+			vals = append(vals, v.X, v.Y, v.Z)
+		}
+		for i, v := range vals {
+			binary.LittleEndian.PutUint32(buf[i*4:], math.Float32bits(float32(v)))
+		}
+		w.Write(buf)
+	}
+	return w.Flush()
+}
+
+// saveSTL saves a stencil plate of the base image size with the apertures of the base image as
+// through-holes, exactly as in the mask.
+func saveSTL(name string, base stencilimg.PixelMask) error {
+	basePxSize := *pxSize / float64(*n)
+	b := base.Bounds()
+	w, h := b.Dx(), b.Dy()
+	cut := make([]bool, w*h)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			cut[y*w+x] = base.Get(b.Min.X+x, b.Min.Y+y)
+		}
+	}
+	err := writeFile(name, func(out io.Writer) error {
+		return writeSTL(out, stencilMesh(w, h, basePxSize, *thickness, cut))
+	})
+	if err != nil {
+		return errorf(exitWriteFailed, "failed to save STL file %q: %w", name, err)
+	}
+	return nil
+}
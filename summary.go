@@ -0,0 +1,49 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/krasin/png2stencil/gcode"
+)
+
+type summaryBBox struct {
+	MinX float64 `json:"min_x"`
+	MinY float64 `json:"min_y"`
+	MaxX float64 `json:"max_x"`
+	MaxY float64 `json:"max_y"`
+}
+
+// runSummary is printed to stdout as a single JSON line on success, for wrapper scripts.
+type runSummary struct {
+	Output           string      `json:"output"`
+	Mode             string      `json:"mode"`
+	Holes            int         `json:"holes"`
+	Paths            int         `json:"paths"`
+	Lines            int         `json:"lines"`
+	EstimatedSeconds float64     `json:"estimated_seconds"`
+	BBox             summaryBBox `json:"bbox"`
+}
+
+// printSummary prints the summary of the run writing the named G-code: holes is the number of plunges
+// in the dispense mode, and paths the number of the cut paths in the others, see cutPaths.
+func printSummary(name string, lines int, st gcode.Stats, holes, paths int) error {
+	s := runSummary{
+		Output:           name,
+		Mode:             *mode,
+		Holes:            holes,
+		Paths:            paths,
+		Lines:            lines,
+		EstimatedSeconds: st.Seconds,
+	}
+	if st.Moves > 0 {
+		s.BBox = summaryBBox{st.Min.X, st.Min.Y, st.Max.X, st.Max.Y}
+	}
+	data, err := json.Marshal(s)
+	if err != nil {
+		return fmt.Errorf("failed to marshal the run summary: %w", err)
+	}
+	fmt.Fprintln(os.Stdout, string(data))
+	return nil
+}
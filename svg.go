@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+
+	"github.com/krasin/png2stencil/geom"
+	"github.com/krasin/png2stencil/stencilimg"
+)
+
+// writeSVG writes an SVG preview of the apertures, the milled circles and the toolpath, with
+// the same colors as the PDF one. The SVG user unit is 1 mm, and the points are in the base image
+// space, since SVG has Y pointing down too. width and height are the image size (in mm).
+func writeSVG(out io.Writer, width, height float64, contours [][]geom.Point, centers []geom.Point, r float64, paths [][]geom.Point) error {
+	w := bufio.NewWriter(out)
+	fmt.Fprintf(w, "<svg xmlns=\"http://www.w3.org/2000/svg\" width=\"%.4fmm\" height=\"%.4fmm\" viewBox=\"0 0 %.4f %.4f\">\n",
+		width, height, width, height)
+	fmt.Fprintf(w, "<rect width=\"%.4f\" height=\"%.4f\" fill=\"none\" stroke=\"#999\" stroke-width=\"0.05\"/>\n", width, height)
+	polyline := func(pts []geom.Point, closed bool) {
+		for i, p := range pts {
+			cmd := "L"
+			if i == 0 {
+				cmd = "M"
+			}
+			fmt.Fprintf(w, "%s%.4f %.4f", cmd, p.X, p.Y)
+		}
+		if closed {
+			w.WriteString("Z")
+		}
+	}
+
+	if len(contours) > 0 {
+		w.WriteString("<path fill=\"#d9d9d9\" fill-rule=\"evenodd\" d=\"")
+		for _, poly := range contours {
+			polyline(poly, true)
+		}
+		w.WriteString("\"/>\n")
+	}
+	w.WriteString("<g fill=\"none\" stroke=\"red\" stroke-width=\"0.05\">\n")
+	for _, p := range centers {
+		fmt.Fprintf(w, "<circle cx=\"%.4f\" cy=\"%.4f\" r=\"%.4f\"/>\n", p.X, p.Y, r)
+	}
+	w.WriteString("</g>\n")
+	w.WriteString("<g fill=\"none\" stroke=\"blue\" stroke-width=\"0.02\">\n")
+	for _, path := range paths {
+		w.WriteString("<path d=\"")
+		polyline(path, false)
+		w.WriteString("\"/>\n")
+	}
+	w.WriteString("</g>\n</svg>\n")
+	return w.Flush()
+}
+
+// writePreviewSVG writes the SVG preview of the base image with the pixel size (in mm). As in
+// the PDF one, if there are no cutting paths, the toolpath is the travel between the milled circles.
+func writePreviewSVG(w io.Writer, base stencilimg.PixelMask, pxSize float64, centers []geom.Point, r float64, paths [][]geom.Point) error {
+	if paths == nil && len(centers) > 1 {
+		paths = [][]geom.Point{centers}
+	}
+	contours := stencilimg.TraceContours(base, pxSize)
+	width := float64(base.Bounds().Dx()) * pxSize
+	height := float64(base.Bounds().Dy()) * pxSize
+	return writeSVG(w, width, height, contours, centers, r, paths)
+}
+
+// saveSVG saves the SVG preview.
+func saveSVG(name string, base stencilimg.PixelMask, centers []geom.Point, paths [][]geom.Point) error {
+	err := writeFile(name, func(w io.Writer) error {
+		return writePreviewSVG(w, base, *pxSize/float64(*n), centers, cutDiameter()/2, paths)
+	})
+	if err != nil {
+		return errorf(exitWriteFailed, "failed to save SVG file %q: %w", name, err)
+	}
+	return nil
+}
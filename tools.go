@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// tool is a named tool of the --tools library.
+type tool struct {
+	Name string
+	// Diameter is the cutting diameter (in mm).
+	Diameter float64
+	// Flutes is the number of cutting edges.
+	Flutes int
+	// MaxPlungeRate is the fastest Z feed into the material (in mm/min).
+	MaxPlungeRate float64
+	// RPM is the recommended spindle speed.
+	RPM float64
+	// ChipLoad is the recommended feed per flute (in mm), 0 if not known.
+	ChipLoad float64
+}
+
+// loadTools reads the tool library: a TOML file with a table per tool, like
+//
+//	[flat-0.4]
+//	diameter = 0.4
+//	flutes = 2
+//	max_plunge_rate = 100
+//	rpm = 12000
+//	chip_load = 0.005
+//
+// The chip load is optional. The tools are returned by their names.
+func loadTools(name string) (map[string]*tool, error) {
+	vals, err := loadConfig(name)
+	if err != nil {
+		return nil, err
+	}
+	tools := make(map[string]*tool)
+	var keys []string
+	for k := range vals {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		// The tool names may have dots, as in flat-0.4, but the keys don't.
+		i := strings.LastIndexByte(k, '.')
+		if i < 0 {
+			return nil, errorf(exitBadFlags, "%s in %s is not in a tool table", k, name)
+		}
+		t := tools[k[:i]]
+		if t == nil {
+			t = &tool{Name: k[:i]}
+			tools[t.Name] = t
+		}
+		v, err := strconv.ParseFloat(vals[k], 64)
+		if err != nil {
+			return nil, errorf(exitBadFlags, "invalid %s in %s: %w", k, name, err)
+		}
+		switch k[i+1:] {
+		case "diameter":
+			t.Diameter = v
+		case "flutes":
+			t.Flutes = int(v)
+		case "max_plunge_rate":
+			t.MaxPlungeRate = v
+		case "rpm":
+			t.RPM = v
+		case "chip_load":
+			t.ChipLoad = v
+		default:
+			return nil, errorf(exitBadFlags, "unknown tool parameter %s in %s", k, name)
+		}
+	}
+	for _, t := range tools {
+		if t.Diameter <= 0 || t.Flutes <= 0 || t.MaxPlungeRate <= 0 || t.RPM <= 0 {
+			return nil, errorf(exitBadFlags, "tool %s in %s needs a positive diameter, flutes, max_plunge_rate and rpm", t.Name, name)
+		}
+	}
+	return tools, nil
+}
+
+// feedRate returns the recommended mill rate of the tool (in mm/min): the chip load feed,
+// if it's known, but never faster than the plunge, since the mill rate is the plunge rate too.
+func (t *tool) feedRate() float64 {
+	if t.ChipLoad <= 0 {
+		return t.MaxPlungeRate
+	}
+	return math.Min(t.RPM*float64(t.Flutes)*t.ChipLoad, t.MaxPlungeRate)
+}
+
+// applyTool sets --tool_diameter and --mill_rate from the --tool in the --tools library, unless
+// they are already set, and fails if the --mill_rate plunges the tool faster than it can take.
+func applyTool() error {
+	if *toolsFile == "" {
+		return errorf(exitBadFlags, "--tool needs the --tools library")
+	}
+	tools, err := loadTools(*toolsFile)
+	if err != nil {
+		return err
+	}
+	t, ok := tools[*toolName]
+	if !ok {
+		return errorf(exitBadFlags, "unknown tool %s in %s", *toolName, *toolsFile)
+	}
+	err = setFlags(map[string]string{
+		"tool_diameter": fmt.Sprint(t.Diameter),
+		"mill_rate":     fmt.Sprint(t.feedRate()),
+	}, "tool "+t.Name)
+	if err != nil {
+		return err
+	}
+	if *millRate > t.MaxPlungeRate {
+		return errorf(exitBadFlags, "--mill_rate=%v exceeds the max plunge rate %v of the tool %s", *millRate, t.MaxPlungeRate, t.Name)
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/krasin/png2stencil/gcode"
+	"github.com/krasin/png2stencil/geom"
+	"github.com/krasin/png2stencil/stencil"
+)
+
+// maxReportedViolations limits how many violations are reported.
+const maxReportedViolations = 20
+
+// checkGCode returns an error listing the violations if the verified program breaks any invariant.
+func checkGCode(v *gcode.Verifier) error {
+	vs := v.Violations
+	if len(vs) == 0 {
+		return nil
+	}
+	var msgs []string
+	for i, v := range vs {
+		if i == maxReportedViolations {
+			msgs = append(msgs, fmt.Sprintf("... and %d more", len(vs)-i))
+			break
+		}
+		msgs = append(msgs, "  "+v.String())
+	}
+	return errorf(exitVerifyFailed, "generated G-code failed verification:\n%s", strings.Join(msgs, "\n"))
+}
+
+// checkLimits returns an error listing the moves of the checked program which exceed the machine travel limits.
+func checkLimits(c *gcode.LimitChecker) error {
+	vs := c.Violations
+	if len(vs) == 0 {
+		return nil
+	}
+	var msgs []string
+	for i, v := range vs {
+		if i == maxReportedViolations {
+			msgs = append(msgs, fmt.Sprintf("... and %d more", len(vs)-i))
+			break
+		}
+		msgs = append(msgs, fmt.Sprintf("  %v: %s", v, v.Code))
+	}
+	return errorf(exitLimits, "%d moves exceed the machine travel limits:\n%s", len(vs), strings.Join(msgs, "\n"))
+}
+
+// checkSheet returns an error if the stencil bounds lo and hi (in the machine space, as designed,
+// before the --fiducials), like the ones of stencilBounds, don't fit the --sheet_x by --sheet_y blank
+// from the machine origin, if they are set.
+func checkSheet(lo, hi geom.Point) error {
+	var msgs []string
+	if !math.IsNaN(*sheetX) && (lo.X < 0 || hi.X > *sheetX) {
+		msgs = append(msgs, fmt.Sprintf("X%.3f - X%.3f is out of --sheet_x=%g", lo.X, hi.X, *sheetX))
+	}
+	if !math.IsNaN(*sheetY) && (lo.Y < 0 || hi.Y > *sheetY) {
+		msgs = append(msgs, fmt.Sprintf("Y%.3f - Y%.3f is out of --sheet_y=%g", lo.Y, hi.Y, *sheetY))
+	}
+	if len(msgs) == 0 {
+		return nil
+	}
+	return errorf(exitLimits, "the stencil doesn't fit the sheet: %s", strings.Join(msgs, ", "))
+}
+
+// checkWebs returns an error listing the webs between the apertures of the plan thinner than --min_web,
+// if it's set.
+func checkWebs(plan *stencil.Plan) error {
+	if !(*minWeb > 0) {
+		return nil
+	}
+	webs := plan.ThinWebs(*minWeb)
+	if len(webs) == 0 {
+		return nil
+	}
+	var msgs []string
+	for i, w := range webs {
+		if i == maxReportedViolations {
+			msgs = append(msgs, fmt.Sprintf("... and %d more", len(webs)-i))
+			break
+		}
+		p := plan.Frame.ToMachine(w.At)
+		msgs = append(msgs, fmt.Sprintf("  apertures %d and %d: %.3f mm at X%.3f Y%.3f", w.A, w.B, w.Width, p.X, p.Y))
+	}
+	return errorf(exitVerifyFailed, "%d webs between the apertures are thinner than --min_web=%v mm:\n%s", len(webs), *minWeb, strings.Join(msgs, "\n"))
+}
+
+// checkUncovered returns an error listing the apertures with the most uncovered area if the circles
+// of the plan leave more than --max_uncovered of the aperture area uncovered. Only the dispense mode
+// is checked, as the laser and the knife cut the whole apertures.
+func checkUncovered(plan *stencil.Plan) error {
+	st := &plan.Stats
+	if *mode != stencil.ModeDispense || st.Uncovered() <= *maxUncovered {
+		return nil
+	}
+	aps := append([]stencil.Aperture(nil), st.Apertures...)
+	sort.SliceStable(aps, func(i, j int) bool { return aps[i].Area-aps[i].Covered > aps[j].Area-aps[j].Covered })
+	pxArea := plan.PxSize * plan.PxSize
+	var msgs []string
+	for i, a := range aps {
+		if a.Area == a.Covered {
+			break
+		}
+		if i == maxReportedViolations {
+			msgs = append(msgs, fmt.Sprintf("... and %d more", len(aps)-i))
+			break
+		}
+		p := plan.Frame.ToMachine(geom.Pt(float64(a.X)*plan.PxSize, float64(a.Y)*plan.PxSize))
+		msgs = append(msgs, fmt.Sprintf("  aperture %d at X%.3f Y%.3f: %.3f of %.3f mm² uncovered",
+			a.ID, p.X, p.Y, float64(a.Area-a.Covered)*pxArea, float64(a.Area)*pxArea))
+	}
+	return errorf(exitUncovered, "%.1f%% of the aperture area is left uncovered, more than --max_uncovered=%v:\n%s",
+		100*st.Uncovered(), *maxUncovered, strings.Join(msgs, "\n"))
+}
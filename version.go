@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+)
+
+// version is the release of the build, set with -ldflags="-X main.version=v1.2.3". If it's empty,
+// the module version from the build info is used.
+var version string
+
+// buildInfo describes the build of the program.
+type buildInfo struct {
+	Version  string
+	Revision string // the VCS revision, with +dirty if there were local changes
+	Time     string // the VCS commit time
+	Go       string
+}
+
+// readBuildInfo returns the build description, with "unknown" for whatever the build does not record.
+func readBuildInfo() buildInfo {
+	b := buildInfo{Version: version, Revision: "unknown", Time: "unknown", Go: runtime.Version()}
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		if b.Version == "" {
+			b.Version = "unknown"
+		}
+		return b
+	}
+	if b.Version == "" {
+		b.Version = info.Main.Version
+	}
+	dirty := false
+	for _, s := range info.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			b.Revision = s.Value
+		case "vcs.time":
+			b.Time = s.Value
+		case "vcs.modified":
+			dirty = s.Value == "true"
+		}
+	}
+	if dirty {
+		b.Revision += "+dirty"
+	}
+	return b
+}
+
+// String returns the one line description, like png2stencil v1.2.3 (revision 0123abc, go1.22.1).
+func (b buildInfo) String() string {
+	rev := b.Revision
+	if len(rev) > 12 {
+		rev = rev[:12]
+	}
+	return fmt.Sprintf("png2stencil %s (revision %s, %s)", b.Version, rev, b.Go)
+}
+
+// runVersion implements the version subcommand.
+func runVersion() error {
+	b := readBuildInfo()
+	fmt.Printf("png2stencil %s\nrevision: %s\ncommitted: %s\ngo: %s\n", b.Version, b.Revision, b.Time, b.Go)
+	return nil
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// watchInterval is how often the watched files are checked for changes.
+const watchInterval = 500 * time.Millisecond
+
+// watchedFiles returns the files a conversion reads: the input, the config, the tool library,
+// and the machine profile and the material preset, if they are files.
+func watchedFiles() []string {
+	var files []string
+	for _, name := range []string{*input, *inputTop, *inputBottom, *configFile, *toolsFile} {
+		if name != "" {
+			files = append(files, name)
+		}
+	}
+	for _, name := range []string{*machine, *material} {
+		if strings.HasSuffix(name, ".toml") {
+			files = append(files, name)
+		}
+	}
+	return files
+}
+
+// fileStamp is what tells that a file has changed.
+type fileStamp struct {
+	mtime time.Time
+	size  int64
+}
+
+// stamps returns the stamps of the files; a missing file has the zero one.
+func stamps(files []string) []fileStamp {
+	res := make([]fileStamp, len(files))
+	for i, name := range files {
+		if fi, err := os.Stat(name); err == nil {
+			res[i] = fileStamp{fi.ModTime(), fi.Size()}
+		}
+	}
+	return res
+}
+
+func sameStamps(a, b []fileStamp) bool {
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// runWatch implements --watch: it runs the subcommand with the same flags in a child process,
+// and runs it again every time one of the watchedFiles changes, until interrupted. A child
+// re-reads the config, so the changes of the flags in it take effect too. The failed runs
+// are reported, but don't stop the watch.
+func runWatch(cmd string, args []string) error {
+	self, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to find the executable: %w", err)
+	}
+	files := watchedFiles()
+	// The last value of a flag wins, so this one overrides --watch wherever it came from.
+	childArgs := append(append([]string{cmd}, args...), "--watch=false")
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	for run := 0; ; run++ {
+		if run == 1 {
+			// The first run refuses to overwrite the existing outputs; the next ones replace its own.
+			childArgs = append(childArgs, "--force")
+		}
+		last := stamps(files)
+		start := time.Now()
+		c := exec.Command(self, childArgs...)
+		c.Stdout, c.Stderr = os.Stdout, os.Stderr
+		err := c.Run()
+		if ctx.Err() != nil {
+			return nil
+		}
+		if ee, ok := err.(*exec.ExitError); ok {
+			fmt.Fprintf(os.Stderr, "%s: run exited with code %d\n", os.Args[0], ee.ExitCode())
+		} else if err != nil {
+			return fmt.Errorf("failed to run %s: %w", self, err)
+		}
+		fmt.Fprintf(os.Stderr, "%s: done in %v, watching %s for changes; press Ctrl-C to stop\n",
+			os.Args[0], time.Since(start).Round(time.Millisecond), strings.Join(files, ", "))
+
+		// Wait for a change, and then until the files stop changing, so a file being
+		// written is not read half way.
+		for changed := false; ; {
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-time.After(watchInterval):
+			}
+			cur := stamps(files)
+			if sameStamps(cur, last) {
+				if changed {
+					break
+				}
+				continue
+			}
+			changed, last = true, cur
+		}
+	}
+}